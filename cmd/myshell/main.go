@@ -1,8 +1,30 @@
 package main
 
-import "github.com/codecrafters-io/shell-starter-go/internal/shell"
+import (
+	"fmt"
+	"os"
+
+	"github.com/codecrafters-io/shell-starter-go/internal/shell"
+)
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "--render-docs" {
+		format := "man"
+		if len(os.Args) > 2 {
+			format = os.Args[2]
+		}
+		if err := shell.RenderDocs(os.Stdout, format); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		return
+	}
+
 	sh := shell.NewShell()
+	for _, arg := range os.Args[1:] {
+		if arg == "--profile-startup" {
+			sh.ProfileStartup(os.Stderr)
+		}
+	}
 	sh.Run()
 }
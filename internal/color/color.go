@@ -0,0 +1,59 @@
+package color
+
+import (
+	"os"
+	"regexp"
+
+	"golang.org/x/term"
+)
+
+// ** Color **
+// ------------------------------------------------------------------------------------------
+
+// SGR codes used by the shell
+const (
+	Red    = "31"
+	Green  = "32"
+	Yellow = "33"
+	Blue   = "34"
+	Dim    = "2"
+)
+
+// Looks up the environment deciding whether to color, the shell points this at its own variables
+// so export NO_COLOR=1 takes effect without restarting
+var Getenv = os.Getenv
+
+// Whether colored output should be written to f, following the NO_COLOR and CLICOLOR conventions:
+// NO_COLOR (non-empty) disables color, CLICOLOR_FORCE (other than 0) forces it, CLICOLOR=0 disables it,
+// otherwise f must be a terminal
+func Enabled(f *os.File) bool {
+	switch {
+	case Getenv("NO_COLOR") != "":
+		return false
+	case Getenv("CLICOLOR_FORCE") != "" && Getenv("CLICOLOR_FORCE") != "0":
+		return true
+	case Getenv("CLICOLOR") == "0":
+		return false
+	}
+	return term.IsTerminal(int(f.Fd()))
+}
+
+// Wraps text in an SGR sequence when color is enabled for f
+func Paint(f *os.File, code, text string) string {
+	if !Enabled(f) {
+		return text
+	}
+	return Wrap(code, text)
+}
+
+// Wraps text in an SGR sequence unconditionally, for output that is stripped later when color is disabled
+func Wrap(code, text string) string {
+	return "\033[" + code + "m" + text + "\033[0m"
+}
+
+var sgr = regexp.MustCompile("\033\\[[0-9;]*m")
+
+// Removes SGR color sequences from text
+func Strip(text string) string {
+	return sgr.ReplaceAllString(text, "")
+}
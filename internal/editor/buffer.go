@@ -0,0 +1,158 @@
+package editor
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Buffer holds the line being edited as runes, so multibyte characters are inserted and erased as a unit
+type Buffer struct {
+	runes   []rune
+	pending []byte
+}
+
+// Creates an empty Buffer
+func NewBuffer() *Buffer {
+	return &Buffer{runes: []rune{}}
+}
+
+// Feeds a raw input byte into the buffer, returns the decoded rune once a full UTF-8 sequence has arrived
+func (b *Buffer) Feed(c byte) (rune, bool) {
+	b.pending = append(b.pending, c)
+	if !utf8.FullRune(b.pending) {
+		return 0, false
+	}
+	r, _ := utf8.DecodeRune(b.pending)
+	b.pending = b.pending[:0]
+	b.runes = append(b.runes, r)
+	return r, true
+}
+
+// Appends a rune to the end of the buffer
+func (b *Buffer) WriteRune(r rune) {
+	b.runes = append(b.runes, r)
+}
+
+// Appends a string to the end of the buffer
+func (b *Buffer) WriteString(s string) {
+	b.runes = append(b.runes, []rune(s)...)
+}
+
+// Removes the last rune, returns the number of terminal columns it occupied
+func (b *Buffer) Backspace() int {
+	if len(b.runes) == 0 {
+		return 0
+	}
+	last := b.runes[len(b.runes)-1]
+	b.runes = b.runes[:len(b.runes)-1]
+	return RuneWidth(last)
+}
+
+// Empties the buffer, discarding any partially decoded sequence
+func (b *Buffer) Reset() {
+	b.runes = b.runes[:0]
+	b.pending = b.pending[:0]
+}
+
+// Number of runes in the buffer
+func (b *Buffer) Len() int {
+	return len(b.runes)
+}
+
+// Number of terminal columns the buffer occupies
+func (b *Buffer) Width() int {
+	width := 0
+	for _, r := range b.runes {
+		width += RuneWidth(r)
+	}
+	return width
+}
+
+func (b *Buffer) String() string {
+	return string(b.runes)
+}
+
+// The buffer as it is displayed, with control characters made visible
+func (b *Buffer) Visible() string {
+	return Visible(string(b.runes))
+}
+
+// ** Width **
+// ------------------------------------------------------------------------------------------
+
+// East Asian wide and fullwidth ranges, plus the emoji blocks terminals render in two columns
+var wideRanges = [][2]rune{
+	{0x1100, 0x115F},
+	{0x2E80, 0x303E},
+	{0x3041, 0x33FF},
+	{0x3400, 0x4DBF},
+	{0x4E00, 0x9FFF},
+	{0xA000, 0xA4CF},
+	{0xAC00, 0xD7A3},
+	{0xF900, 0xFAFF},
+	{0xFE30, 0xFE4F},
+	{0xFF00, 0xFF60},
+	{0xFFE0, 0xFFE6},
+	{0x1F300, 0x1F64F},
+	{0x1F900, 0x1F9FF},
+	{0x20000, 0x2FFFD},
+	{0x30000, 0x3FFFD},
+}
+
+// Whether a rune is a C0 or C1 control character, which the editor never writes to the terminal as is
+func isControl(r rune) bool {
+	return r < 32 || r == 0x7F || (r >= 0x80 && r < 0xA0)
+}
+
+// Visible form of a rune: control characters in caret notation (^[ for ESC, ^? for DEL, M-^[ for C1 controls),
+// so pasted escape sequences are shown rather than interpreted by the terminal
+func VisibleRune(r rune) string {
+	switch {
+	case r == 0x7F:
+		return "^?"
+	case r < 32:
+		return "^" + string(r+'@')
+	case r >= 0x80 && r < 0xA0:
+		return "M-" + VisibleRune(r-0x80)
+	}
+	return string(r)
+}
+
+// Visible form of a string, see VisibleRune
+func Visible(s string) string {
+	var visible strings.Builder
+	for _, r := range s {
+		visible.WriteString(VisibleRune(r))
+	}
+	return visible.String()
+}
+
+// Number of terminal columns a rune occupies: 0 for combining marks, 2 for wide glyphs, the length of the
+// caret notation for control characters and 1 otherwise
+func RuneWidth(r rune) int {
+	if isControl(r) {
+		return len(VisibleRune(r))
+	}
+	if unicode.In(r, unicode.Mn, unicode.Me, unicode.Cf) {
+		return 0
+	}
+	for _, wide := range wideRanges {
+		if r < wide[0] {
+			break
+		}
+		if r <= wide[1] {
+			return 2
+		}
+	}
+	return 1
+}
+
+// Number of terminal columns a string occupies
+func StringWidth(s string) int {
+	width := 0
+	for _, r := range s {
+		width += RuneWidth(r)
+	}
+	return width
+}
@@ -0,0 +1,199 @@
+package editor
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/term"
+)
+
+// ** Display **
+// ------------------------------------------------------------------------------------------
+
+// Width of the terminal, 80 columns when it can't be read
+func columns() int {
+	if w, _, err := term.GetSize(int(os.Stdout.Fd())); err == nil && w > 2 {
+		return w
+	}
+	return 80
+}
+
+// Where the cursor is: rows below the first row the editor drew, and the column in that row. Lines longer than
+// the terminal wrap, a column equal to the width means the row is exactly full and the terminal holds the cursor
+// on it until the next glyph
+type position struct {
+	row, col int
+}
+
+// Moves past a glyph width columns wide, a wide glyph that doesn't fit moves to the next row
+func (p *position) glyph(width, cols int) {
+	if p.col+width > cols {
+		p.row, p.col = p.row+1, 0
+	}
+	p.col += width
+}
+
+// Moves past a rune of the edited line, which is displayed with VisibleRune: caret notation wraps like plain text
+func (p *position) rune(r rune, cols int) {
+	if isControl(r) {
+		for range len(VisibleRune(r)) {
+			p.glyph(1, cols)
+		}
+		return
+	}
+	p.glyph(RuneWidth(r), cols)
+}
+
+// Moves past text printed as is, like the prompt: escape sequences take no columns
+func (p *position) text(text string, cols int) {
+	for i := 0; i < len(text); {
+		r, size := utf8.DecodeRuneInString(text[i:])
+		i += size
+		switch {
+		case r == '\n':
+			p.row, p.col = p.row+1, 0
+		case r == '\r':
+			p.col = 0
+		case r == '\t':
+			p.col = min(cols, (p.col/8+1)*8)
+		case r == 0x1b:
+			i += escapeLength(text[i:])
+		case isControl(r):
+		default:
+			p.glyph(RuneWidth(r), cols)
+		}
+	}
+}
+
+// Length of the escape sequence following an ESC: CSI sequences run to their final byte and
+// OSC sequences to BEL or ST, anything else is a single character
+func escapeLength(text string) int {
+	if text == "" {
+		return 0
+	}
+	switch text[0] {
+	case '[':
+		for i := 1; i < len(text); i++ {
+			if text[i] >= 0x40 && text[i] <= 0x7e {
+				return i + 1
+			}
+		}
+	case ']':
+		for i := 1; i < len(text); i++ {
+			if text[i] == 0x07 {
+				return i + 1
+			}
+			if strings.HasPrefix(text[i:], "\033\\") {
+				return i + 2
+			}
+		}
+	default:
+		return 1
+	}
+	return len(text)
+}
+
+// Writes the output gathered in the scratch buffer. Once a row is exactly full the cursor is first moved
+// to the next one, so its row no longer depends on whether anything follows
+func (e *Editor) flush() {
+	if e.pos.col >= e.cols {
+		e.scratch = append(e.scratch, "\r\n"...)
+		e.pos = position{row: e.pos.row + 1}
+	}
+	e.out.Write(e.scratch)
+	e.scratch = e.scratch[:0]
+}
+
+// Writes the output after a change to the line, with the hint for it
+func (e *Editor) flushLine() {
+	if e.pos.col >= e.cols {
+		e.scratch = append(e.scratch, "\r\n"...)
+		e.pos = position{row: e.pos.row + 1}
+	}
+	e.appendHint()
+	e.flush()
+}
+
+// Adds the hint for the line to the output, dimmed after the cursor and cut at the end of the row, in place
+// of the one shown before
+func (e *Editor) appendHint() {
+	if e.Hint == nil {
+		return
+	}
+	hint := e.Hint(e.buffer.String())
+	if hint == "" && !e.hinted {
+		return
+	}
+	e.scratch = append(e.scratch, "\033[K"...)
+	e.hinted = false
+	room := e.cols - e.pos.col - 1
+	if hint == "" || room < 2 {
+		return
+	}
+	hint = truncate(Visible(hint), room)
+	e.scratch = append(e.scratch, "\033[2m"...)
+	e.scratch = append(e.scratch, hint...)
+	e.scratch = append(e.scratch, "\033[0m\033["...)
+	e.scratch = strconv.AppendInt(e.scratch, int64(StringWidth(hint)), 10)
+	e.scratch = append(e.scratch, 'D')
+	e.hinted = true
+}
+
+// Erases the hint before the cursor leaves the line
+func (e *Editor) clearHint() {
+	if e.hinted {
+		e.scratch = append(e.scratch, "\033[K"...)
+		e.hinted = false
+		e.flush()
+	}
+}
+
+// Goes back up to the first row the editor drew and clears everything below it, for the rows to be drawn
+// again. The terminal width is read again, it may have been resized
+func (e *Editor) home() {
+	e.cols = columns()
+	if e.pos.row > 0 {
+		e.scratch = append(e.scratch, "\033["...)
+		e.scratch = strconv.AppendInt(e.scratch, int64(e.pos.row), 10)
+		e.scratch = append(e.scratch, 'A')
+	}
+	e.scratch = append(e.scratch, "\r\033[J"...)
+	e.pos = position{}
+	e.hinted = false
+}
+
+// Replaces what the editor has on screen with text
+func (e *Editor) show(text string) {
+	e.home()
+	e.scratch = append(e.scratch, text...)
+	e.pos.text(text, e.cols)
+	e.flush()
+}
+
+// Adds a rune of the line to the output, keeping track of the cursor
+func (e *Editor) echoRune(r rune) {
+	if isControl(r) {
+		e.scratch = append(e.scratch, VisibleRune(r)...)
+	} else {
+		e.scratch = utf8.AppendRune(e.scratch, r)
+	}
+	e.pos.rune(r, e.cols)
+}
+
+// Erases the last width columns of the displayed line once they are gone from the buffer. Backspacing
+// doesn't cross into the row above, so the line is redrawn when the end of it moves up a row
+func (e *Editor) erase(width int) {
+	if width > e.pos.col {
+		e.redraw()
+		return
+	}
+	e.pos.col -= width
+	for _, c := range [...]byte{'\b', ' ', '\b'} {
+		for range width {
+			e.scratch = append(e.scratch, c)
+		}
+	}
+	e.flushLine()
+}
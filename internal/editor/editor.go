@@ -0,0 +1,488 @@
+package editor
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// ErrInterrupted is returned by ReadLine when the line is abandoned with Ctrl+C
+var ErrInterrupted = errors.New("interrupted")
+
+// Bracketed paste mode, the terminal wraps pasted text in pasteStart/pasteEnd so it is never mistaken for typed keys
+const (
+	pasteOn    = "\033[?2004h"
+	pasteOff   = "\033[?2004l"
+	pasteStart = "\033[200~"
+	pasteEnd   = "\033[201~"
+)
+
+// How long a key sequence started with Esc waits for its next byte by default
+const DefaultKeyTimeout = 400 * time.Millisecond
+
+// ** Editor **
+// ------------------------------------------------------------------------------------------
+
+// Editor is the raw-mode line editor, every key press is dispatched through its keymap
+type Editor struct {
+	buffer *Buffer
+	keymap *Keymap
+	prompt string
+	done   bool
+	err    error
+
+	// Terminal output and the bytes being gathered for it, where the cursor is and the terminal width
+	out     io.Writer
+	scratch []byte
+	pos     position
+	cols    int
+
+	// Key sequence read so far
+	seq []byte
+
+	// Vi mode: whether it is in command mode rather than inserting, and the first key of a doubled command
+	command bool
+	pending byte
+
+	// Whether a hint is shown after the line
+	hinted bool
+
+	// Complete lines of a multi-line paste waiting to be returned, and the unfinished last line
+	queue []string
+	carry string
+
+	// History recall: the lines offered, the one shown (-1 for the line being typed) and that typed line
+	history     *History
+	recall      []string
+	recallIndex int
+	draft       string
+
+	// Path of the fzf compatible finder used by the fzf widgets, empty when there is none. lookupFinder finds
+	// it on first use, the widgets run the actions their keys had before when it finds none
+	finder         string
+	lookupFinder   func() string
+	finderFallback map[string]func(*Editor)
+
+	// Completion hook, returns the completed line
+	Complete func(line string) string
+	// Hint shown dimmed after the line as it is typed, empty for none
+	Hint func(line string) string
+	// Runs shell commands bound to keys
+	Execute func(command string)
+	// Quotes a word inserted into the line so the shell reads it back unchanged
+	Quote func(word string) string
+	// Hand the terminal to a child process in cooked mode and take it back in raw mode
+	Cook func()
+	Raw  func()
+	// Edit with vi-style command and insert modes instead of emacs-style keys alone
+	ViMode bool
+	// How long a sequence started with Esc waits for its next byte before the Esc counts as pressed alone,
+	// telling an Esc press from the start of an arrow key. 0 waits for the next byte however long it takes
+	KeyTimeout time.Duration
+}
+
+// Action is a named editor function that key sequences can be bound to
+type Action func(e *Editor)
+
+var actions = map[string]Action{
+	"accept-line":            (*Editor).acceptLine,
+	"backward-delete-char":   (*Editor).backwardDeleteChar,
+	"bracketed-paste-begin":  (*Editor).bracketedPaste,
+	"clear-screen":           (*Editor).clearScreen,
+	"complete":               (*Editor).complete,
+	"end-of-file":            (*Editor).endOfFile,
+	"fzf-cd-widget":          (*Editor).fzfCdWidget,
+	"fzf-file-widget":        (*Editor).fzfFileWidget,
+	"fzf-history-widget":     (*Editor).fzfHistoryWidget,
+	"history-menu":           (*Editor).historyMenu,
+	"interrupt":              (*Editor).interrupt,
+	"next-history":           (*Editor).nextHistory,
+	"previous-history":       (*Editor).previousHistory,
+	"redraw-current-line":    (*Editor).redraw,
+	"reverse-search-history": (*Editor).reverseSearchHistory,
+	"unix-line-discard":      (*Editor).unixLineDiscard,
+	"unix-word-rubout":       (*Editor).unixWordRubout,
+}
+
+// Creates an Editor with the default keymap
+func NewEditor() *Editor {
+	return &Editor{buffer: NewBuffer(), keymap: NewKeymap(), history: NewHistory(), out: os.Stdout, KeyTimeout: DefaultKeyTimeout}
+}
+
+// Keymap of the editor, used to rebind keys
+func (e *Editor) Keymap() *Keymap {
+	return e.keymap
+}
+
+// History recalled by the editor, accepted lines are added by the caller
+func (e *Editor) History() *History {
+	return e.history
+}
+
+// Drops pasted lines still waiting to run, as the terminal drops typeahead when a command is interrupted
+func (e *Editor) Discard() {
+	e.queue = nil
+	e.carry = ""
+}
+
+// Text the next ReadLine starts with, as if it had been typed
+func (e *Editor) Prefill(text string) {
+	e.carry = text
+}
+
+// Lets the user pick a line from history in a menu, see Pick
+func (e *Editor) PickHistory() (string, bool) {
+	return e.Pick("history", e.history.candidates())
+}
+
+// Prints the prompt and reads a line from the terminal, which must already be in raw mode.
+// Returns io.EOF on Ctrl+D with an empty line and ErrInterrupted on Ctrl+C
+func (e *Editor) ReadLine(prompt string) (string, error) {
+	e.prompt = prompt
+	e.buffer.Reset()
+	e.done = false
+	e.err = nil
+	e.recall = nil
+	e.recallIndex = -1
+	e.command, e.pending = false, 0
+	e.pos, e.cols = position{}, columns()
+	e.scratch = append(e.scratch, prompt...)
+	e.pos.text(prompt, e.cols)
+	e.flush()
+
+	// Lines of a multi-line paste run one at a time, as if each had been typed and accepted
+	if len(e.queue) > 0 {
+		line := e.queue[0]
+		e.queue = e.queue[1:]
+		fmt.Print(Visible(line) + "\r\n")
+		return line, nil
+	}
+	if e.carry != "" {
+		e.insertText(e.carry)
+		e.carry = ""
+	}
+
+	fmt.Print(pasteOn)
+	defer fmt.Print(pasteOff)
+
+	e.seq = e.seq[:0]
+	var buf [1]byte
+	for !e.done {
+		var n int
+		var err error
+		if len(e.seq) > 0 && e.seq[0] == 0x1b && e.KeyTimeout > 0 {
+			n, err = readWithin(os.Stdin, buf[:], e.KeyTimeout)
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				e.keyTimeout()
+				continue
+			}
+		} else {
+			n, err = os.Stdin.Read(buf[:])
+		}
+		if err != nil {
+			return "", err
+		}
+		if n > 0 {
+			e.key(buf[0])
+		}
+	}
+	return e.buffer.String(), e.err
+}
+
+// Handles an input byte: once the bytes read form a bound key sequence its binding runs, unbound
+// printable input is inserted. In vi mode an Esc not starting a bound sequence switches to command mode
+func (e *Editor) key(c byte) {
+	if e.command && len(e.seq) == 0 && c != 0x1b {
+		e.viCommand(c)
+		return
+	}
+	e.seq = append(e.seq, c)
+	binding, exact, prefix := e.keymap.lookup(string(e.seq))
+	if !prefix {
+		e.resolve(binding, exact)
+	}
+}
+
+// Ends a key sequence that no byte followed within KeyTimeout: bound as it is, its binding runs, and in vi
+// mode a lone Esc switches to command mode. Anything else is dropped
+func (e *Editor) keyTimeout() {
+	binding, exact, _ := e.keymap.lookup(string(e.seq))
+	e.resolve(binding, exact)
+}
+
+// Acts on a complete key sequence: runs its binding when it has one, switches vi mode to command mode on an
+// Esc starting it, inserts it when printable. The sequence is cleared
+func (e *Editor) resolve(binding Binding, exact bool) {
+	switch {
+	case exact:
+		e.dispatch(binding)
+	case e.ViMode && e.seq[0] == 0x1b:
+		keys := append([]byte{}, e.seq[1:]...)
+		e.seq = e.seq[:0]
+		e.command, e.pending = true, 0
+		for _, c := range keys {
+			e.key(c)
+		}
+		return
+	case e.seq[0] >= 32 && e.seq[0] != 0x7f:
+		for _, c := range e.seq {
+			e.selfInsert(c)
+		}
+	}
+	e.seq = e.seq[:0]
+}
+
+// Runs a binding, command bindings get the terminal line to themselves and the prompt is redrawn afterwards
+func (e *Editor) dispatch(binding Binding) {
+	if binding.Command != "" {
+		e.clearHint()
+		fmt.Print("\r\n")
+		if e.Execute != nil {
+			e.Execute(binding.Command)
+		}
+		e.pos = position{}
+		e.redraw()
+		return
+	}
+	if action, exists := actions[binding.Action]; exists {
+		action(e)
+	}
+}
+
+// ** Actions **
+// ------------------------------------------------------------------------------------------
+
+// Inserts a typed byte, echoing it once a full UTF-8 sequence has arrived
+func (e *Editor) selfInsert(c byte) {
+	if r, ok := e.buffer.Feed(c); ok {
+		e.echoRune(r)
+		e.flushLine()
+	}
+}
+
+// Inserts text typed or pasted in one go, control characters in it are displayed in caret notation
+func (e *Editor) insertText(text string) {
+	e.buffer.WriteString(text)
+	for _, r := range text {
+		e.echoRune(r)
+	}
+	e.flushLine()
+}
+
+func (e *Editor) acceptLine() {
+	e.clearHint()
+	fmt.Print("\r\n")
+	e.done = true
+}
+
+// Reads pasted text up to the end marker. The first line joins the current buffer; with a multi-line paste
+// it is accepted and the remaining complete lines are queued for the following reads, the unfinished last line stays editable.
+// A first line joining text typed before the paste waits for Enter instead, the queued lines run after it
+func (e *Editor) bracketedPaste() {
+	var paste []byte
+	var buf [1]byte
+	for !bytes.HasSuffix(paste, []byte(pasteEnd)) {
+		n, err := os.Stdin.Read(buf[:])
+		if err != nil {
+			break
+		}
+		if n > 0 {
+			paste = append(paste, buf[0])
+		}
+	}
+
+	text := strings.TrimSuffix(string(paste), pasteEnd)
+	text = strings.ReplaceAll(strings.ReplaceAll(text, "\r\n", "\n"), "\r", "\n")
+	lines := strings.Split(text, "\n")
+
+	typed := e.buffer.Len() > 0
+	e.insertText(lines[0])
+	if len(lines) == 1 {
+		return
+	}
+	e.queue = append(e.queue, lines[1:len(lines)-1]...)
+	e.carry = lines[len(lines)-1]
+	if !typed {
+		e.acceptLine()
+	}
+}
+
+func (e *Editor) backwardDeleteChar() {
+	if e.buffer.Len() > 0 {
+		e.erase(e.buffer.Backspace())
+	}
+}
+
+func (e *Editor) clearScreen() {
+	fmt.Print("\033[H\033[2J")
+	e.pos = position{}
+	e.redraw()
+}
+
+func (e *Editor) complete() {
+	if e.Complete == nil {
+		return
+	}
+	line := e.buffer.String()
+	if completed := e.Complete(line); completed != line {
+		e.buffer.Reset()
+		e.buffer.WriteString(completed)
+		e.redraw()
+	}
+}
+
+func (e *Editor) endOfFile() {
+	if e.buffer.Len() == 0 {
+		e.done = true
+		e.err = io.EOF
+	}
+}
+
+// Picks a line from history in a menu and puts it in the edit buffer
+func (e *Editor) historyMenu() {
+	if line, ok := e.PickHistory(); ok {
+		e.setLine(line)
+		return
+	}
+	e.redraw()
+}
+
+func (e *Editor) interrupt() {
+	e.clearHint()
+	fmt.Print("\r\n^C\r\n")
+	e.Discard()
+	e.buffer.Reset()
+	e.done = true
+	e.err = ErrInterrupted
+}
+
+// Replaces the whole line being edited
+func (e *Editor) setLine(line string) {
+	e.buffer.Reset()
+	e.buffer.WriteString(line)
+	e.redraw()
+}
+
+// Shows the previous (older) history line, the line being typed is kept to come back to
+func (e *Editor) previousHistory() {
+	if e.recall == nil {
+		e.recall = e.history.candidates()
+	}
+	if e.recallIndex+1 >= len(e.recall) {
+		return
+	}
+	if e.recallIndex == -1 {
+		e.draft = e.buffer.String()
+	}
+	e.recallIndex++
+	e.setLine(e.recall[e.recallIndex])
+}
+
+// Shows the next (newer) history line, past the newest one the typed line comes back
+func (e *Editor) nextHistory() {
+	if e.recallIndex == -1 {
+		return
+	}
+	e.recallIndex--
+	if e.recallIndex == -1 {
+		e.setLine(e.draft)
+		return
+	}
+	e.setLine(e.recall[e.recallIndex])
+}
+
+// Incremental search backwards through history. Ctrl+R finds the next older match, Enter runs the match,
+// Esc keeps it for editing and Ctrl+G or Ctrl+C restores the original line
+func (e *Editor) reverseSearchHistory() {
+	candidates := e.history.candidates()
+	original := e.buffer.String()
+	query := NewBuffer()
+	match := -1
+
+	find := func(from int) {
+		for i := from; i < len(candidates); i++ {
+			if strings.Contains(candidates[i], query.String()) {
+				match = i
+				return
+			}
+		}
+	}
+	current := func() string {
+		if match == -1 {
+			return ""
+		}
+		return candidates[match]
+	}
+	draw := func() {
+		e.show("(reverse-i-search)`" + query.Visible() + "': " + Visible(current()))
+	}
+
+	draw()
+	var buf [1]byte
+	for {
+		n, err := os.Stdin.Read(buf[:])
+		if err != nil {
+			return
+		}
+		if n == 0 {
+			continue
+		}
+
+		switch c := buf[0]; c {
+		case 0x12: // Ctrl+R
+			find(match + 1)
+		case 0x7f, '\b':
+			query.Backspace()
+			match = -1
+			find(0)
+		case 0x07, 0x03: // Ctrl+G, Ctrl+C
+			e.setLine(original)
+			return
+		case '\r', '\n':
+			e.setLine(current())
+			e.acceptLine()
+			return
+		case 0x1b:
+			e.setLine(current())
+			return
+		default:
+			if c < 32 {
+				continue
+			}
+			if _, ok := query.Feed(c); ok {
+				find(max(match, 0))
+			}
+		}
+		draw()
+	}
+}
+
+// Reprints the prompt and the line over the rows they were drawn on
+func (e *Editor) redraw() {
+	e.home()
+	e.scratch = append(e.scratch, e.prompt...)
+	e.pos.text(e.prompt, e.cols)
+	for _, r := range e.buffer.runes {
+		e.echoRune(r)
+	}
+	e.flushLine()
+}
+
+func (e *Editor) unixLineDiscard() {
+	width := e.buffer.Width()
+	e.buffer.Reset()
+	e.erase(width)
+}
+
+func (e *Editor) unixWordRubout() {
+	line := e.buffer.String()
+	trimmed := strings.TrimRight(line, " ")
+	kept := trimmed[:strings.LastIndex(trimmed, " ")+1]
+	e.buffer.Reset()
+	e.buffer.WriteString(kept)
+	e.erase(StringWidth(line) - StringWidth(kept))
+}
@@ -0,0 +1,77 @@
+package editor
+
+import (
+	"io"
+	"testing"
+)
+
+// Editor drawing on a discarded terminal 80 columns wide, as ReadLine leaves it after printing the prompt
+func benchEditor() *Editor {
+	e := NewEditor()
+	e.out = io.Discard
+	e.prompt = "user@host:~/src$ "
+	e.cols = 80
+	e.pos.text(e.prompt, e.cols)
+	return e
+}
+
+// Starts the line over once it gets long, so the benchmarks measure typing rather than an ever growing line
+func (e *Editor) restart() {
+	if e.buffer.Len() > 500 {
+		e.buffer.Reset()
+		e.pos = position{}
+		e.pos.text(e.prompt, e.cols)
+	}
+}
+
+func BenchmarkSelfInsert(b *testing.B) {
+	e := benchEditor()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		e.key('a' + byte(i%26))
+		e.restart()
+	}
+}
+
+func BenchmarkSelfInsertMultibyte(b *testing.B) {
+	e := benchEditor()
+	text := []byte("日本語")
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		e.key(text[i%len(text)])
+		e.restart()
+	}
+}
+
+func BenchmarkBackspace(b *testing.B) {
+	e := benchEditor()
+	for range 100 {
+		e.key('x')
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		e.key('x')
+		e.key(0x7f)
+	}
+}
+
+// An unbound arrow key goes through the keymap prefix lookups byte by byte
+func BenchmarkEscapeSequence(b *testing.B) {
+	e := benchEditor()
+	seq := []byte("\x1b[C")
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		e.key(seq[i%len(seq)])
+	}
+}
+
+func BenchmarkRedrawWrapped(b *testing.B) {
+	e := benchEditor()
+	for range 300 {
+		e.key('x')
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		e.redraw()
+	}
+}
@@ -0,0 +1,161 @@
+package editor
+
+import (
+	"bufio"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// ** Fuzzy Finder **
+// ------------------------------------------------------------------------------------------
+
+// Uses an fzf compatible fuzzy finder for Ctrl+T (insert a file), Alt+C (cd into a directory) and Ctrl+R (history),
+// these keys are rebound to the finder widgets. lookup returns the finder's path, empty when there is none; it
+// runs the first time a widget is used, which then falls back to what its key did before
+func (e *Editor) EnableFinder(lookup func() string) {
+	e.lookupFinder = lookup
+	e.finderFallback = make(map[string]func(*Editor))
+	for seq, widget := range map[string]string{"\x14": "fzf-file-widget", "\x1bc": "fzf-cd-widget", "\x12": "fzf-history-widget"} {
+		if binding, exists := e.keymap.Get(seq); exists && actions[binding.Action] != nil {
+			e.finderFallback[widget] = actions[binding.Action]
+		}
+		e.keymap.Bind(seq, widget)
+	}
+}
+
+// Whether a finder is available, looking it up on first use. Without one the action the widget's key had
+// before EnableFinder runs instead, and false is returned
+func (e *Editor) hasFinder(widget string) bool {
+	if e.lookupFinder != nil {
+		e.finder = e.lookupFinder()
+		e.lookupFinder = nil
+	}
+	if e.finder != "" {
+		return true
+	}
+	if action, exists := e.finderFallback[widget]; exists {
+		action(e)
+	} else {
+		e.redraw()
+	}
+	return false
+}
+
+// Runs the finder over the candidates written by feed and returns the selected line. The terminal is handed
+// to the finder in cooked mode and taken back afterwards, the finder draws on the tty itself and the caller redraws the line
+func (e *Editor) find(feed func(w io.Writer) error, args ...string) (string, bool) {
+	if e.finder == "" {
+		return "", false
+	}
+
+	cmd := exec.Command(e.finder, append([]string{"--height=40%", "--reverse"}, args...)...)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return "", false
+	}
+	var out strings.Builder
+	cmd.Stdout = &out
+
+	if e.Cook != nil {
+		e.Cook()
+	}
+	defer func() {
+		if e.Raw != nil {
+			e.Raw()
+		}
+	}()
+
+	if err := cmd.Start(); err != nil {
+		return "", false
+	}
+	go func() {
+		w := bufio.NewWriter(stdin)
+		// A write error means the finder exited, there is nothing left to feed
+		if feed(w) == nil {
+			w.Flush()
+		}
+		stdin.Close()
+	}()
+	if err := cmd.Wait(); err != nil {
+		return "", false
+	}
+
+	selection := strings.TrimRight(out.String(), "\r\n")
+	return selection, selection != ""
+}
+
+// Files (or only directories) under the current directory, hidden ones left out
+func walk(dirsOnly bool) func(w io.Writer) error {
+	return func(w io.Writer) error {
+		return filepath.WalkDir(".", func(path string, entry fs.DirEntry, err error) error {
+			if err != nil || path == "." {
+				return nil
+			}
+			if strings.HasPrefix(entry.Name(), ".") {
+				if entry.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if dirsOnly && !entry.IsDir() {
+				return nil
+			}
+			_, err = io.WriteString(w, path+"\n")
+			return err
+		})
+	}
+}
+
+// Inserts a file chosen in the finder
+func (e *Editor) fzfFileWidget() {
+	if !e.hasFinder("fzf-file-widget") {
+		return
+	}
+	if path, ok := e.find(walk(false)); ok {
+		if e.Quote != nil {
+			path = e.Quote(path)
+		}
+		e.buffer.WriteString(path)
+	}
+	e.redraw()
+}
+
+// Changes into a directory chosen in the finder
+func (e *Editor) fzfCdWidget() {
+	if !e.hasFinder("fzf-cd-widget") {
+		return
+	}
+	dir, ok := e.find(walk(true))
+	if ok && e.Execute != nil {
+		if e.Quote != nil {
+			dir = e.Quote(dir)
+		}
+		e.Execute("cd " + dir)
+	}
+	e.redraw()
+}
+
+// Puts a history line chosen in the finder in the edit buffer, the typed line is the initial query
+func (e *Editor) fzfHistoryWidget() {
+	if !e.hasFinder("fzf-history-widget") {
+		return
+	}
+	feed := func(w io.Writer) error {
+		for _, line := range e.history.candidates() {
+			if _, err := io.WriteString(w, line+"\n"); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if line, ok := e.find(feed, "--query="+e.buffer.String()); ok {
+		e.buffer.Reset()
+		e.buffer.WriteString(line)
+	}
+	e.redraw()
+}
@@ -0,0 +1,139 @@
+package editor
+
+import "time"
+
+// ** History **
+// ------------------------------------------------------------------------------------------
+
+// A command line together with the directory it was run in and when, then how it ended. Dir and Time are zero
+// for entries loaded without them, Status and Duration are only known once Finished
+type HistoryEntry struct {
+	Line     string
+	Dir      string
+	Time     time.Time
+	Status   int
+	Duration time.Duration
+	Finished bool
+}
+
+// Which entries recall (↑/↓, Ctrl+R) offers relative to the current directory
+type HistoryScope int
+
+const (
+	ScopeAll      HistoryScope = iota // every entry, newest first
+	ScopePrefer                       // entries run in the current directory first, then the rest
+	ScopeRestrict                     // only entries run in the current directory
+)
+
+// Entries kept when no limit is set
+const DefaultHistorySize = 500
+
+// History of accepted lines, a ring keeping the newest limit entries
+type History struct {
+	entries []HistoryEntry
+	limit   int
+	dir     string
+	scope   HistoryScope
+}
+
+// Creates an empty History
+func NewHistory() *History {
+	return &History{entries: []HistoryEntry{}, limit: DefaultHistorySize}
+}
+
+// Appends a line run in dir, consecutive duplicates from the same directory are stored once.
+// The oldest entry is dropped once the history is full. Returns the entry, false for a duplicate
+func (h *History) Add(line, dir string) (HistoryEntry, bool) {
+	if n := len(h.entries); n > 0 && h.entries[n-1].Line == line && h.entries[n-1].Dir == dir {
+		return HistoryEntry{}, false
+	}
+	entry := HistoryEntry{Line: line, Dir: dir, Time: time.Now()}
+	h.entries = append(h.entries, entry)
+	h.trim()
+	return entry, true
+}
+
+// Records how a line ended on the newest entry with its line and time
+func (h *History) Finish(entry HistoryEntry) {
+	for i := len(h.entries) - 1; i >= 0; i-- {
+		if h.entries[i].Line == entry.Line && h.entries[i].Time.Equal(entry.Time) {
+			h.entries[i].Status, h.entries[i].Duration, h.entries[i].Finished = entry.Status, entry.Duration, true
+			return
+		}
+	}
+}
+
+// Removes every entry
+func (h *History) Clear() {
+	h.entries = []HistoryEntry{}
+}
+
+// Removes the entries from index from to index to (oldest first), both included, false when the range isn't in the history
+func (h *History) DeleteRange(from, to int) bool {
+	if from < 0 || to >= len(h.entries) || from > to {
+		return false
+	}
+	h.entries = append(h.entries[:from:from], h.entries[to+1:]...)
+	return true
+}
+
+// Puts entries from an earlier session before the current ones
+func (h *History) Load(entries []HistoryEntry) {
+	h.entries = append(append([]HistoryEntry{}, entries...), h.entries...)
+	h.trim()
+}
+
+// Sets how many entries are kept, dropping the oldest ones beyond it. A negative limit keeps every entry
+func (h *History) SetLimit(limit int) {
+	h.limit = limit
+	h.trim()
+}
+
+func (h *History) trim() {
+	if h.limit >= 0 && len(h.entries) > h.limit {
+		h.entries = append([]HistoryEntry{}, h.entries[len(h.entries)-h.limit:]...)
+	}
+}
+
+// Sets the current directory and how recall treats entries from other directories
+func (h *History) SetScope(dir string, scope HistoryScope) {
+	h.dir = dir
+	h.scope = scope
+}
+
+// All entries, oldest first
+func (h *History) Entries() []HistoryEntry {
+	return h.entries
+}
+
+// Lines offered by recall in the current scope, newest first and without repeating a line
+func (h *History) candidates() []string {
+	lines := []string{}
+	seen := make(map[string]bool)
+	collect := func(local bool) {
+		for i := len(h.entries) - 1; i >= 0; i-- {
+			entry := h.entries[i]
+			if (entry.Dir == h.dir) != local || seen[entry.Line] {
+				continue
+			}
+			seen[entry.Line] = true
+			lines = append(lines, entry.Line)
+		}
+	}
+
+	switch h.scope {
+	case ScopePrefer:
+		collect(true)
+		collect(false)
+	case ScopeRestrict:
+		collect(true)
+	default:
+		for i := len(h.entries) - 1; i >= 0; i-- {
+			if line := h.entries[i].Line; !seen[line] {
+				seen[line] = true
+				lines = append(lines, line)
+			}
+		}
+	}
+	return lines
+}
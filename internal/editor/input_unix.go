@@ -0,0 +1,32 @@
+//go:build !windows
+
+package editor
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// Reads from file, failing with os.ErrDeadlineExceeded when nothing arrives within timeout. The read goes
+// through a nonblocking duplicate for the runtime poller to cut it short, file is left in blocking mode
+func readWithin(file *os.File, buf []byte, timeout time.Duration) (int, error) {
+	// The duplicate is marked close-on-exec before a command can be started with it
+	syscall.ForkLock.RLock()
+	fd, err := syscall.Dup(int(file.Fd()))
+	if err == nil {
+		syscall.CloseOnExec(fd)
+	}
+	syscall.ForkLock.RUnlock()
+	if err != nil {
+		return file.Read(buf)
+	}
+	syscall.SetNonblock(fd, true)
+	dup := os.NewFile(uintptr(fd), file.Name())
+	defer func() {
+		syscall.SetNonblock(fd, false)
+		dup.Close()
+	}()
+	dup.SetReadDeadline(time.Now().Add(timeout))
+	return dup.Read(buf)
+}
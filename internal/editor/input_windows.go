@@ -0,0 +1,13 @@
+//go:build windows
+
+package editor
+
+import (
+	"os"
+	"time"
+)
+
+// Reads from file, the console can't time reads out so this waits for the next byte however long it takes
+func readWithin(file *os.File, buf []byte, timeout time.Duration) (int, error) {
+	return file.Read(buf)
+}
@@ -0,0 +1,199 @@
+package editor
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ** Keymap **
+// ------------------------------------------------------------------------------------------
+
+// A key binding runs either a named editor action or a shell command
+type Binding struct {
+	Action  string
+	Command string
+}
+
+// Keymap maps raw key sequences to bindings. prefixes counts the bound sequences each shorter sequence
+// starts, so a key read doesn't have to scan every binding
+type Keymap struct {
+	bindings map[string]Binding
+	prefixes map[string]int
+}
+
+// Creates a Keymap with the default emacs-style bindings
+func NewKeymap() *Keymap {
+	k := &Keymap{bindings: make(map[string]Binding), prefixes: make(map[string]int)}
+	defaults := map[string]string{
+		"\r":       "accept-line",
+		"\n":       "accept-line",
+		"\t":       "complete",
+		"\x7f":     "backward-delete-char",
+		"\b":       "backward-delete-char",
+		"\x03":     "interrupt",
+		"\x04":     "end-of-file",
+		"\x0c":     "clear-screen",
+		"\x15":     "unix-line-discard",
+		"\x17":     "unix-word-rubout",
+		"\x12":     "reverse-search-history",
+		"\x1b[A":   "previous-history",
+		"\x1b[B":   "next-history",
+		"\x1bOA":   "previous-history",
+		"\x1bOB":   "next-history",
+		pasteStart: "bracketed-paste-begin",
+	}
+	for seq, action := range defaults {
+		k.set(seq, Binding{Action: action})
+	}
+	return k
+}
+
+// Binds a key sequence, keeping the prefix counts
+func (k *Keymap) set(seq string, binding Binding) {
+	if _, exists := k.bindings[seq]; !exists {
+		for i := 1; i < len(seq); i++ {
+			k.prefixes[seq[:i]]++
+		}
+	}
+	k.bindings[seq] = binding
+}
+
+// Binds a key sequence to a named editor action
+func (k *Keymap) Bind(seq, action string) error {
+	if _, exists := actions[action]; !exists {
+		return fmt.Errorf("%s: unknown function name", action)
+	}
+	k.set(seq, Binding{Action: action})
+	return nil
+}
+
+// Binds a key sequence to a shell command
+func (k *Keymap) BindCommand(seq, command string) {
+	k.set(seq, Binding{Command: command})
+}
+
+// Removes the binding of a key sequence
+func (k *Keymap) Unbind(seq string) {
+	if _, exists := k.bindings[seq]; !exists {
+		return
+	}
+	delete(k.bindings, seq)
+	for i := 1; i < len(seq); i++ {
+		if k.prefixes[seq[:i]]--; k.prefixes[seq[:i]] == 0 {
+			delete(k.prefixes, seq[:i])
+		}
+	}
+}
+
+// Bound key sequences, sorted
+func (k *Keymap) Sequences() []string {
+	seqs := []string{}
+	for seq := range k.bindings {
+		seqs = append(seqs, seq)
+	}
+	sort.Strings(seqs)
+	return seqs
+}
+
+// Binding of a key sequence
+func (k *Keymap) Get(seq string) (Binding, bool) {
+	binding, exists := k.bindings[seq]
+	return binding, exists
+}
+
+// Looks up a partially read key sequence, reports whether it is bound and whether longer bound sequences start with it
+func (k *Keymap) lookup(seq string) (binding Binding, exact bool, prefix bool) {
+	binding, exact = k.bindings[seq]
+	return binding, exact, k.prefixes[seq] > 0
+}
+
+// Names of the editor actions that can be bound, sorted
+func ActionNames() []string {
+	names := []string{}
+	for name := range actions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ** Key Sequences **
+// ------------------------------------------------------------------------------------------
+
+// Parses readline key sequence notation into raw bytes: \C-x (control), \M-x (meta, sent as ESC x),
+// \e, \t, \n, \r, \\, \", \' and ^X
+func ParseKeyseq(notation string) (string, error) {
+	var seq strings.Builder
+	for i := 0; i < len(notation); i++ {
+		c := notation[i]
+		switch {
+		case c == '^' && i+1 < len(notation):
+			i++
+			seq.WriteByte(control(notation[i]))
+		case c != '\\':
+			seq.WriteByte(c)
+		case i+1 >= len(notation):
+			return "", fmt.Errorf("%s: trailing backslash in key sequence", notation)
+		case strings.HasPrefix(notation[i:], "\\C-") && i+3 < len(notation):
+			seq.WriteByte(control(notation[i+3]))
+			i += 3
+		case strings.HasPrefix(notation[i:], "\\M-") && i+3 < len(notation):
+			seq.WriteByte(0x1b)
+			i += 2
+		default:
+			i++
+			switch notation[i] {
+			case 'e', 'E':
+				seq.WriteByte(0x1b)
+			case 't':
+				seq.WriteByte('\t')
+			case 'n':
+				seq.WriteByte('\n')
+			case 'r':
+				seq.WriteByte('\r')
+			case 'a':
+				seq.WriteByte('\a')
+			case 'd':
+				seq.WriteByte(0x7f)
+			default:
+				seq.WriteByte(notation[i])
+			}
+		}
+	}
+	if seq.Len() == 0 {
+		return "", fmt.Errorf("empty key sequence")
+	}
+	return seq.String(), nil
+}
+
+// Formats raw bytes in readline key sequence notation
+func FormatKeyseq(seq string) string {
+	var notation strings.Builder
+	for i := 0; i < len(seq); i++ {
+		switch c := seq[i]; {
+		case c == 0x1b:
+			notation.WriteString("\\e")
+		case c == 0x7f:
+			notation.WriteString("\\C-?")
+		case c < 32:
+			notation.WriteString("\\C-" + string(rune(c+'a'-1)))
+		case c == '\\' || c == '"':
+			notation.WriteString("\\" + string(rune(c)))
+		default:
+			notation.WriteByte(c)
+		}
+	}
+	return notation.String()
+}
+
+// Control character for a letter, \C-? is DEL
+func control(c byte) byte {
+	if c == '?' {
+		return 0x7f
+	}
+	if c >= 'a' && c <= 'z' {
+		c -= 'a' - 'A'
+	}
+	return c & 0x1f
+}
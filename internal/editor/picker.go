@@ -0,0 +1,151 @@
+package editor
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ** Picker **
+// ------------------------------------------------------------------------------------------
+
+// Rows of items the picker shows at once
+const pickerRows = 10
+
+// Lets the user choose one of items in a menu drawn below the current line: typing filters the items by
+// substring, ↑/↓ (Ctrl+P/Ctrl+N) and PgUp/PgDn move the selection, Enter picks it and Ctrl+G or Ctrl+C cancels.
+// The terminal must be in raw mode, the menu is erased before returning
+func (e *Editor) Pick(title string, items []string) (string, bool) {
+	query := NewBuffer()
+	filtered := items
+	selected, top := 0, 0
+
+	width := columns()
+
+	filter := func() {
+		filtered = []string{}
+		for _, item := range items {
+			if strings.Contains(item, query.String()) {
+				filtered = append(filtered, item)
+			}
+		}
+		selected, top = 0, 0
+	}
+	move := func(delta int) {
+		selected = max(0, min(len(filtered)-1, selected+delta))
+		if selected < top {
+			top = selected
+		} else if selected >= top+pickerRows {
+			top = selected - pickerRows + 1
+		}
+	}
+	draw := func() {
+		header := fmt.Sprintf("%s (%d/%d)> %s", title, len(filtered), len(items), query.Visible())
+		var menu strings.Builder
+		menu.WriteString("\r\033[J" + header)
+		rows := 0
+		for i := top; i < len(filtered) && i < top+pickerRows; i++ {
+			marker := "  "
+			if i == selected {
+				marker = "> "
+			}
+			menu.WriteString("\r\n" + marker + truncate(Visible(filtered[i]), width-len(marker)-1))
+			rows++
+		}
+		if rows > 0 {
+			fmt.Fprintf(&menu, "\033[%dA", rows)
+		}
+		fmt.Fprintf(&menu, "\r\033[%dC", StringWidth(header))
+		fmt.Print(menu.String())
+	}
+	defer fmt.Print("\r\033[J")
+
+	draw()
+	var buf [1]byte
+	for {
+		n, err := os.Stdin.Read(buf[:])
+		if err != nil {
+			return "", false
+		}
+		if n == 0 {
+			continue
+		}
+
+		switch c := buf[0]; c {
+		case '\r', '\n':
+			if len(filtered) == 0 {
+				return "", false
+			}
+			return filtered[selected], true
+		case 0x07, 0x03: // Ctrl+G, Ctrl+C
+			return "", false
+		case 0x10: // Ctrl+P
+			move(-1)
+		case 0x0e: // Ctrl+N
+			move(1)
+		case 0x7f, '\b':
+			query.Backspace()
+			filter()
+		case 0x1b:
+			switch readSequence() {
+			case "[A", "OA":
+				move(-1)
+			case "[B", "OB":
+				move(1)
+			case "[5~":
+				move(-pickerRows)
+			case "[6~":
+				move(pickerRows)
+			case "":
+				return "", false
+			}
+		default:
+			if c < 32 {
+				continue
+			}
+			if _, ok := query.Feed(c); ok {
+				filter()
+			}
+		}
+		draw()
+	}
+}
+
+// Reads the rest of an escape sequence after ESC: '[' or 'O' and the bytes up to the final letter or '~'.
+// Returns "" when ESC wasn't followed by a sequence
+func readSequence() string {
+	var seq []byte
+	var buf [1]byte
+	for {
+		if n, err := os.Stdin.Read(buf[:]); err != nil || n == 0 {
+			return ""
+		}
+		seq = append(seq, buf[0])
+		if len(seq) == 1 {
+			if buf[0] != '[' && buf[0] != 'O' {
+				return ""
+			}
+			continue
+		}
+		if buf[0] == '~' || buf[0] >= 'A' && buf[0] <= 'Z' || buf[0] >= 'a' && buf[0] <= 'z' {
+			return string(seq)
+		}
+	}
+}
+
+// Cuts text to fit in width columns, marking the cut with '…'
+func truncate(text string, width int) string {
+	if StringWidth(text) <= width {
+		return text
+	}
+	var cut strings.Builder
+	used := 0
+	for _, r := range text {
+		if used+RuneWidth(r) > width-1 {
+			break
+		}
+		cut.WriteRune(r)
+		used += RuneWidth(r)
+	}
+	return cut.String() + "…"
+}
@@ -0,0 +1,46 @@
+package editor
+
+// ** Vi Mode **
+// ------------------------------------------------------------------------------------------
+
+// In vi mode keys insert text as in emacs mode until Esc switches to command mode, where single keys move
+// through history and edit the line. The editor keeps no cursor inside the line, commands act on its end
+func (e *Editor) viCommand(c byte) {
+	if pending := e.pending; pending != 0 {
+		e.pending = 0
+		if c == pending {
+			e.unixLineDiscard()
+			e.command = pending == 'd'
+		}
+		return
+	}
+
+	switch c {
+	case 'i', 'a', 'I', 'A':
+		e.command = false
+	case 'd', 'c':
+		e.pending = c
+	case 'S':
+		e.unixLineDiscard()
+		e.command = false
+	case 'x', 'X':
+		e.backwardDeleteChar()
+	case 'k', '-':
+		e.previousHistory()
+	case 'j', '+':
+		e.nextHistory()
+	case '/', '?':
+		e.reverseSearchHistory()
+	case '#':
+		e.setLine("#" + e.buffer.String())
+		e.acceptLine()
+	case '\r', '\n':
+		e.acceptLine()
+	case 0x03:
+		e.interrupt()
+	case 0x04:
+		e.endOfFile()
+	case 0x0c:
+		e.clearScreen()
+	}
+}
@@ -0,0 +1,114 @@
+package shell
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/codecrafters-io/shell-starter-go/internal/color"
+)
+
+// ** Aliases **
+// ------------------------------------------------------------------------------------------
+
+// Shell builtin alias, defines command aliases expanded when they are the first word of a command, or prints them
+// (all of them without arguments or with -p). A value ending with a space makes the word after it alias-expanded too, e.g. alias sudo='sudo '
+func (s *Shell) alias(args []string, std Streams) error {
+	if len(args) > 0 && args[0] == "-p" {
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		names := []string{}
+		for name := range s.cmdAlias {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintln(std.stdout, formatAlias(name, s.cmdAlias[name]))
+		}
+	}
+
+	for _, arg := range args {
+		name, value, assign := strings.Cut(arg, "=")
+		if !assign {
+			value, exists := s.cmdAlias[name]
+			if !exists {
+				return fmt.Errorf("alias: %s: not found", name)
+			}
+			fmt.Fprintln(std.stdout, formatAlias(name, value))
+			continue
+		}
+		if name == "" || strings.ContainsAny(name, " \t'\"\\$&>/") {
+			return fmt.Errorf("alias: '%s': invalid alias name", name)
+		}
+		s.cmdAlias[name] = value
+	}
+
+	return nil
+}
+
+// Shell builtin unalias, removes the named aliases, or every alias with -a
+func (s *Shell) unalias(args []string, std Streams) error {
+	if len(args) == 0 {
+		return fmt.Errorf("unalias: usage: unalias [-a] name [name ...]")
+	}
+	if args[0] == "-a" {
+		s.cmdAlias = make(map[string]string)
+		return nil
+	}
+
+	var err error
+	for _, name := range args {
+		if _, exists := s.cmdAlias[name]; !exists {
+			err = fmt.Errorf("unalias: %s: not found", name)
+			continue
+		}
+		delete(s.cmdAlias, name)
+	}
+	return err
+}
+
+// Aliases interactive shells start with, scripts get none
+var defaultAliases = map[string]string{
+	"ll": "ls -l",
+	"la": "ls -A",
+}
+
+// Formats an alias as the alias command that defines it
+func formatAlias(name, value string) string {
+	return fmt.Sprintf("alias %s='%s'", name, strings.ReplaceAll(value, "'", `'\''`))
+}
+
+// Line editor hint previewing the command that runs when the first word typed is an alias: the line with
+// the alias expanded, following aliases whose value starts with another one
+func (s *Shell) aliasHint(line string) string {
+	if !s.options.Get("alias_preview") || !color.Enabled(os.Stdout) {
+		return ""
+	}
+	rest := strings.TrimLeft(line, " \t")
+	end := strings.IndexAny(rest, " \t;|&<>")
+	if end == -1 {
+		end = len(rest)
+	}
+	word := rest[:end]
+	if _, exists := s.cmdAlias[word]; !exists || strings.ContainsAny(word, "'\"\\") {
+		return ""
+	}
+
+	seen := make(map[string]bool)
+	for {
+		value, exists := s.cmdAlias[word]
+		if !exists || seen[word] {
+			break
+		}
+		seen[word] = true
+		rest = value + rest[len(word):]
+		end = strings.IndexAny(rest, " \t;|&<>")
+		if end == -1 {
+			end = len(rest)
+		}
+		word = rest[:end]
+	}
+	return "  → " + rest
+}
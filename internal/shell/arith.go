@@ -0,0 +1,150 @@
+package shell
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// ** Arithmetic **
+// ------------------------------------------------------------------------------------------
+
+// Arithmetic evaluator for integer variables, supports + - * / % with the usual precedence, unary minus,
+// parentheses, integer literals and variable names (unset or non-numeric variables count as 0)
+type arithParser struct {
+	s     *Shell
+	input string
+	pos   int
+}
+
+// Evaluates an integer arithmetic expression
+func (s *Shell) evalArithmetic(expr string) (int, error) {
+	p := &arithParser{s: s, input: expr}
+	value, err := p.expr()
+	if err != nil {
+		return 0, err
+	}
+	p.skipSpaces()
+	if p.pos < len(p.input) {
+		return 0, fmt.Errorf("%s: syntax error in expression (error token is \"%s\")", expr, p.input[p.pos:])
+	}
+	return value, nil
+}
+
+func (p *arithParser) skipSpaces() {
+	for p.pos < len(p.input) && p.input[p.pos] == ' ' {
+		p.pos++
+	}
+}
+
+// expr := term (('+' | '-') term)*
+func (p *arithParser) expr() (int, error) {
+	left, err := p.term()
+	if err != nil {
+		return 0, err
+	}
+	for {
+		p.skipSpaces()
+		if p.pos >= len(p.input) || (p.input[p.pos] != '+' && p.input[p.pos] != '-') {
+			return left, nil
+		}
+		op := p.input[p.pos]
+		p.pos++
+		right, err := p.term()
+		if err != nil {
+			return 0, err
+		}
+		if op == '+' {
+			left += right
+		} else {
+			left -= right
+		}
+	}
+}
+
+// term := factor (('*' | '/' | '%') factor)*
+func (p *arithParser) term() (int, error) {
+	left, err := p.factor()
+	if err != nil {
+		return 0, err
+	}
+	for {
+		p.skipSpaces()
+		if p.pos >= len(p.input) || !strings.ContainsRune("*/%", rune(p.input[p.pos])) {
+			return left, nil
+		}
+		op := p.input[p.pos]
+		p.pos++
+		right, err := p.factor()
+		if err != nil {
+			return 0, err
+		}
+		switch op {
+		case '*':
+			left *= right
+		case '/', '%':
+			if right == 0 {
+				return 0, fmt.Errorf("%s: division by 0", p.input)
+			}
+			if op == '/' {
+				left /= right
+			} else {
+				left %= right
+			}
+		}
+	}
+}
+
+// factor := '-' factor | '+' factor | '(' expr ')' | number | name | '$' name
+func (p *arithParser) factor() (int, error) {
+	p.skipSpaces()
+	if p.pos >= len(p.input) {
+		return 0, fmt.Errorf("%s: syntax error: operand expected", p.input)
+	}
+	if p.input[p.pos] == '$' && p.pos+1 < len(p.input) && !unicode.IsDigit(rune(p.input[p.pos+1])) {
+		p.pos++
+	}
+
+	switch c := p.input[p.pos]; {
+	case c == '-' || c == '+':
+		p.pos++
+		value, err := p.factor()
+		if c == '-' {
+			value = -value
+		}
+		return value, err
+	case c == '(':
+		p.pos++
+		value, err := p.expr()
+		if err != nil {
+			return 0, err
+		}
+		p.skipSpaces()
+		if p.pos >= len(p.input) || p.input[p.pos] != ')' {
+			return 0, fmt.Errorf("%s: missing ')'", p.input)
+		}
+		p.pos++
+		return value, nil
+	}
+
+	start := p.pos
+	for p.pos < len(p.input) && (p.input[p.pos] == '_' || unicode.IsLetter(rune(p.input[p.pos])) || unicode.IsDigit(rune(p.input[p.pos]))) {
+		p.pos++
+	}
+	word := p.input[start:p.pos]
+	if word == "" {
+		return 0, fmt.Errorf("%s: syntax error: operand expected (error token is \"%s\")", p.input, p.input[p.pos:])
+	}
+	if unicode.IsDigit(rune(word[0])) {
+		value, err := strconv.Atoi(word)
+		if err != nil {
+			return 0, fmt.Errorf("%s: value too great for base (error token is \"%s\")", p.input, word)
+		}
+		return value, nil
+	}
+
+	value, _ := p.s.getVar(word)
+	n, _ := strconv.Atoi(strings.TrimSpace(value))
+	return n, nil
+}
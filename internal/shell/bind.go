@@ -0,0 +1,105 @@
+package shell
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/codecrafters-io/shell-starter-go/internal/editor"
+)
+
+// ** Key Bindings **
+// ------------------------------------------------------------------------------------------
+
+// Shell builtin bind: binds readline key sequences to editor functions, or to shell commands with -x.
+// -l lists the functions, -p and -X print function and command bindings, -r removes a binding
+func (s *Shell) bind(args []string, std Streams) error {
+	keymap := s.editor.Keymap()
+	command := false
+
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; arg {
+		case "-l":
+			for _, name := range editor.ActionNames() {
+				fmt.Fprintln(std.stdout, name)
+			}
+		case "-p", "-X":
+			for _, seq := range keymap.Sequences() {
+				binding, _ := keymap.Get(seq)
+				if arg == "-p" && binding.Action != "" {
+					fmt.Fprintf(std.stdout, "\"%s\": %s\n", editor.FormatKeyseq(seq), binding.Action)
+				} else if arg == "-X" && binding.Command != "" {
+					fmt.Fprintf(std.stdout, "\"%s\": \"%s\"\n", editor.FormatKeyseq(seq), binding.Command)
+				}
+			}
+		case "-x":
+			command = true
+		case "-r":
+			if i >= len(args)-1 {
+				return fmt.Errorf("bind: -r: option requires an argument")
+			}
+			i++
+			seq, err := editor.ParseKeyseq(strings.Trim(args[i], "\""))
+			if err != nil {
+				return fmt.Errorf("bind: %v", err)
+			}
+			keymap.Unbind(seq)
+		default:
+			if strings.HasPrefix(arg, "-") {
+				return fmt.Errorf("bind: %s: invalid option\nbind: usage: bind [-lpX] [-r keyseq] [-x keyseq:shell-command] [keyseq:readline-function]", arg)
+			}
+			seq, target, err := parseBinding(arg)
+			if err != nil {
+				return fmt.Errorf("bind: %v", err)
+			}
+			if command {
+				keymap.BindCommand(seq, target)
+			} else if err := keymap.Bind(seq, target); err != nil {
+				return fmt.Errorf("bind: %v", err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Splits a readline binding like "\C-g": clear-screen into the raw key sequence and its target
+func parseBinding(spec string) (string, string, error) {
+	var notation, rest string
+	if strings.HasPrefix(spec, "\"") {
+		end := 1
+		for end < len(spec) && spec[end] != '"' {
+			if spec[end] == '\\' {
+				end++
+			}
+			end++
+		}
+		if end >= len(spec) {
+			return "", "", fmt.Errorf("%s: no closing '\"' in key binding", spec)
+		}
+		notation, rest = spec[1:end], spec[end+1:]
+	} else {
+		colon := strings.Index(spec, ":")
+		if colon == -1 {
+			return "", "", fmt.Errorf("%s: no ':' in key binding", spec)
+		}
+		notation, rest = spec[:colon], spec[colon:]
+	}
+
+	rest = strings.TrimSpace(rest)
+	if !strings.HasPrefix(rest, ":") {
+		return "", "", fmt.Errorf("%s: no ':' in key binding", spec)
+	}
+	target := strings.TrimSpace(rest[1:])
+	if len(target) >= 2 && target[0] == '"' && target[len(target)-1] == '"' {
+		target = target[1 : len(target)-1]
+	}
+	if target == "" {
+		return "", "", fmt.Errorf("%s: missing function name or command", spec)
+	}
+
+	seq, err := editor.ParseKeyseq(notation)
+	if err != nil {
+		return "", "", err
+	}
+	return seq, target, nil
+}
@@ -0,0 +1,178 @@
+package shell
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ** Completion Cache **
+// ------------------------------------------------------------------------------------------
+
+// How long a directory listing is trusted without being re-read, as long as the directory's mtime is unchanged
+const completionTTL = 2 * time.Second
+
+// Cached listing of one directory
+type cachedDir struct {
+	entries     []os.DirEntry
+	executables []string
+	modTime     time.Time
+	loaded      time.Time
+}
+
+// Directory listings and PATH executables used by Tab completion, so repeated Tab presses in huge
+// directories (node_modules, /usr/bin) don't re-read them every time
+type CompletionCache struct {
+	mu   sync.Mutex
+	dirs map[string]*cachedDir
+}
+
+// Creates an empty CompletionCache
+func NewCompletionCache() *CompletionCache {
+	return &CompletionCache{dirs: make(map[string]*cachedDir)}
+}
+
+// Returns the cached listing of dir, re-reading it when the TTL has expired or its mtime changed
+func (c *CompletionCache) dir(path string) (*cachedDir, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if cached, exists := c.dirs[path]; exists && time.Since(cached.loaded) < completionTTL && cached.modTime.Equal(fi.ModTime()) {
+		return cached, nil
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	cached := &cachedDir{entries: entries, modTime: fi.ModTime(), loaded: time.Now()}
+	c.dirs[path] = cached
+	return cached, nil
+}
+
+// Drops the cached listings of dirs, they are read again on next use
+func (c *CompletionCache) Forget(dirs ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, dir := range dirs {
+		delete(c.dirs, dir)
+	}
+}
+
+// Entries of a directory
+func (c *CompletionCache) List(path string) ([]os.DirEntry, error) {
+	cached, err := c.dir(path)
+	if err != nil {
+		return nil, err
+	}
+	return cached.entries, nil
+}
+
+// Names of the executables found in the directories of PATH, sorted and without duplicates
+func (c *CompletionCache) Executables(path string) []string {
+	seen := make(map[string]bool)
+	names := []string{}
+	for _, dir := range filepath.SplitList(path) {
+		cached, err := c.dir(dir)
+		if err != nil {
+			continue
+		}
+
+		c.mu.Lock()
+		if cached.executables == nil {
+			cached.executables = []string{}
+			for _, entry := range cached.entries {
+				if fi, err := os.Stat(filepath.Join(dir, entry.Name())); err == nil && !fi.IsDir() && isExecutable(fi) {
+					cached.executables = append(cached.executables, entry.Name())
+				}
+			}
+		}
+		executables := cached.executables
+		c.mu.Unlock()
+
+		for _, name := range executables {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Whether a file can be executed, on windows this goes by extension
+func isExecutable(fi os.FileInfo) bool {
+	if runtime.GOOS == "windows" {
+		ext := strings.ToLower(filepath.Ext(fi.Name()))
+		return ext == ".exe" || ext == ".bat" || ext == ".cmd" || ext == ".com"
+	}
+	return fi.Mode()&0111 != 0
+}
+
+// ** Command Lookup Cache **
+// ------------------------------------------------------------------------------------------
+
+// Remembered PATH lookups (bash's hash table), shared by the executor and type so both always agree.
+// Entries are checked before use and the whole table is dropped when PATH changes
+type LookupCache struct {
+	mu      sync.Mutex
+	path    string
+	entries map[string]string
+}
+
+// Creates an empty LookupCache
+func NewLookupCache() *LookupCache {
+	return &LookupCache{entries: make(map[string]string)}
+}
+
+// Resolves a command name to the executable it runs. Names containing a path separator are used as they are,
+// others are searched for in the directories of path (on windows with the usual extensions added)
+func (c *LookupCache) Lookup(name, path string) (string, bool) {
+	if strings.ContainsRune(name, '/') || strings.ContainsRune(name, filepath.Separator) {
+		return name, runnable(name)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if path != c.path {
+		c.path = path
+		c.entries = make(map[string]string)
+	}
+	if cached, exists := c.entries[name]; exists {
+		if runnable(cached) {
+			return cached, true
+		}
+		delete(c.entries, name)
+	}
+
+	files := []string{name}
+	if runtime.GOOS == "windows" && filepath.Ext(name) == "" {
+		files = []string{name + ".exe", name + ".bat", name + ".cmd", name + ".com"}
+	}
+	for _, dir := range filepath.SplitList(path) {
+		for _, file := range files {
+			if candidate := filepath.Join(dir, file); runnable(candidate) {
+				c.entries[name] = candidate
+				return candidate, true
+			}
+		}
+	}
+	return "", false
+}
+
+// Whether path is an executable file
+func runnable(path string) bool {
+	fi, err := os.Stat(path)
+	return err == nil && !fi.IsDir() && isExecutable(fi)
+}
@@ -0,0 +1,48 @@
+package shell
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+)
+
+// ** Capture **
+// ------------------------------------------------------------------------------------------
+
+// Shell builtin capture, runs a command on a pseudo-terminal, so it behaves as it would on the shell's own
+// terminal, and writes what it shows to file as well. -a appends to the file
+func (s *Shell) capture(args []string, std Streams) error {
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if len(args) > 0 && args[0] == "-a" {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+		args = args[1:]
+	}
+	if len(args) < 2 {
+		return fmt.Errorf("capture: usage: capture [-a] file command [arg ...]")
+	}
+
+	path, exists := s.findCommand(args[1])
+	if !exists {
+		return fmt.Errorf("capture: %s: command not found", args[1])
+	}
+	file, err := os.OpenFile(args[0], flags, 0666)
+	if err != nil {
+		return fmt.Errorf("capture: %s: %s", args[0], describeError(err))
+	}
+	defer file.Close()
+
+	ext := exec.Command(path, args[2:]...)
+	ext.Args[0] = args[1]
+	ext.Env = s.environ()
+	err = s.runOnPty(ext, io.MultiWriter(std.stdout, file))
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return ExitStatus(exitCode(err))
+	}
+	if err != nil {
+		return fmt.Errorf("capture: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package shell
+
+import (
+	"fmt"
+	"os/exec"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ** Argument Completion **
+// ------------------------------------------------------------------------------------------
+
+// Completer lists the candidates matching the argument being completed, given the word typed so far.
+// A candidate replaces the word, so it doesn't have to start with it
+type Completer func(word string) []string
+
+// Per-command argument completers, commands without one complete file paths
+func (s *Shell) initCompleters() {
+	s.completers = map[string]Completer{
+		"declare": s.completeVariable,
+		"export":  s.completeVariable,
+		"kill":    s.completeKill,
+		"typeset": s.completeVariable,
+		"unalias": s.completeAlias,
+		"unset":   s.completeVariable,
+	}
+}
+
+// Candidates for commands taking variable names: the shell's variables, sorted. Nothing is offered once
+// the word has become an assignment
+func (s *Shell) completeVariable(word string) []string {
+	if strings.Contains(word, "=") {
+		return nil
+	}
+	names := []string{}
+	for name := range s.vars {
+		if s.hasPrefix(name, word) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Candidates for unalias: the defined aliases, sorted
+func (s *Shell) completeAlias(word string) []string {
+	names := []string{}
+	for name := range s.cmdAlias {
+		if s.hasPrefix(name, word) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Candidates for kill: job specs of the shell's jobs, then with complete_processes set the PIDs of system
+// processes, matched by PID or by process name (a name that matches completes to its PID)
+func (s *Shell) completeKill(word string) []string {
+	candidates := []string{}
+	s.jobs.mu.Lock()
+	for _, job := range s.jobs.sorted() {
+		if spec := "%" + strconv.Itoa(job.id); job.state != JobDone && strings.HasPrefix(spec, word) {
+			candidates = append(candidates, spec)
+		}
+	}
+	s.jobs.mu.Unlock()
+	if !s.options.Get("complete_processes") || strings.HasPrefix(word, "%") {
+		return candidates
+	}
+
+	for _, proc := range processes() {
+		pid := strconv.Itoa(proc.pid)
+		if strings.HasPrefix(pid, word) || word != "" && s.hasPrefix(proc.name, word) {
+			candidates = append(candidates, pid)
+		}
+	}
+	return candidates
+}
+
+// A running process, as listed for completion
+type process struct {
+	pid  int
+	name string
+}
+
+// Running processes of the system, from ps or tasklist on windows
+func processes() []process {
+	var out []byte
+	var err error
+	if runtime.GOOS == "windows" {
+		out, err = exec.Command("tasklist", "/fo", "csv", "/nh").Output()
+	} else {
+		out, err = exec.Command("ps", "-e", "-o", "pid=", "-o", "comm=").Output()
+	}
+	if err != nil {
+		return nil
+	}
+
+	procs := []process{}
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		var proc process
+		if runtime.GOOS == "windows" {
+			// "name.exe","1234","Console","1","10,000 K"
+			fields := strings.Split(strings.TrimSpace(line), "\",\"")
+			if len(fields) < 2 {
+				continue
+			}
+			proc.name = strings.TrimPrefix(fields[0], "\"")
+			proc.pid, err = strconv.Atoi(fields[1])
+		} else {
+			_, err = fmt.Sscan(line, &proc.pid)
+			proc.name = strings.TrimSpace(strings.TrimSpace(line)[len(strconv.Itoa(proc.pid)):])
+		}
+		if err == nil {
+			procs = append(procs, proc)
+		}
+	}
+	return procs
+}
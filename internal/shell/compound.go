@@ -0,0 +1,766 @@
+package shell
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/codecrafters-io/shell-starter-go/internal/editor"
+)
+
+// ** Compound Commands **
+// ------------------------------------------------------------------------------------------
+
+// Reserved words structuring compound commands, recognized as the first word of a command
+var reservedWords = map[string]bool{
+	"if":   true,
+	"then": true,
+	"elif": true,
+	"else": true,
+	"fi":   true,
+	"case": true,
+	"esac": true,
+	"{":    true,
+	"}":    true,
+}
+
+// Reserved words closing compound commands, with the word opening each
+var closingWords = map[string]string{"fi": "if", "esac": "case", "}": "{"}
+
+// Part of the input split at its reserved words: a reserved word, or the text of the commands between two
+// of them and the commands parseCommand parsed from it. connector joins it to the part after it: "&&", "||",
+// "|" or ";". The text of a case is its word, the text of a ")" the patterns of a case clause and the text of a
+// "()" the name of the function it defines. A reserved word is found at bytes start to end of the line-th line
+type piece struct {
+	word       string
+	text       string
+	stack      []Command
+	connector  string
+	line       int
+	start, end int
+}
+
+// Commands of a compound command's part, run in order like a command list
+type block []node
+
+// Simple commands parsed by parseCommand, or a compound command, and the connector to the node after it
+type node struct {
+	stack     []Command
+	compound  any // *ifCommand, *caseCommand, *groupCommand, *timeoutCommand, *pipelineCommand or *functionDefinition
+	connector string
+}
+
+// if, with its elif conditions: the branch of the first condition that succeeds runs, or the else branch
+// when there is one more branch than conditions
+type ifCommand struct {
+	conditions []block
+	branches   []block
+}
+
+// case, matching its word against the patterns of each clause in turn
+type caseCommand struct {
+	word    string
+	clauses []caseClause
+}
+
+// Patterns of a case clause, alternatives any of which selects the body
+type caseClause struct {
+	patterns []string
+	body     block
+}
+
+// { commands; }, run in the current shell like a command list
+type groupCommand struct {
+	body block
+}
+
+// Compound commands joined by | to each other or to simple commands, the stages of a pipeline. A stage of
+// simple commands may be a pipeline itself
+type pipelineCommand struct {
+	stages []node
+}
+
+// name() { commands; }, defining a function that runs the group with its arguments as positional parameters
+type functionDefinition struct {
+	name   string
+	body   block
+	source string
+}
+
+// Word or separator of a line as split at its reserved words
+type lexeme struct {
+	text       string
+	sep        bool // ";", ";;", "&&", "||", "&" or "|"
+	start, end int
+}
+
+// Next word or separator of a line from byte i on, honoring quotes and escapes. ok is false at the end of
+// the line
+func lexAt(line string, i int) (lx lexeme, ok bool) {
+	for i < len(line) && (line[i] == ' ' || line[i] == '\t') {
+		i++
+	}
+	if i >= len(line) {
+		return lexeme{}, false
+	}
+	if isSeparator(line, i) {
+		sep := line[i : i+1]
+		if i+1 < len(line) && line[i+1] == line[i] {
+			sep += sep
+		}
+		return lexeme{text: sep, sep: true, start: i, end: i + len(sep)}, true
+	}
+
+	var single, double, escaped bool
+	for j := i; j < len(line); j++ {
+		c := line[j]
+		switch {
+		case escaped:
+			escaped = false
+		case c == '\\' && !single:
+			escaped = true
+		case c == '\'' && !double:
+			single = !single
+		case c == '"' && !single:
+			double = !double
+		case single || double:
+		case c == ' ' || c == '\t' || isSeparator(line, j):
+			return lexeme{text: line[i:j], start: i, end: j}, true
+		}
+	}
+	return lexeme{text: line[i:], start: i, end: len(line)}, true
+}
+
+// Whether the unquoted byte at i of a line separates commands. The & and | of redirection operators like
+// 2>&1, &> and >| belong to their words
+func isSeparator(line string, i int) bool {
+	switch line[i] {
+	case ';':
+		return true
+	case '&', '|':
+		return !(i > 0 && (line[i-1] == '>' || line[i-1] == '<') || line[i] == '&' && i+1 < len(line) && line[i+1] == '>')
+	}
+	return false
+}
+
+// Patterns of a case clause starting at byte i of a line, without the optional (, up to the unquoted ) that
+// ends them. end is the byte after the )
+func patternsAt(line string, i int) (text string, end int, err error) {
+	if line[i] == '(' {
+		i++
+	}
+	var single, double, escaped bool
+	for j := i; j < len(line); j++ {
+		c := line[j]
+		switch {
+		case escaped:
+			escaped = false
+		case c == '\\' && !single:
+			escaped = true
+		case c == '\'' && !double:
+			single = !single
+		case c == '"' && !single:
+			double = !double
+		case single || double:
+		case c == ')':
+			if strings.TrimSpace(line[i:j]) == "" {
+				return "", 0, fmt.Errorf("syntax error near unexpected token ')'")
+			}
+			return line[i:j], j + 1, nil
+		}
+	}
+	return "", 0, fmt.Errorf("syntax error near unexpected token 'newline'")
+}
+
+// Alternatives of case patterns, split at the unquoted |
+func splitPatterns(text string) []string {
+	patterns := []string{}
+	var single, double, escaped bool
+	start := 0
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case escaped:
+			escaped = false
+		case c == '\\' && !single:
+			escaped = true
+		case c == '\'' && !double:
+			single = !single
+		case c == '"' && !single:
+			double = !double
+		case single || double:
+		case c == '|':
+			patterns = append(patterns, text[start:i])
+			start = i + 1
+		}
+	}
+	return append(patterns, text[start:])
+}
+
+// Name of the function a word in command position defines, as name() or followed by a separate (), empty
+// when it isn't a function definition. The { of the body may follow the () without a blank
+func functionName(line string, lx lexeme) string {
+	if name, _, ok := strings.Cut(lx.text, "()"); ok {
+		if isValidName(name) {
+			return name
+		}
+		return ""
+	}
+	if next, ok := lexAt(line, lx.end); ok && next.text == "()" && isValidName(lx.text) {
+		return lx.text
+	}
+	return ""
+}
+
+// Whether a timeout word in command position times a group, its duration followed by a {
+func timeoutGroup(line string, lx lexeme) bool {
+	duration, ok := lexAt(line, lx.end)
+	if !ok || duration.sep {
+		return false
+	}
+	next, ok := lexAt(line, duration.end)
+	return ok && next.text == "{"
+}
+
+// Splits the lines of the input at their reserved words, keeping track of the compound commands they leave
+// open from one line to the next
+type splitter struct {
+	open    []string // opening words of the open compound commands, innermost last
+	pattern bool     // the patterns of a case clause come next
+	group   bool     // a function was named or a timeout given, the { of the group comes next
+}
+
+// Opening word of the innermost open compound command, empty when none is open
+func (sp *splitter) innermost() string {
+	if len(sp.open) == 0 {
+		return ""
+	}
+	return sp.open[len(sp.open)-1]
+}
+
+// Splits a line at the reserved words in command position and at the separators of its command lists, each
+// text piece holding a pipeline (or a command put in the background) and the separator joining it to what
+// follows, a | when a compound command comes next. A trailing &&, || or | continues on the next line, any
+// other line end is a ;. The patterns of a
+// case clause make a ")" piece, the ;; ending the clause a ";;" piece
+func (sp *splitter) split(line string) ([]piece, error) {
+	pieces := []piece{}
+	pending := -1
+	for i := 0; ; {
+		lx, ok := lexAt(line, i)
+		if !ok {
+			break
+		}
+		i = lx.end
+		switch {
+		case sp.group && lx.text != "{":
+			return nil, fmt.Errorf("syntax error near unexpected token '%s'", lx.text)
+		case sp.pattern && lx.text != "esac":
+			if lx.sep {
+				return nil, fmt.Errorf("syntax error near unexpected token '%s'", lx.text)
+			}
+			text, end, err := patternsAt(line, lx.start)
+			if err != nil {
+				return nil, err
+			}
+			pieces = append(pieces, piece{word: ")", text: text})
+			sp.pattern = false
+			i = end
+		case !lx.sep && pending == -1 && reservedWords[lx.text]:
+			current, end, err := sp.reserved(line, lx)
+			if err != nil {
+				return nil, err
+			}
+			pieces = append(pieces, current)
+			i = end
+		case !lx.sep && pending == -1 && lx.text == "timeout" && timeoutGroup(line, lx):
+			// timeout DURATION { ...; }, a timeout of a simple command runs timeout(1)
+			duration, _ := lexAt(line, lx.end)
+			pieces = append(pieces, piece{word: "timeout", text: duration.text, start: lx.start, end: duration.end})
+			sp.group = true
+			i = duration.end
+		case !lx.sep && pending == -1 && functionName(line, lx) != "":
+			// name() or name (), the body goes on after the ()
+			name := functionName(line, lx)
+			end := strings.Index(line[lx.start:], "()") + lx.start + 2
+			pieces = append(pieces, piece{word: "()", text: name, start: lx.start, end: end})
+			sp.group = true
+			i = end
+		case lx.text == ";;":
+			if sp.innermost() != "case" {
+				return nil, fmt.Errorf("syntax error near unexpected token ';;'")
+			}
+			if pending != -1 {
+				pieces = append(pieces, piece{text: line[pending:lx.start], connector: ";"})
+				pending = -1
+			}
+			pieces = append(pieces, piece{word: ";;"})
+			sp.pattern = true
+		case lx.sep && pending == -1:
+			return nil, fmt.Errorf("syntax error near unexpected token '%s'", lx.text)
+		case lx.text == "|":
+			// A compound command may follow as the next stage, the commands before it make a stage
+			next, ok := lexAt(line, i)
+			switch {
+			case ok && (next.text == "if" || next.text == "case" || next.text == "{" || next.text == "timeout" && timeoutGroup(line, next)):
+				pieces = append(pieces, piece{text: line[pending:lx.start], connector: "|"})
+				pending = -1
+			case ok && reservedWords[next.text]:
+				return nil, fmt.Errorf("syntax error near unexpected token '%s'", next.text)
+			}
+		case lx.sep:
+			// A command put in the background keeps its &, the list goes on after it as after a ;
+			text, connector := line[pending:lx.start], lx.text
+			if connector == "&" {
+				text, connector = line[pending:lx.end], ";"
+			}
+			pieces = append(pieces, piece{text: text, connector: connector})
+			pending = -1
+		case pending == -1:
+			pending = lx.start
+		}
+	}
+
+	if pending != -1 {
+		pieces = append(pieces, piece{text: line[pending:], connector: ";"})
+	}
+	return pieces, nil
+}
+
+// Piece of a reserved word lexed from a line, and where the line goes on after it. A case takes its word
+// and the in after it, a closing word the separator after it as its connector
+func (sp *splitter) reserved(line string, lx lexeme) (piece, int, error) {
+	current := piece{word: lx.text, start: lx.start, end: lx.end}
+	unexpected := func(next lexeme, ok bool) (piece, int, error) {
+		if !ok {
+			next.text = "newline"
+		}
+		return piece{}, 0, fmt.Errorf("syntax error near unexpected token '%s'", next.text)
+	}
+
+	switch lx.text {
+	case "case":
+		word, ok := lexAt(line, lx.end)
+		if !ok || word.sep {
+			return unexpected(word, ok)
+		}
+		in, ok := lexAt(line, word.end)
+		if !ok || in.text != "in" {
+			return unexpected(in, ok)
+		}
+		sp.open = append(sp.open, "case")
+		sp.pattern = true
+		current.text = word.text
+		return current, in.end, nil
+	case "if", "{":
+		sp.open = append(sp.open, lx.text)
+		sp.group = false
+	case "then", "elif", "else":
+		if sp.innermost() != "if" {
+			return unexpected(lx, true)
+		}
+	case "fi", "esac", "}":
+		if sp.innermost() != closingWords[lx.text] {
+			return unexpected(lx, true)
+		}
+		sp.open = sp.open[:len(sp.open)-1]
+		sp.pattern = false
+		next, ok := lexAt(line, lx.end)
+		switch {
+		case !ok || next.text == ";;":
+			current.connector = ";"
+			return current, lx.end, nil
+		case next.text == ";" || next.text == "&&" || next.text == "||" || next.text == "|":
+			current.connector = next.text
+			return current, next.end, nil
+		}
+		return unexpected(next, ok)
+	}
+	if next, ok := lexAt(line, lx.end); ok && next.sep {
+		return unexpected(next, ok)
+	}
+	return current, lx.end, nil
+}
+
+// Reads a command line holding compound commands, and the lines that follow it up to the end of the
+// compound commands it opens, prompting with PS2. Each line's commands are parsed, and their here-documents
+// read, before the next line. Returns nil when the line has no reserved words and runs as simple commands
+func (s *Shell) readCompound(line string, next lineSource) (block, error) {
+	pieces := []piece{}
+	lines := []string{}
+	sp := &splitter{}
+	for first := true; ; first = false {
+		split, err := sp.split(line)
+		if err != nil {
+			return nil, err
+		}
+		if first && !slices.ContainsFunc(split, func(p piece) bool { return p.word != "" }) {
+			return nil, nil
+		}
+		lines = append(lines, line)
+		for _, current := range split {
+			current.line = len(lines) - 1
+			if current.word != "" {
+				pieces = append(pieces, current)
+				continue
+			}
+			s.parseCommand(current.text)
+			if err := s.readHeredocs(next); err != nil {
+				s.stack = []Command{}
+				return nil, err
+			}
+			if len(s.stack) > 0 {
+				current.stack = s.stack
+				pieces = append(pieces, current)
+			}
+			s.stack = []Command{}
+		}
+		if len(sp.open) == 0 && !sp.group && pieces[len(pieces)-1].connector == ";" {
+			break
+		}
+
+		line, err = next(s.secondaryPrompt())
+		if err == editor.ErrInterrupted {
+			return nil, err
+		}
+		if err != nil {
+			return nil, fmt.Errorf("syntax error: unexpected end of file")
+		}
+		line = stripComment(line)
+	}
+
+	p := &compoundParser{pieces: pieces, lines: lines}
+	b, err := p.block()
+	if err == nil && p.pos < len(pieces) {
+		err = fmt.Errorf("syntax error near unexpected token '%s'", pieces[p.pos].word)
+	}
+	return b, err
+}
+
+// Builds the tree of compound commands from the pieces of the input and the lines they were split from
+type compoundParser struct {
+	pieces []piece
+	lines  []string
+	pos    int
+}
+
+// Reserved word of the piece being parsed, empty at the end or on commands
+func (p *compoundParser) peek() string {
+	if p.pos >= len(p.pieces) {
+		return ""
+	}
+	return p.pieces[p.pos].word
+}
+
+// Parses commands and compound commands up to a reserved word that ends the block, or the end of the input
+func (p *compoundParser) block() (block, error) {
+	b := block{}
+	for p.pos < len(p.pieces) {
+		current := p.pieces[p.pos]
+		switch current.word {
+		case "":
+			b = append(b, node{stack: current.stack, connector: current.connector})
+			p.pos++
+		case "if":
+			p.pos++
+			compound, connector, err := p.ifCommand()
+			if err != nil {
+				return nil, err
+			}
+			b = append(b, node{compound: compound, connector: connector})
+		case "case":
+			p.pos++
+			compound, connector, err := p.caseCommand(current.text)
+			if err != nil {
+				return nil, err
+			}
+			b = append(b, node{compound: compound, connector: connector})
+		case "{":
+			p.pos++
+			body, err := p.part("}")
+			if err != nil {
+				return nil, err
+			}
+			b = append(b, node{compound: &groupCommand{body: body}, connector: p.pieces[p.pos-1].connector})
+		case "timeout":
+			p.pos++
+			if p.peek() != "{" {
+				return nil, fmt.Errorf("syntax error near unexpected token '%s'", p.peek())
+			}
+			p.pos++
+			body, err := p.part("}")
+			if err != nil {
+				return nil, err
+			}
+			compound := &timeoutCommand{duration: current.text, body: body}
+			b = append(b, node{compound: compound, connector: p.pieces[p.pos-1].connector})
+		case "()":
+			p.pos++
+			if p.peek() != "{" {
+				return nil, fmt.Errorf("syntax error near unexpected token '%s'", p.peek())
+			}
+			p.pos++
+			body, err := p.part("}")
+			if err != nil {
+				return nil, err
+			}
+			end := p.pieces[p.pos-1]
+			compound := &functionDefinition{name: current.text, body: body, source: p.source(current, end)}
+			b = append(b, node{compound: compound, connector: end.connector})
+		default:
+			return b, nil
+		}
+		// Nodes joined by | are the stages of a pipeline, which is followed by the last stage's connector
+		if n := len(b); n > 1 && b[n-2].connector == "|" {
+			pipeline, ok := b[n-2].compound.(*pipelineCommand)
+			if !ok {
+				pipeline = &pipelineCommand{stages: []node{b[n-2]}}
+			}
+			pipeline.stages = append(pipeline.stages, b[n-1])
+			b = append(b[:n-2], node{compound: pipeline, connector: b[n-1].connector})
+		}
+	}
+	return b, nil
+}
+
+// Parses an if command after its if, up to its fi, returning the connector following the fi
+func (p *compoundParser) ifCommand() (*ifCommand, string, error) {
+	compound := &ifCommand{}
+	for {
+		condition, err := p.part("then")
+		if err != nil {
+			return nil, "", err
+		}
+		branch, err := p.part("elif", "else", "fi")
+		if err != nil {
+			return nil, "", err
+		}
+		compound.conditions = append(compound.conditions, condition)
+		compound.branches = append(compound.branches, branch)
+		if p.pieces[p.pos-1].word != "elif" {
+			break
+		}
+	}
+	if p.pieces[p.pos-1].word == "else" {
+		branch, err := p.part("fi")
+		if err != nil {
+			return nil, "", err
+		}
+		compound.branches = append(compound.branches, branch)
+	}
+	return compound, p.pieces[p.pos-1].connector, nil
+}
+
+// Parses a case command after its case, up to its esac, returning the connector following the esac. The ;;
+// after the last clause may be left out
+func (p *compoundParser) caseCommand(word string) (*caseCommand, string, error) {
+	compound := &caseCommand{word: word}
+	for p.peek() == ")" {
+		patterns := splitPatterns(p.pieces[p.pos].text)
+		p.pos++
+		body, err := p.block()
+		if err != nil {
+			return nil, "", err
+		}
+		compound.clauses = append(compound.clauses, caseClause{patterns: patterns, body: body})
+		if p.peek() != ";;" {
+			break
+		}
+		p.pos++
+	}
+	switch {
+	case p.pos >= len(p.pieces):
+		return nil, "", fmt.Errorf("syntax error: unexpected end of file")
+	case p.peek() != "esac":
+		return nil, "", fmt.Errorf("syntax error near unexpected token '%s'", p.peek())
+	}
+	p.pos++
+	return compound, p.pieces[p.pos-1].connector, nil
+}
+
+// Input from the start of one piece to the end of another, the lines between them included
+func (p *compoundParser) source(from, to piece) string {
+	if from.line == to.line {
+		return p.lines[from.line][from.start:to.end]
+	}
+	lines := []string{p.lines[from.line][from.start:]}
+	lines = append(lines, p.lines[from.line+1:to.line]...)
+	return strings.Join(append(lines, p.lines[to.line][:to.end]), "\n")
+}
+
+// Parses a non-empty block ended by one of the reserved words given, which is consumed
+func (p *compoundParser) part(ends ...string) (block, error) {
+	b, err := p.block()
+	if err != nil {
+		return nil, err
+	}
+	word := p.peek()
+	switch {
+	case word == "" && p.pos >= len(p.pieces):
+		return nil, fmt.Errorf("syntax error: unexpected end of file")
+	case !slices.Contains(ends, word) || len(b) == 0:
+		return nil, fmt.Errorf("syntax error near unexpected token '%s'", word)
+	}
+	p.pos++
+	return b, nil
+}
+
+// Runs a block like a command list: after each node its connector decides whether the next one runs. An
+// interrupted node abandons the rest, as does one that ran in a cancelled timeout group or returned from a
+// function
+func (s *Shell) runBlock(b block, std Streams) error {
+	var err error
+	for i := 0; i < len(b); i++ {
+		err = s.runNode(b[i], std)
+		if sig, ok := signaled(err); ok && sig == syscall.SIGINT || std.scope.cancelled() || s.returning {
+			return err
+		}
+		// Skipped nodes keep the status for the connector after them
+		for i < len(b)-1 && (b[i].connector == "&&" && err != nil || b[i].connector == "||" && err == nil) {
+			i++
+		}
+		if i < len(b)-1 {
+			s.report(err)
+		}
+	}
+	return err
+}
+
+// Runs simple commands or a compound command
+func (s *Shell) runNode(n node, std Streams) error {
+	switch compound := n.compound.(type) {
+	case *ifCommand:
+		return s.runIf(compound, std)
+	case *caseCommand:
+		return s.runCase(compound, std)
+	case *groupCommand:
+		return s.runBlock(compound.body, std)
+	case *timeoutCommand:
+		return s.runTimeout(compound, std)
+	case *pipelineCommand:
+		return s.runPipeline(compound, std)
+	case *functionDefinition:
+		s.functions[compound.name] = &function{body: compound.body, source: compound.source}
+		s.status = 0
+		return nil
+	}
+	return s.executeCommand(n.stack[0], std)
+}
+
+// Runs the stages of a pipeline holding compound commands, connected by pipes like those of executePipeline.
+// Every stage runs in a goroutine of the shell as a builtin stage does, the external commands they start
+// stay in the shell's process group. The status is the last stage's
+func (s *Shell) runPipeline(compound *pipelineCommand, std Streams) error {
+	stages := compound.stages
+	stdin := make([]*os.File, len(stages))
+	stdout := make([]*os.File, len(stages))
+	stdin[0], stdout[len(stages)-1] = std.stdin, std.stdout
+	var pipes openFiles
+	defer pipes.close()
+	for i := 0; i < len(stages)-1; i++ {
+		reader, writer, err := os.Pipe()
+		if err != nil {
+			return fmt.Errorf("pipe: %v", err)
+		}
+		pipes.add(reader)
+		pipes.add(writer)
+		stdout[i], stdin[i+1] = writer, reader
+	}
+
+	s.pipelines++
+	defer func() { s.pipelines-- }()
+	errs := make([]error, len(stages))
+	var running sync.WaitGroup
+	for i, stage := range stages {
+		running.Add(1)
+		go func() {
+			defer running.Done()
+			errs[i] = s.runNode(stage, Streams{stdin[i], stdout[i], std.stderr, std.scope})
+			// The stages next to it see EOF and EPIPE once it is over
+			if i > 0 {
+				stdin[i].Close()
+			}
+			if i < len(stages)-1 {
+				stdout[i].Close()
+				s.report(errs[i])
+			}
+		}()
+	}
+	running.Wait()
+
+	for _, err := range errs {
+		if sig, ok := signaled(err); ok && sig == syscall.SIGINT {
+			return err
+		}
+	}
+	err := errs[len(stages)-1]
+	s.status = exitCode(err)
+	return err
+}
+
+// Runs the branch of an if whose condition succeeds first, the status is 0 when no branch runs
+func (s *Shell) runIf(compound *ifCommand, std Streams) error {
+	// errexit leaves failing conditions alone, they are tested
+	for i, condition := range compound.conditions {
+		s.conditions++
+		err := s.runBlock(condition, std)
+		s.conditions--
+		if sig, ok := signaled(err); ok && sig == syscall.SIGINT || s.returning {
+			return err
+		}
+		if err == nil {
+			return s.runBlock(compound.branches[i], std)
+		}
+		s.report(err)
+	}
+	if len(compound.branches) > len(compound.conditions) {
+		return s.runBlock(compound.branches[len(compound.branches)-1], std)
+	}
+	s.status = 0
+	return nil
+}
+
+// Runs the body of the first case clause with a pattern matching the expanded word, the status is 0 when
+// no clause matches or its body is empty
+func (s *Shell) runCase(compound *caseCommand, std Streams) error {
+	word := strings.ReplaceAll(s.expandCompoundWord(compound.word), globMark, "")
+	for _, clause := range compound.clauses {
+		if !slices.ContainsFunc(clause.patterns, func(pattern string) bool {
+			return matchPattern(s.expandCompoundWord(pattern), word)
+		}) {
+			continue
+		}
+		if len(clause.body) > 0 {
+			return s.runBlock(clause.body, std)
+		}
+		break
+	}
+	s.status = 0
+	return nil
+}
+
+// Expands a word of a compound command like an argument, without matching it against files: its unquoted
+// glob characters stay marked
+func (s *Shell) expandCompoundWord(word string) string {
+	saved := s.stack
+	defer func() { s.stack = saved }()
+	// The escaped placeholder command keeps the word from alias expansion
+	s.parseCommand("\\: " + word)
+	if len(s.stack) == 0 || len(s.stack[0].args) == 0 {
+		return ""
+	}
+	expanded, _ := s.expandWord(s.expandTilde(s.stack[0].args[0]))
+	return expanded
+}
+
+// Whether a string matches a word holding marked glob characters. Unlike in pathnames, * and ? match / too
+func matchPattern(word, name string) bool {
+	// path.Match stops * and ? at /, another byte stands in for it on both sides
+	pattern := strings.ReplaceAll(globPattern(word, true), "/", "\x00")
+	matched, _ := path.Match(pattern, strings.ReplaceAll(name, "/", "\x00"))
+	return matched
+}
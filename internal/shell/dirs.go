@@ -0,0 +1,200 @@
+package shell
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ** Directory Stack **
+// ------------------------------------------------------------------------------------------
+
+// Entries of the directory stack, the current directory (entry 0) first
+func (s *Shell) dirEntries() []string {
+	cwd, _ := os.Getwd()
+	return append([]string{cwd}, s.dirStack...)
+}
+
+// Index of the entry +N (counting from the top, the current directory being 0) or -N (counting from the bottom) names
+func (s *Shell) dirIndex(spec string) (int, bool) {
+	if len(spec) < 2 || spec[0] != '+' && spec[0] != '-' {
+		return 0, false
+	}
+	n, err := strconv.Atoi(spec[1:])
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	if spec[0] == '-' {
+		n = len(s.dirStack) - n
+	}
+	return n, n >= 0 && n <= len(s.dirStack)
+}
+
+// Changes the current directory, keeping PWD and OLDPWD up to date
+func (s *Shell) chdir(dir string) error {
+	previous, _ := os.Getwd()
+	if err := os.Chdir(dir); err != nil {
+		return err
+	}
+	s.setVar("OLDPWD", previous)
+	if pwd, err := os.Getwd(); err == nil {
+		s.setVar("PWD", pwd)
+	}
+	return nil
+}
+
+// Expands ~N, ~+N and ~-N at the start of path to the directory stack entry they name
+func (s *Shell) expandDirStack(path string) (string, bool) {
+	spec, ok := strings.CutPrefix(path, "~")
+	if !ok {
+		return path, false
+	}
+	spec, rest, _ := strings.Cut(spec, "/")
+	if spec == "" || spec == "+" || spec == "-" {
+		return path, false
+	}
+	if spec[0] != '+' && spec[0] != '-' {
+		spec = "+" + spec
+	}
+	n, ok := s.dirIndex(spec)
+	if !ok {
+		return path, false
+	}
+	if strings.Contains(path, "/") {
+		return s.dirEntries()[n] + "/" + rest, true
+	}
+	return s.dirEntries()[n], true
+}
+
+// Prints the directory stack the way dirs does without flags
+func (s *Shell) printDirs(std Streams) {
+	entries := s.dirEntries()
+	for i, dir := range entries {
+		entries[i] = s.collapseHome(dir)
+	}
+	fmt.Fprintln(std.stdout, strings.Join(entries, " "))
+}
+
+// Shell builtin dirs, prints the directory stack: -v numbered one per line, -p one per line, -l with full paths,
+// +N/-N only that entry; -c clears it
+func (s *Shell) dirs(args []string, std Streams) error {
+	verbose, perLine, long := false, false, false
+	index := -1
+	for _, arg := range args {
+		if n, ok := s.dirIndex(arg); ok {
+			index = n
+			continue
+		} else if len(arg) > 1 && (arg[0] == '+' || arg[0] == '-') && arg[1] >= '0' && arg[1] <= '9' {
+			return fmt.Errorf("dirs: %s: directory stack index out of range", arg)
+		}
+		if !strings.HasPrefix(arg, "-") {
+			return fmt.Errorf("dirs: usage: %s", builtinDocs["dirs"].Usage)
+		}
+		for _, f := range arg[1:] {
+			switch f {
+			case 'c':
+				s.dirStack = nil
+				return nil
+			case 'v':
+				verbose = true
+			case 'p':
+				perLine = true
+			case 'l':
+				long = true
+			default:
+				return fmt.Errorf("dirs: -%c: invalid option\ndirs: usage: %s", f, builtinDocs["dirs"].Usage)
+			}
+		}
+	}
+
+	entries := s.dirEntries()
+	if !long {
+		for i, dir := range entries {
+			entries[i] = s.collapseHome(dir)
+		}
+	}
+	switch {
+	case index != -1:
+		fmt.Fprintln(std.stdout, entries[index])
+	case verbose:
+		for i, dir := range entries {
+			fmt.Fprintf(std.stdout, "%2d  %s\n", i, dir)
+		}
+	case perLine:
+		for _, dir := range entries {
+			fmt.Fprintln(std.stdout, dir)
+		}
+	default:
+		fmt.Fprintln(std.stdout, strings.Join(entries, " "))
+	}
+	return nil
+}
+
+// Shell builtin pushd, saves the current directory on the stack and changes to dir. Without arguments the top
+// two entries are swapped, +N/-N rotates the stack so that entry comes to the top
+func (s *Shell) pushd(args []string, std Streams) error {
+	if len(args) > 1 {
+		return fmt.Errorf("pushd: too many arguments")
+	}
+	entries := s.dirEntries()
+
+	switch {
+	case len(args) == 0:
+		if len(s.dirStack) == 0 {
+			return fmt.Errorf("pushd: no other directory")
+		}
+		if err := s.chdir(s.dirStack[0]); err != nil {
+			return fmt.Errorf("pushd: %s: %s", s.dirStack[0], describeError(err))
+		}
+		s.dirStack[0] = entries[0]
+	case strings.HasPrefix(args[0], "+") || strings.HasPrefix(args[0], "-") && len(args[0]) > 1:
+		n, ok := s.dirIndex(args[0])
+		if !ok {
+			return fmt.Errorf("pushd: %s: directory stack index out of range", args[0])
+		}
+		rotated := append(append([]string{}, entries[n:]...), entries[:n]...)
+		if err := s.chdir(rotated[0]); err != nil {
+			return fmt.Errorf("pushd: %s: %s", rotated[0], describeError(err))
+		}
+		s.dirStack = rotated[1:]
+	default:
+		if err := s.chdir(args[0]); err != nil {
+			return fmt.Errorf("pushd: %s: %s", args[0], describeError(err))
+		}
+		s.dirStack = append([]string{entries[0]}, s.dirStack...)
+	}
+
+	s.printDirs(std)
+	return nil
+}
+
+// Shell builtin popd, removes the top entry of the stack and changes to the new top. +N/-N removes that entry instead
+func (s *Shell) popd(args []string, std Streams) error {
+	if len(args) > 1 {
+		return fmt.Errorf("popd: too many arguments")
+	}
+	if len(s.dirStack) == 0 {
+		return fmt.Errorf("popd: directory stack empty")
+	}
+
+	n := 0
+	if len(args) == 1 {
+		index, ok := s.dirIndex(args[0])
+		if !ok {
+			return fmt.Errorf("popd: %s: directory stack index out of range", args[0])
+		}
+		n = index
+	}
+	if n == 0 {
+		if err := s.chdir(s.dirStack[0]); err != nil {
+			return fmt.Errorf("popd: %s: %s", s.dirStack[0], describeError(err))
+		}
+		s.dirStack = s.dirStack[1:]
+	} else {
+		s.dirStack = append(s.dirStack[:n-1], s.dirStack[n:]...)
+	}
+
+	s.printDirs(std)
+	return nil
+}
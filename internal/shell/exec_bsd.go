@@ -0,0 +1,9 @@
+//go:build !windows && !linux
+
+package shell
+
+import "syscall"
+
+func dup2(oldfd, newfd int) error {
+	return syscall.Dup2(oldfd, newfd)
+}
@@ -0,0 +1,8 @@
+package shell
+
+import "syscall"
+
+// Some Linux ports only have dup3
+func dup2(oldfd, newfd int) error {
+	return syscall.Dup3(oldfd, newfd, 0)
+}
@@ -0,0 +1,27 @@
+//go:build !windows
+
+package shell
+
+import (
+	"os"
+	"syscall"
+)
+
+// Replaces the shell process with the program at path, argv[0] is whatever the caller chose
+func replaceProcess(path string, argv []string, env []string) error {
+	return syscall.Exec(path, argv, env)
+}
+
+// Duplicates file onto the descriptor behind *target, so every stream already holding it follows, those of
+// the command line being run included, and so do the programs exec replaces the shell with. file is closed
+// afterwards unless it is one of the shell's own streams
+func redirectStream(target **os.File, file *os.File) error {
+	var err error
+	if file.Fd() != (*target).Fd() {
+		err = dup2(int(file.Fd()), int((*target).Fd()))
+	}
+	if file != os.Stdin && file != os.Stdout && file != os.Stderr {
+		file.Close()
+	}
+	return err
+}
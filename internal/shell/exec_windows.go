@@ -0,0 +1,32 @@
+//go:build windows
+
+package shell
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+)
+
+// Windows can't replace a running process, so the program runs as a child and the shell exits with its status
+func replaceProcess(path string, argv []string, env []string) error {
+	cmd := &exec.Cmd{Path: path, Args: argv, Env: env, Stdin: os.Stdin, Stdout: os.Stdout, Stderr: os.Stderr}
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		return err
+	}
+	os.Exit(cmd.ProcessState.ExitCode())
+	return nil
+}
+
+// Windows has no dup2 for handles, *target is swapped for file: streams the command line being run already
+// holds keep their old target until it ends. The previous target is closed once nothing refers to it anymore
+func redirectStream(target **os.File, file *os.File) error {
+	old := *target
+	*target = file
+	if old != os.Stdout && old != os.Stderr && old.Fd() > 2 {
+		old.Close()
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package shell
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/codecrafters-io/shell-starter-go/internal/color"
+)
+
+// ** Expect **
+// ------------------------------------------------------------------------------------------
+
+// Step of a transcript: the lines typed, the first one at the prompt and the others at PS2, and the output
+// they are expected to print
+type expectStep struct {
+	input  []string
+	output string
+	line   int // line of the transcript the step starts on
+}
+
+// Reads a transcript: a line starting with "$ " is typed at the prompt, the lines starting with "> " right
+// after it are typed at PS2, and the lines up to the next "$ " are the output expected, stdout and stderr
+// together. Lines starting with # before the first command are comments
+func parseTranscript(input io.Reader) ([]expectStep, error) {
+	steps := []expectStep{}
+	scanner := bufio.NewScanner(input)
+	scanner.Buffer(nil, 1<<20)
+	typing := false
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		if typed, ok := strings.CutPrefix(line, "$ "); ok || line == "$" {
+			steps = append(steps, expectStep{input: []string{typed}, line: n})
+			typing = true
+			continue
+		}
+		if len(steps) == 0 {
+			if line != "" && !strings.HasPrefix(line, "#") {
+				return nil, fmt.Errorf("line %d: output before the first command", n)
+			}
+			continue
+		}
+		step := &steps[len(steps)-1]
+		if typed, ok := strings.CutPrefix(line, "> "); ok && typing {
+			step.input = append(step.input, typed)
+			continue
+		}
+		typing = false
+		step.output += line + "\n"
+	}
+	return steps, scanner.Err()
+}
+
+// Replays a transcript through the same steps as the prompt loop, history expansion and job notices included,
+// and checks what each command prints against it. Every step is logged with the time it ran at, the prompt
+// and its output, a mismatch with what was expected instead. Returns 1 when a step didn't match, 2 when the
+// transcript can't be read
+func (s *Shell) runExpect(path string) int {
+	file, err := os.Open(path)
+	if err != nil {
+		s.report(fmt.Errorf("%s: %s", path, describeError(err)))
+		return 2
+	}
+	steps, err := parseTranscript(file)
+	file.Close()
+	if err != nil {
+		s.report(fmt.Errorf("%s: %v", path, err))
+		return 2
+	}
+
+	start := time.Now()
+	failed := 0
+	for _, step := range steps {
+		elapsed := time.Since(start).Seconds()
+		prompt := color.Strip(s.prompt())
+		input := step.input
+		output := s.recordOutput(func() {
+			s.reportJobs()
+			cwd, _ := os.Getwd()
+			line, ok := s.acceptLine(input[0], cwd)
+			if !ok {
+				return
+			}
+			s.execute(line, func(string) (string, error) {
+				if len(input) <= 1 {
+					return "", io.EOF
+				}
+				input = input[1:]
+				return input[0], nil
+			})
+			s.finishHistory()
+		})
+
+		fmt.Printf("[%8.3fs] %s%s\n", elapsed, prompt, step.input[0])
+		for _, more := range step.input[1:] {
+			fmt.Printf("%11s %s%s\n", "", color.Strip(s.secondaryPrompt()), more)
+		}
+		if output == step.output {
+			fmt.Print(output)
+			continue
+		}
+		failed++
+		fmt.Printf("%s:%d: output differs\n", path, step.line)
+		printLines("-", step.output)
+		printLines("+", output)
+	}
+
+	fmt.Printf("%d of %d steps matched\n", len(steps)-failed, len(steps))
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// What run prints on the shell's stdout and stderr, interleaved as written, with \r\n line ends made \n.
+// Background commands started by run are only waited for briefly
+func (s *Shell) recordOutput(run func()) string {
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		run()
+		return ""
+	}
+	stdout, stderr := os.Stdout, os.Stderr
+	os.Stdout, os.Stderr = writer, writer
+	var buf bytes.Buffer
+	done := make(chan struct{})
+	go func() {
+		io.Copy(&buf, reader)
+		close(done)
+	}()
+
+	run()
+	os.Stdout, os.Stderr = stdout, stderr
+	writer.Close()
+	reader.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	<-done
+	reader.Close()
+	return strings.ReplaceAll(buf.String(), "\r\n", "\n")
+}
+
+// Prints each line of text after a prefix
+func printLines(prefix, text string) {
+	if text == "" {
+		return
+	}
+	for _, line := range strings.Split(strings.TrimSuffix(text, "\n"), "\n") {
+		fmt.Println(prefix + line)
+	}
+}
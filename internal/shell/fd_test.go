@@ -0,0 +1,83 @@
+package shell
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Number of file descriptors the test process has open
+func openDescriptors(t *testing.T) int {
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Skip("open descriptors can't be counted without /proc")
+	}
+	return len(entries)
+}
+
+// Runs command lines through the executor over and over, hitting redirection, pipe and here-string paths that
+// succeed and that fail, and checks the shell doesn't hold on to more descriptors than it started with
+func TestExecutorDescriptorLeaks(t *testing.T) {
+	t.Setenv("HISTFILE", "")
+	s := NewShell()
+	dir := t.TempDir()
+
+	// The shell's debug log is written to the current directory
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(cwd)
+
+	// Output goes nowhere while the commands run
+	null, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer null.Close()
+	stdout, stderr := os.Stdout, os.Stderr
+	os.Stdout, os.Stderr = null, null
+	defer func() { os.Stdout, os.Stderr = stdout, stderr }()
+
+	lines := []string{
+		"echo out > DIR/a",
+		"echo out >> DIR/a 2> DIR/b",
+		"cat < DIR/a > DIR/c",
+		"cat < DIR/missing",
+		"echo x > DIR/missing/x",
+		"true < DIR/a < DIR/c > DIR/d",
+		"echo x 2>&1 >&2 > DIR/e",
+		"echo x >&7",
+		"cat <<< here-string | cat > DIR/f",
+		"echo x | nosuchcommand",
+		"nosuchcommand | cat",
+		"echo x | cat | wc -l > DIR/g",
+		"help | head -1",
+		"cat DIR/a | true",
+		"read line < DIR/a",
+		"echo x | read line",
+		"read -t 0.01 line <<< x",
+	}
+
+	before := openDescriptors(t)
+	for range 20 {
+		for _, line := range lines {
+			s.parseCommand(strings.ReplaceAll(line, "DIR", dir))
+			s.executeCommand(s.stack[0], standardStreams())
+		}
+	}
+
+	// Here-string feeds close their pipe from a goroutine, give them a moment
+	after := openDescriptors(t)
+	for deadline := time.Now().Add(2 * time.Second); after > before && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+		after = openDescriptors(t)
+	}
+	if after > before {
+		t.Errorf("%d descriptors open before running the commands, %d after", before, after)
+	}
+}
@@ -0,0 +1,45 @@
+package shell
+
+import (
+	"fmt"
+	"os/exec"
+	"syscall"
+	"unsafe"
+)
+
+// Foreground commands stopped with Ctrl+Z become jobs
+const canSuspend = true
+
+// Blocks until a foreground command in a process group of its own exits or stops, and reports whether it
+// stopped. The stop is collected, an exit is only looked at and left for exec.Cmd.Wait to collect
+func waitStopped(pid int) bool {
+	const pPid = 1
+	const cldStopped = 5
+
+	// siginfo_t is 128 bytes, si_code is its third int
+	var info [16]uint64
+	for {
+		_, _, errno := syscall.Syscall6(syscall.SYS_WAITID, pPid, uintptr(pid), uintptr(unsafe.Pointer(&info)), syscall.WEXITED|syscall.WSTOPPED|syscall.WNOWAIT, 0, 0)
+		if errno == syscall.EINTR {
+			continue
+		}
+		if errno != 0 || int32(info[1]) != cldStopped {
+			return false
+		}
+		break
+	}
+	syscall.Syscall6(syscall.SYS_WAITID, pPid, uintptr(pid), uintptr(unsafe.Pointer(&info)), syscall.WSTOPPED, 0, 0)
+	return true
+}
+
+// Turns a foreground command stopped with Ctrl+Z into a stopped job, for fg and bg to resume
+func (s *Shell) suspend(ext *exec.Cmd, command string) error {
+	job := s.jobs.add(ext, command)
+	s.jobs.update(job, JobStopped, 0)
+	go s.monitorJob(job)
+
+	s.jobs.mu.Lock()
+	fmt.Printf("\n%s\n", s.jobs.format(job))
+	s.jobs.mu.Unlock()
+	return ExitStatus(128 + int(syscall.SIGTSTP))
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+
+package shell
+
+import "os/exec"
+
+// Stops can't be told apart from exits without reaping the command, foreground commands stay in the
+// shell's process group
+const canSuspend = false
+
+func waitStopped(pid int) bool {
+	return false
+}
+
+func (s *Shell) suspend(ext *exec.Cmd, command string) error {
+	return nil
+}
@@ -0,0 +1,76 @@
+package shell
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ** Functions **
+// ------------------------------------------------------------------------------------------
+
+// Function defined with name() { commands; }, its body runs like a builtin in the current shell
+type function struct {
+	body   block
+	source string // the definition as it was typed, type prints it
+}
+
+// Command run inside the shell for a name: a builtin or a function. Builtins win over functions of the same
+// name unless functions_first is set, as in bash
+func (s *Shell) internal(name string) (CommandFunc, bool) {
+	if s.isFunction(name) {
+		fn := s.functions[name]
+		return func(args []string, std Streams) error {
+			return s.callFunction(fn, args, std)
+		}, true
+	}
+	builtin, isBuiltin := s.commands[name]
+	return builtin, isBuiltin
+}
+
+// Whether a name runs a function rather than a builtin or an external command
+func (s *Shell) isFunction(name string) bool {
+	_, isFunction := s.functions[name]
+	_, isBuiltin := s.commands[name]
+	return isFunction && (!isBuiltin || s.options.Get("functions_first"))
+}
+
+// Runs a function's body on the std streams with its arguments as the positional parameters, which are
+// restored when it returns along with the variables it made local. Its status is the status of the last
+// command it ran, or the one given to return
+func (s *Shell) callFunction(fn *function, args []string, std Streams) error {
+	saved := s.positional
+	s.positional = args
+	s.locals = append(s.locals, s.newScope())
+	defer func() {
+		s.locals[len(s.locals)-1].restore()
+		s.locals = s.locals[:len(s.locals)-1]
+		s.positional = saved
+		s.returning = false
+	}()
+	return s.runBlock(fn.body, std)
+}
+
+// Shell builtin return, leaves the function being run with status n, the status of the last command by default
+func (s *Shell) _return(args []string, std Streams) error {
+	if len(s.locals) == 0 {
+		return fmt.Errorf("return: can only 'return' from a function")
+	}
+	if len(args) > 1 {
+		return fmt.Errorf("return: too many arguments")
+	}
+	status := s.status
+	if len(args) == 1 {
+		n, err := strconv.Atoi(strings.TrimSpace(args[0]))
+		if err != nil {
+			fmt.Fprintf(std.stderr, "return: %s: numeric argument required\n", args[0])
+			n = 2
+		}
+		status = (n%256 + 256) % 256
+	}
+	s.returning = true
+	if status != 0 {
+		return ExitStatus(status)
+	}
+	return nil
+}
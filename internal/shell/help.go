@@ -0,0 +1,387 @@
+package shell
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ** Help **
+// ------------------------------------------------------------------------------------------
+
+// Documentation of a builtin, the single source for help output and rendered man/markdown pages
+type BuiltinDoc struct {
+	Usage       string
+	Description string
+	Flags       [][2]string // flag and what it does
+}
+
+var builtinDocs = map[string]BuiltinDoc{
+	"alias": {
+		Usage:       "alias [-p] [name[=value] ...]",
+		Description: "Define command aliases, or print the named ones. Without arguments or with -p, list every alias. An alias is expanded when it is the first word of a command, and again when its value starts with another alias, but never inside its own expansion; when its value ends with a space the next word is checked for an alias too.",
+	},
+	"bg": {
+		Usage:       "bg [jobspec ...]",
+		Description: "Resume stopped jobs in the background. Without a jobspec the current job is used.",
+	},
+	"bind": {
+		Usage:       "bind [-lpX] [-r keyseq] [-x keyseq:shell-command] [keyseq:readline-function]",
+		Description: "Bind key sequences to line editor functions or shell commands. Key sequences use readline notation, e.g. \"\\C-g\".",
+		Flags: [][2]string{
+			{"-l", "list the names of all editor functions"},
+			{"-p", "print the key sequences bound to editor functions"},
+			{"-X", "print the key sequences bound to shell commands"},
+			{"-r keyseq", "remove the binding of keyseq"},
+			{"-x keyseq:command", "run command when keyseq is pressed"},
+		},
+	},
+	"capture": {
+		Usage:       "capture [-a] file command [arg ...]",
+		Description: "Run command on a pseudo-terminal of its own, so it behaves as on the shell's terminal (colors, line editing, Ctrl+C), showing its output and writing a copy to file. The terminal size follows the shell's.",
+		Flags: [][2]string{
+			{"-a", "append to file instead of overwriting it"},
+		},
+	},
+	"cd": {
+		Usage:       "cd dir",
+		Description: "Change the current directory to dir. ~ is replaced by $HOME, ~N (or ~+N) by entry N of the directory stack and ~-N by entry N counting from the bottom.",
+	},
+	"clear": {
+		Usage:       "clear [-a | -x]",
+		Description: "Clear the terminal screen. Without flags the system clear command is run. cls is an alias.",
+		Flags: [][2]string{
+			{"-x", "clear only the visible screen, keeping the scrollback"},
+			{"-a", "clear the visible screen and the scrollback"},
+		},
+	},
+	"declare": {
+		Usage:       "declare [-airx] [-p] [name[=value] ...]",
+		Description: "Set variable values and attributes. Using + instead of - removes an attribute. Without names, list variables having the given attributes. typeset is a synonym.",
+		Flags: [][2]string{
+			{"-a", "make name an indexed array, assign elements with name=(a b c), expand them with ${name[i]} or ${name[@]}"},
+			{"-i", "make name an integer, assignments are evaluated arithmetically"},
+			{"-r", "make name readonly"},
+			{"-x", "export name to the environment of commands"},
+			{"-p", "print the attributes and value of each name"},
+		},
+	},
+	"dirs": {
+		Usage:       "dirs [-clpv] [+N | -N]",
+		Description: "Display the directory stack, the current directory first. +N shows the Nth entry counting from the top (0 is the current directory), -N counting from the bottom.",
+		Flags: [][2]string{
+			{"-c", "clear the directory stack"},
+			{"-l", "show full paths instead of abbreviating $HOME to ~"},
+			{"-p", "print one entry per line"},
+			{"-v", "print one entry per line, numbered"},
+		},
+	},
+	"disown": {
+		Usage:       "disown [-ah] [jobspec ...]",
+		Description: "Remove jobs from the job table so they are neither reported nor sent SIGHUP when the shell exits. Without a jobspec the current job is used.",
+		Flags: [][2]string{
+			{"-a", "disown every job"},
+			{"-h", "keep the jobs in the table but do not send them SIGHUP on exit"},
+		},
+	},
+	"echo": {
+		Usage:       "echo [arg ...]",
+		Description: "Write the arguments, separated by spaces and followed by a newline, to standard output.",
+	},
+	"exec": {
+		Usage:       "exec [-a name] [command [arg ...]] [redirection ...]",
+		Description: "Replace the shell with command, which gets the terminal modes the shell started with and any redirections. Without a command, apply the redirections to the shell itself for the rest of the session, e.g. exec > session.log 2>&1.",
+		Flags: [][2]string{
+			{"-a name", "pass name to command as its argv[0]"},
+		},
+	},
+	"exit": {
+		Usage:       "exit [n]",
+		Description: "Exit the shell with status n modulo 256, or the status of the last command when n is omitted. A non-numeric n exits with status 2. Running jobs are sent SIGHUP. While jobs are stopped the first exit is refused, the shell exits when exit is run again right after.",
+	},
+	"export": {
+		Usage:       "export [-n] [-p] [name[=value] ...]",
+		Description: "Mark each name for export to the environment of the commands the shell runs, assigning value first when it is given. Without names, list the exported variables.",
+		Flags: [][2]string{
+			{"-n", "remove the export mark from each name instead"},
+			{"-p", "list the exported variables"},
+		},
+	},
+	"fg": {
+		Usage:       "fg [jobspec]",
+		Description: "Resume a job in the foreground and wait for it. Without a jobspec the current job is used.",
+	},
+	"help": {
+		Usage:       "help [-ds] [pattern ...]",
+		Description: "Display information about builtin commands. Without a pattern, list the usage of every builtin.",
+		Flags: [][2]string{
+			{"-d", "output a short description for each builtin"},
+			{"-s", "output only the usage synopsis for each builtin"},
+		},
+	},
+	"history": {
+		Usage:       "history [n] | -c | -d offset[-last] | -i | [-s text] [--cwd dir] [--since when] [--until when] [--failed] [--status n] [--longer duration] [n]",
+		Description: "Display the numbered history list, or only its last n entries. Entries deleted with -d are deleted from the history store too, -c leaves the store alone unless histappend is unset, the history list then replaces the store's entries when the shell exits. HISTSTORE picks the store: file (the default), sqlite for a database searched quickly even with hundreds of thousands of entries (needs the sqlite3 command), or memory; HISTFILE is its path and HISTFILESIZE the number of entries it keeps, HISTSIZE when unset.",
+		Flags: [][2]string{
+			{"-c", "clear the history list of this session, the history store keeps its entries"},
+			{"-d offset", "delete the entry at offset, negative offsets count back from the end"},
+			{"-d first-last", "delete the entries from first to last, e.g. 10-12 or -3--1"},
+			{"-s text", "search the history store for the lines containing text; matches are printed oldest first with their start time, status, duration and directory, only the last n with n"},
+			{"--cwd dir", "search for the lines run in dir, e.g. --cwd ."},
+			{"--since when", "search for the lines run at or after when: a duration ago (30m, 1h, 2d), today, yesterday or a date and time (2024-05-01 14:00)"},
+			{"--until when", "search for the lines run before when"},
+			{"--failed", "search for the lines that ended with a non-zero status"},
+			{"--status n", "search for the lines that ended with status n"},
+			{"--longer duration", "search for the lines that ran at least duration (5s, 2m)"},
+			{"-i", "pick an entry in a menu and put it on the next prompt for editing; type to filter, arrows move, Enter picks, Ctrl+G cancels"},
+		},
+	},
+	"jobs": {
+		Usage:       "jobs [-lprs] [jobspec ...]",
+		Description: "List jobs, the commands started with & and the foreground commands stopped with Ctrl+Z, with their state: Running, Stopped, Done, or Exit and the status of a failed job. Finished jobs are forgotten once listed.",
+		Flags: [][2]string{
+			{"-l", "also print each job's process id, and its process group id when it differs"},
+			{"-p", "print only the process group id of each job"},
+			{"-r", "list only running jobs"},
+			{"-s", "list only stopped jobs"},
+		},
+	},
+	"kill": {
+		Usage:       "kill [-s sigspec | -n signum | -sigspec] pid | jobspec ... or kill -l [sigspec]",
+		Description: "Send a signal, SIGTERM by default, to processes given by PID and jobs given by jobspec. A stopped job is continued so it gets the signal. Signals are given by name, with or without SIG, or by number.",
+		Flags: [][2]string{
+			{"-s sigspec", "send the named signal"},
+			{"-n signum", "send the signal with that number"},
+			{"-l", "list the signal names, or convert the given signal numbers or exit statuses to names"},
+		},
+	},
+	"local": {
+		Usage:       "local [-airx] [-p] [name[=value] ...]",
+		Description: "Declare variables local to the function being run, with declare's options: they are restored, or unset when they didn't exist, when the function returns. Functions it calls see them. Without names, list the function's local variables.",
+	},
+	"popd": {
+		Usage:       "popd [+N | -N]",
+		Description: "Remove the top entry of the directory stack and change to the new top. +N or -N removes that entry instead, without changing directory.",
+	},
+	"printf": {
+		Usage:       "printf [-v var] format [arguments]",
+		Description: "Print the arguments under the control of format, like printf(1). Besides the usual conversions, %b expands backslash escapes in its argument (\\c there ends the output) and %q quotes it for reuse as shell input. The format is reused until every argument is consumed.",
+		Flags: [][2]string{
+			{"-v var", "assign the output to the shell variable var instead of printing it"},
+		},
+	},
+	"pushd": {
+		Usage:       "pushd [dir | +N | -N]",
+		Description: "Save the current directory on the directory stack and change to dir. Without arguments the top two entries are swapped; +N or -N rotates the stack so that entry becomes the top.",
+	},
+	"pwd": {
+		Usage:       "pwd",
+		Description: "Print the current working directory.",
+	},
+	"read": {
+		Usage:       "read [-r] [-a array] [-p prompt] [-t timeout] [name ...]",
+		Description: "Read a line from standard input and split it on IFS into words, assigning them to the names in order; the last name gets the rest of the line. Without names the line is assigned to REPLY. The exit status is 1 at end of file, 130 when interrupted with Ctrl+C and 142 on timeout.",
+		Flags: [][2]string{
+			{"-a array", "assign the words to the indexed array array"},
+			{"-p prompt", "print prompt on standard error before reading, when reading from a terminal"},
+			{"-r", "do not treat backslashes as escapes"},
+			{"-t timeout", "give up after timeout seconds (fractions allowed), assigning what was read"},
+		},
+	},
+	"return": {
+		Usage:       "return [n]",
+		Description: "Leave the function being run with status n modulo 256, or the status of the last command when n is omitted. The rest of the function's body is skipped. Outside a function return fails.",
+	},
+	"set": {
+		Usage:       "set [-eCHx] [+eCHx] [-o option] [+o option] [--] [arg ...]",
+		Description: "Set shell options, or list every shell variable without arguments. Using + instead of - disables an option. The options are the ones shopt manages. Arguments after the options, or after --, become the positional parameters $1, $2 and on; set -- alone clears them.",
+		Flags: [][2]string{
+			{"-e", "errexit: exit when a command fails outside an && or || list"},
+			{"-x", "xtrace: print commands and their arguments before running them"},
+			{"-C", "noclobber: > refuses to overwrite existing files"},
+			{"-H", "histexpand: expand ! history events"},
+			{"-o emacs", "edit lines with emacs-style keys, the default"},
+			{"-o vi", "edit lines with vi-style insert and command modes"},
+			{"-o option", "enable option, list the options without one"},
+			{"+o option", "disable option, print set commands restoring the options without one"},
+		},
+	},
+	"setopt": {
+		Usage:       "setopt [optname ...]",
+		Description: "Enable shell options, zsh style. Without names, list the enabled options.",
+	},
+	"shopt": {
+		Usage:       "shopt [-pqsu] [optname ...]",
+		Description: "Set and query shell options. Without flags, list the options and whether they are on. interactive and login_shell describe how the shell was started and cannot be set.",
+		Flags: [][2]string{
+			{"-s", "enable each optname"},
+			{"-u", "disable each optname"},
+			{"-q", "print nothing, the exit status tells whether every optname is on"},
+			{"-p", "print the options as shopt commands that restore them"},
+		},
+	},
+	"type": {
+		Usage:       "type [-p] name [name ...]",
+		Description: "Tell how each name resolves as a command, in the order the shell resolves it: an alias and its value, a shell keyword, a function and its definition, a shell builtin or the path of the executable it runs. Builtins come before functions of the same name unless functions_first is set. Executables are resolved through the same cached PATH lookup the shell runs commands with.",
+		Flags: [][2]string{
+			{"-p", "print only the path of the executable, nothing for aliases, keywords, functions and builtins"},
+		},
+	},
+	"unalias": {
+		Usage:       "unalias [-a] name [name ...]",
+		Description: "Remove the named aliases.",
+		Flags: [][2]string{
+			{"-a", "remove every alias"},
+		},
+	},
+	"unset": {
+		Usage:       "unset [-f | -v] name ...",
+		Description: "Remove each variable or function name. Without an option a name that isn't a variable is taken for a function. Readonly variables can't be unset.",
+		Flags: [][2]string{
+			{"-f", "remove functions"},
+			{"-v", "remove variables only"},
+		},
+	},
+	"unsetopt": {
+		Usage:       "unsetopt [optname ...]",
+		Description: "Disable shell options, zsh style. Without names, list the disabled options.",
+	},
+	"wait": {
+		Usage:       "wait [jobspec ...]",
+		Description: "Wait for the given jobs to finish, or for every job when called without arguments. Jobs that finished are reported right away.",
+	},
+}
+
+// Aliases of builtins documented under another name
+var builtinDocAliases = map[string]string{
+	"cls":     "clear",
+	"typeset": "declare",
+}
+
+// Documentation of a builtin, following aliases
+func builtinDoc(name string) (BuiltinDoc, bool) {
+	if alias, exists := builtinDocAliases[name]; exists {
+		name = alias
+	}
+	doc, exists := builtinDocs[name]
+	return doc, exists
+}
+
+// Names of the documented builtins, sorted
+func builtinDocNames() []string {
+	names := []string{}
+	for name := range builtinDocs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Shell builtin help
+func (s *Shell) help(args []string, std Streams) error {
+	short, synopsis := false, false
+	i := 0
+	for ; i < len(args) && strings.HasPrefix(args[i], "-"); i++ {
+		for _, f := range args[i][1:] {
+			switch f {
+			case 'd':
+				short = true
+			case 's':
+				synopsis = true
+			default:
+				return fmt.Errorf("help: -%c: invalid option\nhelp: usage: %s", f, builtinDocs["help"].Usage)
+			}
+		}
+	}
+
+	patterns := args[i:]
+	if len(patterns) == 0 {
+		fmt.Fprintln(std.stdout, "These shell commands are defined internally. Type 'help name' to find out more about the command 'name'.")
+		fmt.Fprintln(std.stdout)
+		for _, name := range builtinDocNames() {
+			fmt.Fprintln(std.stdout, " "+builtinDocs[name].Usage)
+		}
+		return nil
+	}
+
+	for _, pattern := range patterns {
+		names := []string{}
+		if _, exists := builtinDoc(pattern); exists {
+			names = append(names, pattern)
+		} else {
+			for _, name := range builtinDocNames() {
+				if matched, _ := filepath.Match(pattern, name); matched || strings.HasPrefix(name, pattern) {
+					names = append(names, name)
+				}
+			}
+		}
+		if len(names) == 0 {
+			return fmt.Errorf("help: no help topics match '%s'", pattern)
+		}
+
+		for _, name := range names {
+			doc, _ := builtinDoc(name)
+			switch {
+			case short:
+				fmt.Fprintf(std.stdout, "%s - %s\n", name, doc.Description)
+			case synopsis:
+				fmt.Fprintf(std.stdout, "%s: %s\n", name, doc.Usage)
+			default:
+				fmt.Fprintf(std.stdout, "%s: %s\n    %s\n", name, doc.Usage, doc.Description)
+				if len(doc.Flags) > 0 {
+					fmt.Fprintln(std.stdout)
+					fmt.Fprintln(std.stdout, "    Options:")
+					for _, flag := range doc.Flags {
+						fmt.Fprintf(std.stdout, "      %-20s%s\n", flag[0], flag[1])
+					}
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// Renders the builtin documentation as a man page ("man") or markdown ("markdown")
+func RenderDocs(w io.Writer, format string) error {
+	switch format {
+	case "man":
+		fmt.Fprintln(w, ".TH MYSHELL-BUILTINS 1")
+		fmt.Fprintln(w, ".SH NAME")
+		fmt.Fprintln(w, "myshell-builtins \\- commands built into myshell")
+		fmt.Fprintln(w, ".SH BUILTINS")
+		for _, name := range builtinDocNames() {
+			doc := builtinDocs[name]
+			fmt.Fprintf(w, ".TP\n.B %s\n%s\n", roffEscape(doc.Usage), roffEscape(doc.Description))
+			if len(doc.Flags) > 0 {
+				fmt.Fprintln(w, ".RS")
+				for _, flag := range doc.Flags {
+					fmt.Fprintf(w, ".TP\n.B %s\n%s\n", roffEscape(flag[0]), roffEscape(flag[1]))
+				}
+				fmt.Fprintln(w, ".RE")
+			}
+		}
+	case "markdown":
+		fmt.Fprintln(w, "# myshell builtins")
+		for _, name := range builtinDocNames() {
+			doc := builtinDocs[name]
+			fmt.Fprintf(w, "\n## %s\n\n`%s`\n\n%s\n", name, doc.Usage, doc.Description)
+			if len(doc.Flags) > 0 {
+				fmt.Fprintln(w, "\n| Flag | Description |\n| --- | --- |")
+				for _, flag := range doc.Flags {
+					fmt.Fprintf(w, "| `%s` | %s |\n", flag[0], flag[1])
+				}
+			}
+		}
+	default:
+		return fmt.Errorf("unknown docs format '%s', expected man or markdown", format)
+	}
+	return nil
+}
+
+// Escapes backslashes and leading dashes for roff
+func roffEscape(text string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(text, "\\", "\\e"), "-", "\\-")
+}
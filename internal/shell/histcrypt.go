@@ -0,0 +1,173 @@
+package shell
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"strings"
+)
+
+// ** History Secrets **
+// ------------------------------------------------------------------------------------------
+
+// Lines that look like they carry a credential: password, token and key assignments or flags, authorization
+// headers, URLs with a password, and the formats of well-known API keys
+var secretPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)(passw(or)?d|passwd|secret|token|api[_-]?key|access[_-]?key|private[_-]?key|credentials?)[a-z_]*\s*[=:]\s*\S`),
+	regexp.MustCompile(`(?i)--?(passw(or)?d|secret|token|api-?key)([=\s]+)\S`),
+	regexp.MustCompile(`(?i)authorization:\s*(bearer|basic|token)\s+\S`),
+	regexp.MustCompile(`[a-z][a-z0-9+.-]*://[^/\s:@]+:[^/\s@]+@`),
+	regexp.MustCompile(`\b(AKIA|ASIA)[0-9A-Z]{16}\b`),
+	regexp.MustCompile(`\b(gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{22,})\b`),
+	regexp.MustCompile(`\b(sk|rk)-[A-Za-z0-9_-]{20,}\b`),
+	regexp.MustCompile(`\bxox[abposr]-[A-Za-z0-9-]{10,}\b`),
+	regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----`),
+}
+
+// Whether a line must be kept out of history with the histsecrets option: it matches one of the built-in
+// secret patterns or the extended regular expression in HISTSECRETS
+func (s *Shell) secret(line string) bool {
+	if !s.options.Get("histsecrets") {
+		return false
+	}
+	for _, pattern := range secretPatterns {
+		if pattern.MatchString(line) {
+			return true
+		}
+	}
+	if extra, _ := s.getVar("HISTSECRETS"); extra != "" {
+		if pattern, err := regexp.Compile(extra); err == nil && pattern.MatchString(line) {
+			return true
+		}
+	}
+	return false
+}
+
+// ** History Encryption **
+// ------------------------------------------------------------------------------------------
+
+// Marks an encrypted entry in the history file, the rest of the line is the base64 nonce and ciphertext
+const encryptedPrefix = "!aes:"
+
+// Keyring item holding the history key
+const (
+	keyringService = "myshell"
+	keyringAccount = "history"
+)
+
+// Key the history file is encrypted with, read from the system keyring and created there on first use.
+// The key (or the failure to get it) is looked up once per session
+func (s *Shell) historyKey() ([]byte, error) {
+	if s.histKey != nil || s.histKeyErr != nil {
+		return s.histKey, s.histKeyErr
+	}
+	key, err := lookupKey()
+	if err == nil && key == nil {
+		key, err = createKey()
+	}
+	if err != nil {
+		s.histKeyErr = fmt.Errorf("history: no key in the keyring: %v", err)
+		fmt.Fprintf(os.Stderr, "%v\r\n", s.histKeyErr)
+		return nil, s.histKeyErr
+	}
+	s.histKey = key
+	return key, nil
+}
+
+// Reads the history key from the keyring: secret-tool (libsecret) on Linux and the BSDs, security on macOS.
+// A nil key without an error means the keyring has none yet
+func lookupKey() ([]byte, error) {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("security", "find-generic-password", "-s", keyringService, "-a", keyringAccount, "-w")
+	case "windows":
+		return nil, fmt.Errorf("no supported keyring on %s", runtime.GOOS)
+	default:
+		cmd = exec.Command("secret-tool", "lookup", "service", keyringService, "account", keyringAccount)
+	}
+	out, err := cmd.Output()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && len(out) == 0 {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(out)))
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("malformed key")
+	}
+	return key, nil
+}
+
+// Generates a random history key and stores it in the keyring
+func createKey() ([]byte, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	encoded := hex.EncodeToString(key)
+
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("security", "add-generic-password", "-s", keyringService, "-a", keyringAccount, "-w", encoded)
+	case "windows":
+		return nil, fmt.Errorf("no supported keyring on %s", runtime.GOOS)
+	default:
+		cmd = exec.Command("secret-tool", "store", "--label=myshell history", "service", keyringService, "account", keyringAccount)
+		cmd.Stdin = strings.NewReader(encoded)
+	}
+	if err := cmd.Run(); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// Encrypts a formatted history entry into a single line with AES-256-GCM
+func encryptEntry(key []byte, text string) (string, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(text), nil)
+	return encryptedPrefix + base64.StdEncoding.EncodeToString(sealed) + "\n", nil
+}
+
+// Decrypts a line written by encryptEntry back into the formatted entry
+func decryptEntry(key []byte, line string) (string, error) {
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(line, encryptedPrefix))
+	if err != nil {
+		return "", err
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return "", fmt.Errorf("truncated entry")
+	}
+	text, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	return string(text), err
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,241 @@
+package shell
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/codecrafters-io/shell-starter-go/internal/editor"
+)
+
+// ** History Expansion **
+// ------------------------------------------------------------------------------------------
+
+// Characters after '!' that leave it alone
+const histExpandStop = " \t\n=(\""
+
+// Expands the history events of a line before it is parsed: !! is the previous command, !N command N, !-N the
+// Nth previous one, !prefix the most recent command starting with prefix and !?string? the most recent one
+// containing string. A word designator after the event picks some of its words (!!:1, !ls:$), !$ !^ !* and
+// !:N pick words of the previous command. ^old^new at the start of the line is the previous command with old
+// replaced. Nothing is expanded inside single quotes or after a backslash
+func (s *Shell) expandHistory(line string) (string, error) {
+	entries := s.editor.History().Entries()
+	if strings.HasPrefix(line, "^") {
+		return quickSubstitute(entries, line)
+	}
+	if !strings.Contains(line, "!") {
+		return line, nil
+	}
+
+	var expanded strings.Builder
+	singleQuote, doubleQuote, backslash := false, false, false
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case backslash:
+			backslash = false
+		case c == '\\' && !singleQuote:
+			backslash = true
+		case c == '\'' && !doubleQuote:
+			singleQuote = !singleQuote
+		case c == '"' && !singleQuote:
+			doubleQuote = !doubleQuote
+		case c == '!' && !singleQuote && i+1 < len(line) && !strings.ContainsRune(histExpandStop, rune(line[i+1])):
+			event, n, err := expandEvent(entries, line[i+1:])
+			if err != nil {
+				return "", err
+			}
+			expanded.WriteString(event)
+			i += n
+			continue
+		}
+		expanded.WriteByte(c)
+	}
+	return expanded.String(), nil
+}
+
+// Expands ^old^new^rest: the previous command with the first old replaced by new, followed by rest. The last
+// '^' and new can be left out
+func quickSubstitute(entries []editor.HistoryEntry, line string) (string, error) {
+	if len(entries) == 0 {
+		return "", fmt.Errorf("!!: event not found")
+	}
+	old, replacement, _ := strings.Cut(line[1:], "^")
+	replacement, rest, _ := strings.Cut(replacement, "^")
+	previous := entries[len(entries)-1].Line
+	if old == "" || !strings.Contains(previous, old) {
+		return "", fmt.Errorf(":s%s: substitution failed", line)
+	}
+	return strings.Replace(previous, old, replacement, 1) + rest, nil
+}
+
+// Expands the event designator and the word designator following it (the text after '!'), returning the
+// expansion and the length of the designators
+func expandEvent(entries []editor.HistoryEntry, designator string) (string, int, error) {
+	// !$, !^ and !* are words of the previous command
+	event, n := "", 0
+	if strings.IndexByte("^$*", designator[0]) != -1 {
+		if len(entries) == 0 {
+			return "", 0, fmt.Errorf("!%c: event not found", designator[0])
+		}
+		event = entries[len(entries)-1].Line
+	} else {
+		var err error
+		if event, n, err = findEvent(entries, designator); err != nil {
+			return "", 0, err
+		}
+	}
+
+	spec := designator[n:]
+	switch {
+	case strings.HasPrefix(spec, ":") && len(spec) > 1 && strings.IndexByte("0123456789^$*-", spec[1]) != -1:
+		spec, n = spec[1:], n+1
+	case spec != "" && strings.IndexByte("^$*", spec[0]) != -1:
+	default:
+		return event, n, nil
+	}
+
+	words := historyWords(event)
+	from, to, length, ok := wordRange(spec, len(words)-1)
+	// x* and * select no words when x is past the last one
+	if !ok || to > len(words)-1 || from > to && (from > to+1 || spec[length-1] != '*') {
+		return "", 0, fmt.Errorf(":%s: bad word specifier", spec[:max(length, 1)])
+	}
+	return strings.Join(words[from:to+1], " "), n + length, nil
+}
+
+// Range of words a word designator selects, from the start of spec: N, ^ (word 1), $ (the last word), x-y, x*
+// (x-$), x- (x-$ without the last word), -y (0-y) and * (all but the command, possibly none). length is the
+// length of the designator
+func wordRange(spec string, last int) (from, to, length int, ok bool) {
+	word := func(i int) (int, int, bool) {
+		switch {
+		case i >= len(spec):
+			return 0, 0, false
+		case spec[i] == '^':
+			return 1, 1, true
+		case spec[i] == '$':
+			return last, 1, true
+		}
+		j := i
+		for j < len(spec) && spec[j] >= '0' && spec[j] <= '9' {
+			j++
+		}
+		n, err := strconv.Atoi(spec[i:j])
+		return n, j - i, err == nil
+	}
+
+	if strings.HasPrefix(spec, "*") {
+		return 1, last, 1, true
+	}
+	if strings.HasPrefix(spec, "-") {
+		spec = "0" + spec
+		from, to, length, ok = wordRange(spec, last)
+		return from, to, length - 1, ok
+	}
+	from, length, ok = word(0)
+	if !ok {
+		return 0, 0, 0, false
+	}
+	switch {
+	case strings.HasPrefix(spec[length:], "*"):
+		return from, last, length + 1, true
+	case strings.HasPrefix(spec[length:], "-"):
+		if to, n, ok := word(length + 1); ok {
+			return from, to, length + 1 + n, true
+		}
+		return from, last - 1, length + 1, true
+	}
+	return from, from, length, true
+}
+
+// Splits a command line into words the way word designators count them: at unquoted blanks, with quotes kept
+// in the words and each run of operator characters a word of its own
+func historyWords(line string) []string {
+	words := []string{}
+	var word strings.Builder
+	flush := func() {
+		if word.Len() > 0 {
+			words = append(words, word.String())
+			word.Reset()
+		}
+	}
+	singleQuote, doubleQuote, backslash := false, false, false
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		quoted := singleQuote || doubleQuote || backslash
+		switch {
+		case backslash:
+			backslash = false
+		case c == '\\' && !singleQuote:
+			backslash = true
+		case c == '\'' && !doubleQuote:
+			singleQuote = !singleQuote
+		case c == '"' && !singleQuote:
+			doubleQuote = !doubleQuote
+		}
+		switch {
+		case quoted:
+			word.WriteByte(c)
+		case c == ' ' || c == '\t':
+			flush()
+		case strings.IndexByte("|&;<>()", c) != -1:
+			if word.Len() > 0 && strings.IndexByte("|&;<>()", word.String()[word.Len()-1]) == -1 {
+				flush()
+			}
+			word.WriteByte(c)
+			if i+1 >= len(line) || strings.IndexByte("|&;<>()", line[i+1]) == -1 {
+				flush()
+			}
+		default:
+			word.WriteByte(c)
+		}
+	}
+	flush()
+	return words
+}
+
+// Finds the command an event designator (the text after '!') refers to, returning it and the length of the designator
+func findEvent(entries []editor.HistoryEntry, designator string) (string, int, error) {
+	n := 0
+	var match func(i int) bool
+	switch {
+	case designator[0] == '!':
+		n = 1
+		match = func(int) bool { return true }
+	case designator[0] == '?':
+		end := strings.IndexByte(designator[1:], '?')
+		search := designator[1:]
+		n = len(designator)
+		if end != -1 {
+			search = designator[1 : end+1]
+			n = end + 2
+		}
+		match = func(i int) bool { return strings.Contains(entries[i].Line, search) }
+	default:
+		// A word designator starting with ^, $ or * can follow a prefix without the ':'
+		for n < len(designator) && !strings.ContainsRune(" \t\n;&|<>()\"':^$*", rune(designator[n])) {
+			n++
+		}
+		word := designator[:n]
+		if number, err := strconv.Atoi(word); err == nil {
+			index := number - 1
+			if number < 0 {
+				index = len(entries) + number
+			}
+			if index < 0 || index >= len(entries) || number == 0 {
+				return "", 0, fmt.Errorf("!%s: event not found", word)
+			}
+			return entries[index].Line, n, nil
+		}
+		match = func(i int) bool { return strings.HasPrefix(entries[i].Line, word) }
+	}
+
+	for i := len(entries) - 1; i >= 0; i-- {
+		if match(i) {
+			return entries[i].Line, n, nil
+		}
+	}
+	return "", 0, fmt.Errorf("!%s: event not found", designator[:n])
+}
@@ -0,0 +1,101 @@
+package shell
+
+import (
+	"testing"
+)
+
+// Shell whose history holds the given lines, oldest first
+func historyShell(t *testing.T, lines ...string) *Shell {
+	s := scriptShell(t)
+	for _, line := range lines {
+		s.editor.History().Add(line, "/")
+	}
+	return s
+}
+
+// History expansion cases: the line as typed, what it expands to or the error it fails with
+type expansionTest struct {
+	line, want, err string
+}
+
+// Expands each line against the history of s
+func testExpansions(t *testing.T, s *Shell, tests []expansionTest) {
+	for _, test := range tests {
+		got, err := s.expandHistory(test.line)
+		errText := ""
+		if err != nil {
+			errText = err.Error()
+		}
+		if got != test.want || errText != test.err {
+			t.Errorf("expandHistory(%q) = %q, %q, want %q, %q", test.line, got, errText, test.want, test.err)
+		}
+	}
+}
+
+// !!, !N, !-N, !prefix, !?string? and ^old^new name commands of the history
+func TestExpandHistoryEvents(t *testing.T) {
+	s := historyShell(t, "echo one two", "ls -la /tmp", "git commit -m 'message'")
+	testExpansions(t, s, []expansionTest{
+		{"sudo !!", "sudo git commit -m 'message'", ""},
+		{"!1 | cat", "echo one two | cat", ""},
+		{"!-2", "ls -la /tmp", ""},
+		{"!ec; !l", "echo one two; ls -la /tmp", ""},
+		{"!?la?x", "ls -la /tmpx", ""},
+		{"!?one", "echo one two", ""},
+		{"echo !!!", "echo git commit -m 'message'!", ""},
+
+		// Left alone: quoted, escaped, or followed by what can't start an event
+		{"echo '!!' \\!! ! !=", "echo '!!' \\!! ! !=", ""},
+		{"echo \"!-3\"", "echo \"echo one two\"", ""},
+		{"no events", "no events", ""},
+
+		{"!4", "", "!4: event not found"},
+		{"!0", "", "!0: event not found"},
+		{"!-4", "", "!-4: event not found"},
+		{"!nope", "", "!nope: event not found"},
+		{"!?nope?", "", "!?nope?: event not found"},
+
+		{"^message^fix", "git commit -m 'fix'", ""},
+		{"^commit^log^ -1", "git log -m 'message' -1", ""},
+		{"^ -m 'message'", "git commit", ""},
+		{"^nope^x", "", ":s^nope^x: substitution failed"},
+	})
+
+	testExpansions(t, historyShell(t), []expansionTest{
+		{"!!", "", "!!: event not found"},
+		{"^a^b", "", "!!: event not found"},
+	})
+}
+
+// Word designators pick words of an event, !$ !^ !* and !:N those of the previous command
+func TestExpandWordDesignators(t *testing.T) {
+	s := historyShell(t, "cp 'a b' dir/ && ls x>y", "vim main.go notes.txt")
+	testExpansions(t, s, []expansionTest{
+		{"ls !$", "ls notes.txt", ""},
+		{"ls !^", "ls main.go", ""},
+		{"rm !*", "rm main.go notes.txt", ""},
+		{"!:0 !:2", "vim notes.txt", ""},
+		{"!!:1", "main.go", ""},
+		{"!cp:1", "'a b'", ""},
+		{"!cp:$", "y", ""},
+		{"!cp:2-4", "dir/ && ls", ""},
+		{"!cp:3*", "&& ls x > y", ""},
+		{"!cp:4-", "ls x >", ""},
+		{"!cp:-2", "cp 'a b' dir/", ""},
+		{"!cp^ !cp*", "'a b' 'a b' dir/ && ls x > y", ""},
+		{"!vim$.bak", "notes.txt.bak", ""},
+
+		{"!:3", "", ":3: bad word specifier"},
+		{"!!:2-5", "", ":2-5: bad word specifier"},
+		{"!!:3*", "", ""},
+		{"!!:4*", "", ":4*: bad word specifier"},
+	})
+
+	testExpansions(t, historyShell(t, "true"), []expansionTest{
+		{"echo !*", "echo ", ""},
+		{"!$", "true", ""},
+	})
+	testExpansions(t, historyShell(t), []expansionTest{
+		{"!$", "", "!$: event not found"},
+	})
+}
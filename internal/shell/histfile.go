@@ -0,0 +1,245 @@
+package shell
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/codecrafters-io/shell-starter-go/internal/editor"
+)
+
+// ** History File **
+// ------------------------------------------------------------------------------------------
+
+// History file in the home directory when HISTFILE isn't set
+const defaultHistFile = ".myshell_history"
+
+// History database in the home directory with HISTSTORE=sqlite when HISTFILE isn't set
+const defaultHistDB = ".myshell_history.db"
+
+// Some entries of the history file are encrypted and the keyring has no key for them
+var errUndecryptable = errors.New("encrypted entries can't be read without the key")
+
+// Path of the history file: HISTFILE, or ~/.myshell_history (~/.myshell_history.db with HISTSTORE=sqlite).
+// An empty HISTFILE keeps history in memory only
+func (s *Shell) historyFile() string {
+	if path, exists := s.getVar("HISTFILE"); exists {
+		return path
+	}
+	home, _ := s.getVar("HOME")
+	if home == "" {
+		return ""
+	}
+	if kind, _ := s.getVar("HISTSTORE"); kind == "sqlite" {
+		return filepath.Join(home, defaultHistDB)
+	}
+	return filepath.Join(home, defaultHistFile)
+}
+
+// Loads the newest HISTSIZE entries of the history store into the line editor's history
+func (s *Shell) loadHistory() {
+	s.editor.History().SetLimit(s.historySize())
+	store, err := s.historyStore()
+	if err != nil {
+		s.report(err)
+		return
+	}
+	entries, err := store.Load(s.historySize())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "history: %s: %s\n", s.historyFile(), describeError(err))
+		if err != errUndecryptable {
+			return
+		}
+	}
+	s.editor.History().Load(entries)
+}
+
+// Appends an entry to the history store as soon as it is run, so it survives a crash and shows up in other
+// sessions. A store that can't be used is reported each time, the line isn't saved
+func (s *Shell) saveHistory(entry editor.HistoryEntry) {
+	store, err := s.historyStore()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "history: %v\r\n", err)
+		return
+	}
+	store.Append(entry)
+}
+
+// Trims the history store to the newest HISTFILESIZE entries, HISTSIZE when it isn't set, when the shell exits.
+// Without histappend an interactive shell's history list replaces the store's entries instead, as in bash:
+// the lines other sessions added since it started are lost
+func (s *Shell) flushHistory() {
+	size := s.historySize()
+	if value, exists := s.getVar("HISTFILESIZE"); exists {
+		if n, err := strconv.Atoi(strings.TrimSpace(value)); err == nil {
+			size = n
+		}
+	}
+	store, err := s.historyStore()
+	switch {
+	case err != nil:
+	case !s.options.Get("histappend") && s.options.Get("interactive"):
+		store.Replace(newestEntries(s.editor.History().Entries(), size))
+	case size >= 0:
+		store.Trim(size)
+	}
+}
+
+// Deletes entries deleted from the in-memory history from the history store too
+func (s *Shell) deleteHistory(entries []editor.HistoryEntry) error {
+	store, err := s.historyStore()
+	if err != nil {
+		return err
+	}
+	return store.Delete(entries)
+}
+
+// Writes entries to a history file. They are written aside and renamed over the file so an interrupted
+// write can't lose the history
+func (s *Shell) writeHistory(path string, entries []editor.HistoryEntry) error {
+	var content strings.Builder
+	for _, entry := range entries {
+		text, err := s.formatEntry(entry)
+		if err != nil {
+			return err
+		}
+		content.WriteString(text)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(content.String()), 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// An entry in the history file: its line, preceded by a comment with the time and directory it was run in
+// when they are known (#1700000000 /home/me/src), like bash's timestamp comments, and followed by how it
+// ended once it has finished
+func formatHistory(entry editor.HistoryEntry) string {
+	if entry.Time.IsZero() {
+		return entry.Line + "\n"
+	}
+	text := fmt.Sprintf("#%d %s\n%s\n", entry.Time.Unix(), entry.Dir, entry.Line)
+	if entry.Finished {
+		text += finishLine(entry)
+	}
+	return text
+}
+
+// Comment recording how an entry ended, its start time followed by its status and duration in milliseconds
+// (#=1700000000 1 250). Other sessions may have appended entries since, it applies to the newest one that
+// started at that time
+func finishLine(entry editor.HistoryEntry) string {
+	return fmt.Sprintf("#=%d %d %d\n", entry.Time.Unix(), entry.Status, entry.Duration.Milliseconds())
+}
+
+// How an entry ended as it is appended to the history file, encrypted with the histencrypt option
+func (s *Shell) formatFinish(entry editor.HistoryEntry) (string, error) {
+	if !s.options.Get("histencrypt") {
+		return finishLine(entry), nil
+	}
+	key, err := s.historyKey()
+	if err != nil {
+		return "", err
+	}
+	return encryptEntry(key, finishLine(entry))
+}
+
+// An entry as it is written to the history file, encrypted with the histencrypt option
+func (s *Shell) formatEntry(entry editor.HistoryEntry) (string, error) {
+	if !s.options.Get("histencrypt") {
+		return formatHistory(entry), nil
+	}
+	key, err := s.historyKey()
+	if err != nil {
+		return "", err
+	}
+	return encryptEntry(key, formatHistory(entry))
+}
+
+// Reads the entries of a history file, oldest first. Encrypted entries are decrypted with the key from the
+// keyring whether or not histencrypt is on; without it they are skipped and errUndecryptable is returned
+// with the others
+func (s *Shell) readHistory(path string) ([]editor.HistoryEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	entries := []editor.HistoryEntry{}
+	var meta editor.HistoryEntry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1<<20)
+	var failed error
+	lines := []string{}
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, encryptedPrefix) {
+			key, err := s.historyKey()
+			if err == nil {
+				line, err = decryptEntry(key, line)
+			}
+			if err != nil {
+				failed = errUndecryptable
+				continue
+			}
+			lines = append(lines, strings.Split(strings.TrimSuffix(line, "\n"), "\n")...)
+		} else {
+			lines = append(lines, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, line := range lines {
+		if finish, ok := strings.CutPrefix(line, "#="); ok && applyFinish(entries, finish) {
+			continue
+		}
+		if comment, ok := strings.CutPrefix(line, "#"); ok {
+			stamp, dir, _ := strings.Cut(comment, " ")
+			if seconds, err := strconv.ParseInt(stamp, 10, 64); err == nil {
+				meta = editor.HistoryEntry{Dir: dir, Time: time.Unix(seconds, 0)}
+				continue
+			}
+		}
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		meta.Line = line
+		entries = append(entries, meta)
+		meta = editor.HistoryEntry{}
+	}
+	return entries, failed
+}
+
+// Applies a #= comment to the newest entry that started at its time, false when it doesn't parse
+func applyFinish(entries []editor.HistoryEntry, finish string) bool {
+	fields := strings.Fields(finish)
+	if len(fields) != 3 {
+		return false
+	}
+	stamp, err1 := strconv.ParseInt(fields[0], 10, 64)
+	status, err2 := strconv.Atoi(fields[1])
+	millis, err3 := strconv.ParseInt(fields[2], 10, 64)
+	if err1 != nil || err2 != nil || err3 != nil {
+		return false
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		if !entries[i].Time.IsZero() && entries[i].Time.Unix() == stamp {
+			entries[i].Status, entries[i].Duration, entries[i].Finished = status, time.Duration(millis)*time.Millisecond, true
+			break
+		}
+	}
+	return true
+}
@@ -0,0 +1,227 @@
+package shell
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/codecrafters-io/shell-starter-go/internal/editor"
+	"golang.org/x/term"
+)
+
+// ** History **
+// ------------------------------------------------------------------------------------------
+
+// Shell builtin history, lists the numbered entries (the last n with an argument), -c clears the in-memory list,
+// -d deletes one entry or a range of them and -i picks an entry in a menu and puts it on the next prompt for
+// editing. -s and the long options search the history store
+func (s *Shell) history(args []string, std Streams) error {
+	if len(args) > 0 && (args[0] == "-s" || strings.HasPrefix(args[0], "--")) {
+		return s.searchHistory(args, std)
+	}
+	history := s.editor.History()
+	if len(args) == 0 || args[0] != "-c" && args[0] != "-d" && args[0] != "-i" {
+		if len(args) > 1 {
+			return fmt.Errorf("history: too many arguments")
+		}
+		entries := history.Entries()
+		first := 0
+		if len(args) == 1 {
+			n, err := strconv.Atoi(args[0])
+			if err != nil || n < 0 {
+				return fmt.Errorf("history: %s: numeric argument required", args[0])
+			}
+			first = max(0, len(entries)-n)
+		}
+		for i := first; i < len(entries); i++ {
+			if _, err := fmt.Fprintf(std.stdout, "%5d  %s\n", i+1, entries[i].Line); err != nil {
+				return fmt.Errorf("history: write error: %w", err)
+			}
+		}
+		return nil
+	}
+
+	switch args[0] {
+	case "-c":
+		// Like bash, only this session's list: the store keeps what every session ran
+		history.Clear()
+	case "-d":
+		if len(args) != 2 {
+			return fmt.Errorf("history: -d: option requires an argument")
+		}
+		from, to, ok := historyRange(args[1], len(history.Entries()))
+		if !ok || from < 1 || to > len(history.Entries()) || from > to {
+			return fmt.Errorf("history: %s: history position out of range", args[1])
+		}
+		deleted := append([]editor.HistoryEntry{}, history.Entries()[from-1:to]...)
+		history.DeleteRange(from-1, to-1)
+		if err := s.deleteHistory(deleted); err != nil {
+			return fmt.Errorf("history: %s: %s", s.historyFile(), describeError(err))
+		}
+	case "-i":
+		fd := int(os.Stdin.Fd())
+		if !term.IsTerminal(fd) {
+			return fmt.Errorf("history: -i: standard input is not a terminal")
+		}
+		state, err := term.MakeRaw(fd)
+		if err != nil {
+			return fmt.Errorf("history: %v", err)
+		}
+		line, ok := s.editor.PickHistory()
+		term.Restore(fd, state)
+		if ok {
+			s.editor.Prefill(line)
+		}
+	}
+	return nil
+}
+
+// history -s text, --cwd dir, --since when, --until when, --failed, --status n and --longer duration: prints
+// the stored entries meeting every condition, oldest first, with when and where they ran and how they ended.
+// A last argument n keeps the newest n matches
+func (s *Shell) searchHistory(args []string, std Streams) error {
+	var query HistoryQuery
+	limit := -1
+	for i := 0; i < len(args); i++ {
+		flag := args[i]
+		if !strings.HasPrefix(flag, "-") {
+			n, err := strconv.Atoi(flag)
+			if err != nil || n < 0 || i != len(args)-1 {
+				return fmt.Errorf("history: %s: numeric argument required", flag)
+			}
+			limit = n
+			continue
+		}
+		if flag == "--failed" {
+			query.Failed = true
+			continue
+		}
+		if i+1 >= len(args) {
+			return fmt.Errorf("history: %s: option requires an argument", flag)
+		}
+		i++
+		value := args[i]
+		switch flag {
+		case "-s":
+			query.Text = value
+		case "--cwd":
+			dir, err := filepath.Abs(value)
+			if err != nil {
+				return fmt.Errorf("history: %s: %v", value, err)
+			}
+			query.Dir = dir
+		case "--since", "--until":
+			when, ok := parseWhen(value, time.Now())
+			if !ok {
+				return fmt.Errorf("history: %s: invalid time, e.g. 1h, 2d, yesterday or 2024-05-01 14:00", value)
+			}
+			if flag == "--since" {
+				query.Since = when
+			} else {
+				query.Until = when
+			}
+		case "--status":
+			status, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("history: %s: numeric argument required", value)
+			}
+			query.Status = &status
+		case "--longer":
+			duration, err := parseInterval(value)
+			if err != nil {
+				return fmt.Errorf("history: %s: invalid duration", value)
+			}
+			query.MinDuration = duration
+		default:
+			return fmt.Errorf("history: %s: invalid option\nhistory: usage: %s", flag, builtinDocs["history"].Usage)
+		}
+	}
+
+	store, err := s.historyStore()
+	if err != nil {
+		return fmt.Errorf("history: %v", err)
+	}
+	found, err := store.Search(query, limit)
+	if err != nil && err != errUndecryptable {
+		return fmt.Errorf("history: %s: %s", s.historyFile(), describeError(err))
+	}
+	for i := len(found) - 1; i >= 0; i-- {
+		if _, err := fmt.Fprintln(std.stdout, describeEntry(found[i])); err != nil {
+			return fmt.Errorf("history: write error: %w", err)
+		}
+	}
+	return nil
+}
+
+// An entry as history searches print it: when it started, its status and duration, the directory and the line.
+// What isn't known is a dash
+func describeEntry(entry editor.HistoryEntry) string {
+	started, status, duration, dir := "-", "-", "-", entry.Dir
+	if !entry.Time.IsZero() {
+		started = entry.Time.Format(time.DateTime)
+	}
+	if entry.Finished {
+		status = strconv.Itoa(entry.Status)
+		duration = entry.Duration.Round(time.Millisecond).String()
+	}
+	if dir == "" {
+		dir = "-"
+	}
+	return fmt.Sprintf("%-19s  %3s  %8s  %s  %s", started, status, duration, dir, entry.Line)
+}
+
+// Time named by a history search option, relative to now: a duration ago (90s, 30m, 1h, 2d), today or
+// yesterday for their midnight, or a local date and time (2024-05-01, 2024-05-01 14:00)
+func parseWhen(text string, now time.Time) (time.Time, bool) {
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	switch text {
+	case "today":
+		return midnight, true
+	case "yesterday":
+		return midnight.AddDate(0, 0, -1), true
+	}
+	for _, layout := range []string{time.DateOnly, "2006-01-02 15:04", time.DateTime} {
+		if when, err := time.ParseInLocation(layout, text, now.Location()); err == nil {
+			return when, true
+		}
+	}
+	if ago, err := parseInterval(text); err == nil {
+		return now.Add(-ago), true
+	}
+	return time.Time{}, false
+}
+
+// Positions of the first and last entry named by the argument of history -d: an offset, or a start-end range.
+// Negative offsets count back from the end of the history, -1 being the last entry
+func historyRange(spec string, n int) (from, to int, ok bool) {
+	offset := func(s string) (int, bool) {
+		i, err := strconv.Atoi(s)
+		if err != nil || i == 0 {
+			return 0, false
+		}
+		if i < 0 {
+			i += n + 1
+		}
+		return i, true
+	}
+
+	// The separator is the first '-' that doesn't start the range
+	start, end, isRange := spec, spec, false
+	if i := strings.IndexByte(spec[min(1, len(spec)):], '-'); i != -1 {
+		start, end, isRange = spec[:i+1], spec[i+2:], true
+	}
+	from, ok = offset(start)
+	if !ok {
+		return 0, 0, false
+	}
+	to = from
+	if isRange {
+		if to, ok = offset(end); !ok {
+			return 0, 0, false
+		}
+	}
+	return from, to, true
+}
@@ -0,0 +1,561 @@
+package shell
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/codecrafters-io/shell-starter-go/internal/editor"
+)
+
+// ** History Store **
+// ------------------------------------------------------------------------------------------
+
+// Where the history is kept between sessions, picked with HISTSTORE
+type HistoryStore interface {
+	Load(limit int) ([]editor.HistoryEntry, error)                       // the newest limit entries, oldest first, all of them when limit is negative
+	Append(entry editor.HistoryEntry) error                              // called as soon as a line is run
+	Delete(entries []editor.HistoryEntry) error                          // removes the newest stored copy of each entry
+	Trim(size int) error                                                 // keeps the newest size entries
+	Replace(entries []editor.HistoryEntry) error                         // stores entries in place of all the others
+	Finish(entry editor.HistoryEntry) error                              // records the status and duration of an appended entry
+	Search(query HistoryQuery, limit int) ([]editor.HistoryEntry, error) // the newest limit entries matching query, newest first
+}
+
+// Conditions on the entries a search returns, the zero value matches every entry
+type HistoryQuery struct {
+	Text        string        // contained in the line
+	Dir         string        // the directory the line was run in
+	Since       time.Time     // run at or after
+	Until       time.Time     // run before
+	Failed      bool          // ended with a non-zero status
+	Status      *int          // ended with this status
+	MinDuration time.Duration // ran at least this long
+}
+
+// Reports whether an entry meets the query's conditions. Entries without a time or a status don't meet
+// conditions on them
+func (q HistoryQuery) matches(entry editor.HistoryEntry) bool {
+	switch {
+	case !strings.Contains(entry.Line, q.Text):
+	case q.Dir != "" && entry.Dir != q.Dir:
+	case !q.Since.IsZero() && (entry.Time.IsZero() || entry.Time.Before(q.Since)):
+	case !q.Until.IsZero() && (entry.Time.IsZero() || !entry.Time.Before(q.Until)):
+	case (q.Failed || q.Status != nil || q.MinDuration > 0) && !entry.Finished:
+	case q.Failed && entry.Status == 0:
+	case q.Status != nil && entry.Status != *q.Status:
+	case entry.Duration < q.MinDuration:
+	default:
+		return true
+	}
+	return false
+}
+
+// The store HISTSTORE names: file, the default, sqlite for a database fit for hundreds of thousands of entries,
+// or memory. An empty HISTFILE keeps the history in memory whatever the store
+func (s *Shell) historyStore() (HistoryStore, error) {
+	kind, _ := s.getVar("HISTSTORE")
+	path := s.historyFile()
+	switch {
+	case kind == "memory" || path == "" && (kind == "" || kind == "file" || kind == "sqlite"):
+		return memoryStore{history: s.editor.History()}, nil
+	case kind == "" || kind == "file":
+		return &fileStore{shell: s, path: path}, nil
+	case kind == "sqlite":
+		// The database is plain text, a user who asked for encryption is told rather than silently exposed
+		if s.options.Get("histencrypt") {
+			return nil, fmt.Errorf("HISTSTORE: sqlite can't encrypt history, unset histencrypt or use the file store")
+		}
+		// One store for the session, keeping its sqlite3 running, until HISTFILE changes
+		if s.histDB == nil || s.histDB.path != path {
+			if s.histDB != nil {
+				s.histDB.Close()
+			}
+			s.histDB = &sqliteStore{path: path}
+		}
+		return s.histDB, nil
+	}
+	return nil, fmt.Errorf("HISTSTORE: %s: unknown history store", kind)
+}
+
+// Newest limit entries of a list, all of them when limit is negative
+func newestEntries(entries []editor.HistoryEntry, limit int) []editor.HistoryEntry {
+	if limit < 0 || len(entries) <= limit {
+		return entries
+	}
+	return entries[len(entries)-limit:]
+}
+
+// Entries of a store without the newest copy of each of the entries named. Entries are the same when their
+// lines, directories and start times, to the second as stores keep them, are
+func withoutEntries(stored, deleted []editor.HistoryEntry) []editor.HistoryEntry {
+	removed := make([]bool, len(stored))
+	for _, entry := range deleted {
+		for i := len(stored) - 1; i >= 0; i-- {
+			if !removed[i] && stored[i].Line == entry.Line && stored[i].Dir == entry.Dir && entryStamp(stored[i]) == entryStamp(entry) {
+				removed[i] = true
+				break
+			}
+		}
+	}
+	kept := []editor.HistoryEntry{}
+	for i, entry := range stored {
+		if !removed[i] {
+			kept = append(kept, entry)
+		}
+	}
+	return kept
+}
+
+// Start time of an entry in seconds, 0 when it isn't known
+func entryStamp(entry editor.HistoryEntry) int64 {
+	if entry.Time.IsZero() {
+		return 0
+	}
+	return entry.Time.Unix()
+}
+
+// Newest limit entries matching a query, newest first, all of them when limit is negative
+func searchEntries(entries []editor.HistoryEntry, query HistoryQuery, limit int) []editor.HistoryEntry {
+	found := []editor.HistoryEntry{}
+	for i := len(entries) - 1; i >= 0 && (limit < 0 || len(found) < limit); i-- {
+		if query.matches(entries[i]) {
+			found = append(found, entries[i])
+		}
+	}
+	return found
+}
+
+// ** File Store **
+// ------------------------------------------------------------------------------------------
+
+// History file of timestamp comments and lines, bash style, encrypted with the histencrypt option
+type fileStore struct {
+	shell *Shell
+	path  string
+}
+
+// A missing file is an empty history, one that other users can read is made private
+func (f *fileStore) Load(limit int) ([]editor.HistoryEntry, error) {
+	if info, err := os.Stat(f.path); err == nil && info.Mode().Perm()&0077 != 0 {
+		os.Chmod(f.path, 0600)
+	}
+	entries, err := f.shell.readHistory(f.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return newestEntries(entries, limit), err
+}
+
+func (f *fileStore) Append(entry editor.HistoryEntry) error {
+	text, err := f.shell.formatEntry(entry)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = file.WriteString(text)
+	return err
+}
+
+// Appends a #= line, which readHistory applies to the entry
+func (f *fileStore) Finish(entry editor.HistoryEntry) error {
+	text, err := f.shell.formatFinish(entry)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = file.WriteString(text)
+	return err
+}
+
+// The file is read again and only the entries named are removed from it, so those beyond HISTSIZE and the
+// ones other sessions appended stay. It is left alone when some entries can't be decrypted
+func (f *fileStore) Delete(entries []editor.HistoryEntry) error {
+	stored, err := f.shell.readHistory(f.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	kept := withoutEntries(stored, entries)
+	if len(kept) == len(stored) {
+		return nil
+	}
+	return f.shell.writeHistory(f.path, kept)
+}
+
+// The file is read again rather than rewritten from memory, other sessions may have appended to it. It is
+// left alone when some entries can't be decrypted
+func (f *fileStore) Trim(size int) error {
+	entries, err := f.shell.readHistory(f.path)
+	if err != nil || len(entries) <= size {
+		return err
+	}
+	return f.shell.writeHistory(f.path, entries[len(entries)-size:])
+}
+
+// It is left alone when some of the file's entries can't be decrypted, they would be lost
+func (f *fileStore) Replace(entries []editor.HistoryEntry) error {
+	if _, err := f.shell.readHistory(f.path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return f.shell.writeHistory(f.path, entries)
+}
+
+// Reads the whole file, fine for the thousands of entries a history file holds
+func (f *fileStore) Search(query HistoryQuery, limit int) ([]editor.HistoryEntry, error) {
+	entries, err := f.shell.readHistory(f.path)
+	if err != nil && err != errUndecryptable {
+		return nil, err
+	}
+	return searchEntries(entries, query, limit), err
+}
+
+// ** Memory Store **
+// ------------------------------------------------------------------------------------------
+
+// No store at all: the history lives in the line editor and is gone when the shell exits
+type memoryStore struct {
+	history *editor.History
+}
+
+func (m memoryStore) Load(int) ([]editor.HistoryEntry, error) { return nil, nil }
+func (m memoryStore) Append(editor.HistoryEntry) error        { return nil }
+func (m memoryStore) Delete([]editor.HistoryEntry) error      { return nil }
+func (m memoryStore) Trim(int) error                          { return nil }
+func (m memoryStore) Replace([]editor.HistoryEntry) error     { return nil }
+func (m memoryStore) Finish(editor.HistoryEntry) error        { return nil }
+func (m memoryStore) Search(query HistoryQuery, limit int) ([]editor.HistoryEntry, error) {
+	return searchEntries(m.history.Entries(), query, limit), nil
+}
+
+// ** SQLite Store **
+// ------------------------------------------------------------------------------------------
+
+// History database in HISTFILE, or ~/.myshell_history.db, in plain text: it can't be used with histencrypt.
+// One sqlite3 process, started on first use and kept for the session, runs the statements so the shell needs
+// no driver. Values are bound to ? placeholders as hex literals, so they never mix with the SQL, and text
+// columns are read back in hex
+type sqliteStore struct {
+	path   string
+	mu     sync.Mutex
+	cmd    *exec.Cmd
+	input  io.WriteCloser
+	output *bufio.Reader
+	lastID int64               // row of the entry this session appended last, the one Finish updates
+	last   editor.HistoryEntry // that entry
+}
+
+// Table of the entries, created on first use. Their ids keep them in the order they were run in, status and
+// duration (in milliseconds) are NULL until the line has finished. The indexes serve searches by directory
+// and by time
+const sqliteSchema = `CREATE TABLE IF NOT EXISTS history (id INTEGER PRIMARY KEY, time INTEGER NOT NULL DEFAULT 0, dir TEXT NOT NULL DEFAULT '', line TEXT NOT NULL, status INTEGER, duration INTEGER);
+CREATE INDEX IF NOT EXISTS history_dir ON history (dir);
+CREATE INDEX IF NOT EXISTS history_time ON history (time);`
+
+// Output settings of the session, whatever ~/.sqliterc chose: rows as |-separated fields, NULL as nothing
+const sqliteSettings = ".headers off\n.mode list\n.separator | \"\\n\"\n.nullvalue ''"
+
+// Columns of the entries a SELECT returns
+const sqliteColumns = "time, hex(dir), hex(line), status, duration"
+
+// Printed after each batch of statements, the end of what they printed
+const sqliteDone = "~done~"
+
+func (q *sqliteStore) Load(limit int) ([]editor.HistoryEntry, error) {
+	return q.query("SELECT "+sqliteColumns+" FROM (SELECT * FROM history ORDER BY id DESC LIMIT ?) ORDER BY id;", limit)
+}
+
+func (q *sqliteStore) Append(entry editor.HistoryEntry) error {
+	status, duration := entryEnd(entry)
+	rows, err := q.exec("INSERT INTO history (time, dir, line, status, duration) VALUES (?, ?, ?, ?, ?);\nSELECT last_insert_rowid();",
+		entryStamp(entry), entry.Dir, entry.Line, status, duration)
+	if err != nil {
+		return err
+	}
+	if len(rows) == 1 {
+		q.lastID, _ = strconv.ParseInt(rows[0][0], 10, 64)
+		q.last = entry
+	}
+	return nil
+}
+
+// Only the entry this session appended last can be finished, other sessions' rows are never touched
+func (q *sqliteStore) Finish(entry editor.HistoryEntry) error {
+	if q.lastID == 0 || q.last.Line != entry.Line || !q.last.Time.Equal(entry.Time) {
+		return nil
+	}
+	_, err := q.exec("UPDATE history SET status = ?, duration = ? WHERE id = ?;", entry.Status, entry.Duration.Milliseconds(), q.lastID)
+	return err
+}
+
+func (q *sqliteStore) Delete(entries []editor.HistoryEntry) error {
+	var sql strings.Builder
+	args := []any{}
+	sql.WriteString("BEGIN;\n")
+	for _, entry := range entries {
+		sql.WriteString("DELETE FROM history WHERE id = (SELECT max(id) FROM history WHERE time = ? AND dir = ? AND line = ?);\n")
+		args = append(args, entryStamp(entry), entry.Dir, entry.Line)
+	}
+	sql.WriteString("COMMIT;")
+	_, err := q.exec(sql.String(), args...)
+	return err
+}
+
+func (q *sqliteStore) Trim(size int) error {
+	_, err := q.exec("DELETE FROM history WHERE id <= (SELECT id FROM history ORDER BY id DESC LIMIT 1 OFFSET ?);", size)
+	return err
+}
+
+func (q *sqliteStore) Replace(entries []editor.HistoryEntry) error {
+	var sql strings.Builder
+	args := []any{}
+	sql.WriteString("BEGIN;\nDELETE FROM history;\n")
+	for _, entry := range entries {
+		status, duration := entryEnd(entry)
+		sql.WriteString("INSERT INTO history (time, dir, line, status, duration) VALUES (?, ?, ?, ?, ?);\n")
+		args = append(args, entryStamp(entry), entry.Dir, entry.Line, status, duration)
+	}
+	sql.WriteString("COMMIT;")
+	q.lastID = 0
+	_, err := q.exec(sql.String(), args...)
+	return err
+}
+
+// Text is found with instr, a scan SQLite runs through hundreds of thousands of entries in a few milliseconds
+func (q *sqliteStore) Search(query HistoryQuery, limit int) ([]editor.HistoryEntry, error) {
+	where := []string{"1"}
+	args := []any{}
+	if query.Text != "" {
+		where = append(where, "instr(line, ?) > 0")
+		args = append(args, query.Text)
+	}
+	if query.Dir != "" {
+		where = append(where, "dir = ?")
+		args = append(args, query.Dir)
+	}
+	if !query.Since.IsZero() {
+		where = append(where, "time >= ?")
+		args = append(args, query.Since.Unix())
+	}
+	if !query.Until.IsZero() {
+		where = append(where, "time > 0 AND time < ?")
+		args = append(args, query.Until.Unix())
+	}
+	if query.Failed {
+		where = append(where, "status != 0")
+	}
+	if query.Status != nil {
+		where = append(where, "status = ?")
+		args = append(args, *query.Status)
+	}
+	if query.MinDuration > 0 {
+		where = append(where, "duration >= ?")
+		args = append(args, query.MinDuration.Milliseconds())
+	}
+	args = append(args, limit)
+	return q.query("SELECT "+sqliteColumns+" FROM history WHERE "+strings.Join(where, " AND ")+" ORDER BY id DESC LIMIT ?;", args...)
+}
+
+// Status and duration of an entry as stored, nil until it has finished
+func entryEnd(entry editor.HistoryEntry) (status, duration any) {
+	if !entry.Finished {
+		return nil, nil
+	}
+	return entry.Status, entry.Duration.Milliseconds()
+}
+
+// Runs a SELECT of sqliteColumns and decodes the entries it returns
+func (q *sqliteStore) query(sql string, args ...any) ([]editor.HistoryEntry, error) {
+	rows, err := q.exec(sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]editor.HistoryEntry, 0, len(rows))
+	for _, row := range rows {
+		if len(row) != 5 {
+			return nil, fmt.Errorf("sqlite3: unexpected row %q", strings.Join(row, "|"))
+		}
+		stamp, _ := strconv.ParseInt(row[0], 10, 64)
+		dir, err1 := hex.DecodeString(row[1])
+		line, err2 := hex.DecodeString(row[2])
+		if err1 != nil || err2 != nil {
+			return nil, fmt.Errorf("sqlite3: unexpected row %q", strings.Join(row, "|"))
+		}
+		entry := editor.HistoryEntry{Line: string(line), Dir: string(dir)}
+		if stamp != 0 {
+			entry.Time = time.Unix(stamp, 0)
+		}
+		status, err1 := strconv.Atoi(row[3])
+		millis, err2 := strconv.ParseInt(row[4], 10, 64)
+		if err1 == nil && err2 == nil {
+			entry.Status, entry.Duration, entry.Finished = status, time.Duration(millis)*time.Millisecond, true
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// Statements with their ? placeholders replaced by args in order: integers as they are, strings as hex blob
+// literals cast to text, nil as NULL
+func bindSQL(sql string, args []any) (string, error) {
+	var bound strings.Builder
+	for _, arg := range args {
+		i := strings.IndexByte(sql, '?')
+		if i == -1 {
+			return "", fmt.Errorf("sqlite3: more arguments than placeholders")
+		}
+		bound.WriteString(sql[:i])
+		sql = sql[i+1:]
+		switch value := arg.(type) {
+		case nil:
+			bound.WriteString("NULL")
+		case int:
+			bound.WriteString(strconv.Itoa(value))
+		case int64:
+			bound.WriteString(strconv.FormatInt(value, 10))
+		case string:
+			bound.WriteString("CAST(X'" + hex.EncodeToString([]byte(value)) + "' AS TEXT)")
+		default:
+			return "", fmt.Errorf("sqlite3: can't bind %T", arg)
+		}
+	}
+	if strings.IndexByte(sql, '?') != -1 {
+		return "", fmt.Errorf("sqlite3: more placeholders than arguments")
+	}
+	bound.WriteString(sql)
+	return bound.String(), nil
+}
+
+// Runs statements in the session's sqlite3, starting it when needed, and returns the rows they printed split
+// into fields. Rows are only digits, hex and separators, any other line is an error sqlite3 reported
+func (q *sqliteStore) exec(sql string, args ...any) ([][]string, error) {
+	bound, err := bindSQL(sql, args)
+	if err != nil {
+		return nil, err
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if err := q.start(); err != nil {
+		return nil, err
+	}
+	return q.batch(bound)
+}
+
+// Sends a batch to sqlite3 and reads what it printed up to the end marker
+func (q *sqliteStore) batch(sql string) ([][]string, error) {
+	if _, err := fmt.Fprintf(q.input, "%s\n.print %s\n", sql, sqliteDone); err != nil {
+		q.stop()
+		return nil, fmt.Errorf("sqlite3: %v", err)
+	}
+	rows := [][]string{}
+	failure := ""
+	for {
+		line, err := q.output.ReadString('\n')
+		if err != nil {
+			q.stop()
+			return nil, fmt.Errorf("sqlite3: exited")
+		}
+		line = strings.TrimRight(line, "\r\n")
+		switch {
+		case line == sqliteDone:
+			if failure != "" {
+				return nil, errors.New(failure)
+			}
+			return rows, nil
+		case strings.Trim(line, "0123456789ABCDEF|-") == "":
+			rows = append(rows, strings.Split(line, "|"))
+		case failure == "":
+			failure = strings.TrimSpace(line)
+		}
+	}
+}
+
+// Starts the session's sqlite3 on the database in a process group of its own, out of reach of Ctrl+C, and
+// creates the table. The database is made private, like history files
+func (q *sqliteStore) start() error {
+	if q.cmd != nil {
+		return nil
+	}
+	cmd := exec.Command("sqlite3", "-batch", q.path)
+	setProcessGroup(cmd)
+	input, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		input.Close()
+		return err
+	}
+	cmd.Stdout, cmd.Stderr = writer, writer
+	err = cmd.Start()
+	writer.Close()
+	if errors.Is(err, exec.ErrNotFound) {
+		err = fmt.Errorf("HISTSTORE: sqlite needs the sqlite3 command")
+	}
+	if err != nil {
+		input.Close()
+		reader.Close()
+		return err
+	}
+	q.cmd, q.input, q.output = cmd, input, bufio.NewReader(reader)
+
+	// What ~/.sqliterc printed comes before the settings take effect and is skipped
+	if _, err := fmt.Fprintf(q.input, "%s\n.print %s\n", sqliteSettings, sqliteDone); err != nil {
+		q.stop()
+		return fmt.Errorf("sqlite3: %v", err)
+	}
+	for {
+		line, err := q.output.ReadString('\n')
+		if err != nil {
+			q.stop()
+			return fmt.Errorf("sqlite3: exited")
+		}
+		if strings.TrimRight(line, "\r\n") == sqliteDone {
+			break
+		}
+	}
+	if _, err := q.batch(sqliteSchema); err != nil {
+		q.stop()
+		return fmt.Errorf("%s: %v", q.path, err)
+	}
+	if info, err := os.Stat(q.path); err == nil && info.Mode().Perm()&0077 != 0 {
+		os.Chmod(q.path, 0600)
+	}
+	return nil
+}
+
+// Ends the session's sqlite3, which finishes writing the database when its input is closed
+func (q *sqliteStore) stop() {
+	if q.cmd == nil {
+		return
+	}
+	q.input.Close()
+	q.cmd.Wait()
+	q.cmd, q.input, q.output = nil, nil, nil
+}
+
+// Ends the session's sqlite3 when the shell exits
+func (q *sqliteStore) Close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.stop()
+}
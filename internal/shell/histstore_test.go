@@ -0,0 +1,133 @@
+package shell
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/shell-starter-go/internal/editor"
+)
+
+// Every history store loads back what was appended and how it ended, newest entries last, and trims and
+// searches alike
+func TestHistoryStores(t *testing.T) {
+	for _, kind := range []string{"file", "sqlite"} {
+		t.Run(kind, func(t *testing.T) {
+			if _, err := exec.LookPath("sqlite3"); err != nil && kind == "sqlite" {
+				t.Skip("sqlite3 isn't installed")
+			}
+			s := scriptShell(t)
+			s.setVar("HISTSTORE", kind)
+			s.setVar("HISTFILE", filepath.Join(t.TempDir(), "history"))
+			store, err := s.historyStore()
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() {
+				if s.histDB != nil {
+					s.histDB.Close()
+				}
+			})
+
+			stamp := time.Unix(1700000000, 0)
+			for i, line := range []string{"echo one", "ls -la", "echo 'two'", "grep echo notes"} {
+				entry := editor.HistoryEntry{Line: line, Dir: "/tmp", Time: stamp.Add(time.Duration(i) * time.Hour)}
+				if err := store.Append(entry); err != nil {
+					t.Fatal(err)
+				}
+				entry.Status, entry.Duration, entry.Finished = i, time.Duration(i)*time.Second, true
+				if err := store.Finish(entry); err != nil {
+					t.Fatal(err)
+				}
+			}
+			entries, err := store.Load(3)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := historyLines(entries); !slices.Equal(got, []string{"ls -la", "echo 'two'", "grep echo notes"}) {
+				t.Errorf("Load(3) = %q", got)
+			}
+			want := editor.HistoryEntry{Line: "ls -la", Dir: "/tmp", Time: stamp.Add(time.Hour), Status: 1, Duration: time.Second, Finished: true}
+			if entries[0] != want {
+				t.Errorf("Load(3)[0] = %+v, want %+v", entries[0], want)
+			}
+
+			status := 2
+			for _, test := range []struct {
+				query HistoryQuery
+				limit int
+				want  []string
+			}{
+				{HistoryQuery{Text: "echo"}, 2, []string{"grep echo notes", "echo 'two'"}},
+				{HistoryQuery{Text: "echo", Failed: true}, -1, []string{"grep echo notes", "echo 'two'"}},
+				{HistoryQuery{Status: &status}, -1, []string{"echo 'two'"}},
+				{HistoryQuery{MinDuration: time.Second, Until: stamp.Add(2 * time.Hour)}, -1, []string{"ls -la"}},
+				{HistoryQuery{Since: stamp.Add(3 * time.Hour), Dir: "/tmp"}, -1, []string{"grep echo notes"}},
+				{HistoryQuery{Dir: "/home"}, -1, []string{}},
+			} {
+				found, err := store.Search(test.query, test.limit)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := historyLines(found); !slices.Equal(got, test.want) {
+					t.Errorf("Search(%+v, %d) = %q, want %q", test.query, test.limit, got, test.want)
+				}
+			}
+
+			if err := store.Trim(2); err != nil {
+				t.Fatal(err)
+			}
+			entries, err = store.Load(-1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := historyLines(entries); !slices.Equal(got, []string{"echo 'two'", "grep echo notes"}) {
+				t.Errorf("after Trim(2), Load(-1) = %q", got)
+			}
+
+			replaced := []editor.HistoryEntry{want, {Line: "pwd", Dir: "/", Time: stamp}}
+			if err := store.Replace(replaced); err != nil {
+				t.Fatal(err)
+			}
+			if entries, err = store.Load(-1); err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(entries, replaced) {
+				t.Errorf("after Replace, Load(-1) = %+v, want %+v", entries, replaced)
+			}
+		})
+	}
+}
+
+// Lines of history entries
+func historyLines(entries []editor.HistoryEntry) []string {
+	lines := []string{}
+	for _, entry := range entries {
+		lines = append(lines, entry.Line)
+	}
+	return lines
+}
+
+// history -d deletes the named entries from the store and leaves the ones beyond HISTSIZE alone
+func TestHistoryDelete(t *testing.T) {
+	s := scriptShell(t)
+	path := filepath.Join(t.TempDir(), "history")
+	if err := os.WriteFile(path, []byte("old1\nold2\nold3\nold4\nold5\nold6\nold7\nold8\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	s.setVar("HISTFILE", path)
+	s.setVar("HISTSIZE", "3")
+	s.loadHistory()
+	s.runLine("history -d 1")
+
+	entries, err := s.readHistory(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := historyLines(entries); !slices.Equal(got, []string{"old1", "old2", "old3", "old4", "old5", "old7", "old8"}) {
+		t.Errorf("history file = %q", got)
+	}
+}
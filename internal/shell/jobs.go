@@ -0,0 +1,750 @@
+package shell
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// ** Jobs **
+// ------------------------------------------------------------------------------------------
+
+type JobState int
+
+const (
+	JobRunning JobState = iota
+	JobStopped
+	JobDone
+)
+
+func (js JobState) String() string {
+	switch js {
+	case JobRunning:
+		return "Running"
+	case JobStopped:
+		return "Stopped"
+	default:
+		return "Done"
+	}
+}
+
+type Job struct {
+	id      int
+	pid     int
+	pgid    int // process group the job runs in, signals for the job go to the whole group
+	command string
+	state   JobState
+	status  int
+	signal  syscall.Signal // signal that killed the job
+	nohup   bool           // disowned with -h, left alone when the shell hangs up
+	cmd     *exec.Cmd
+}
+
+// Job table, jobs are kept in recency order so the last one is the current job (%+) and the one before it the previous job (%-)
+type JobTable struct {
+	mu         sync.Mutex
+	changed    *sync.Cond
+	jobs       []*Job
+	foreground map[int]*cancelScope // processes of the commands running in the foreground, not kept as jobs, with the timeout group running each
+	interrupts int                  // SIGINTs the shell caught, for waits that give up on Ctrl+C
+}
+
+// Creates an empty JobTable
+func NewJobTable() *JobTable {
+	jt := &JobTable{jobs: []*Job{}, foreground: make(map[int]*cancelScope)}
+	jt.changed = sync.NewCond(&jt.mu)
+	return jt
+}
+
+// Registers a started command as a job, assigns it the lowest free job id
+func (jt *JobTable) add(cmd *exec.Cmd, command string) *Job {
+	jt.mu.Lock()
+	defer jt.mu.Unlock()
+
+	id := 1
+	for _, job := range jt.sorted() {
+		if job.id == id {
+			id++
+		}
+	}
+
+	job := &Job{id: id, pid: cmd.Process.Pid, pgid: cmd.Process.Pid, command: command, state: JobRunning, cmd: cmd}
+	jt.jobs = append(jt.jobs, job)
+	return job
+}
+
+// Removes a job from the table
+func (jt *JobTable) remove(job *Job) {
+	jt.mu.Lock()
+	defer jt.mu.Unlock()
+	jt.removeLocked(job)
+}
+
+func (jt *JobTable) removeLocked(job *Job) {
+	for i, j := range jt.jobs {
+		if j == job {
+			jt.jobs = append(jt.jobs[:i], jt.jobs[i+1:]...)
+			return
+		}
+	}
+}
+
+// Records a state change of a job and wakes up everyone waiting on it, a stopped job becomes the current job
+func (jt *JobTable) update(job *Job, state JobState, status int) {
+	jt.mu.Lock()
+	defer jt.mu.Unlock()
+
+	job.state = state
+	job.status = status
+	if state == JobStopped {
+		jt.removeLocked(job)
+		jt.jobs = append(jt.jobs, job)
+	}
+	jt.changed.Broadcast()
+}
+
+// Records the processes of a command the shell waits on, run in the scope of a timeout group or nil, until
+// release is called, which may be called again. The stages of a pipeline holding compound commands run
+// concurrently, their processes are all recorded
+func (jt *JobTable) setRunning(pids []int, scope *cancelScope) (release func()) {
+	jt.mu.Lock()
+	defer jt.mu.Unlock()
+	for _, pid := range pids {
+		jt.foreground[pid] = scope
+	}
+	return func() {
+		jt.mu.Lock()
+		defer jt.mu.Unlock()
+		for _, pid := range pids {
+			delete(jt.foreground, pid)
+		}
+	}
+}
+
+// Passes a SIGINT the shell caught on to the foreground command and wakes up the wait builtin, the shell
+// itself carries on
+func (jt *JobTable) interrupt() {
+	jt.mu.Lock()
+	pids := []int{}
+	for pid := range jt.foreground {
+		pids = append(pids, pid)
+	}
+	jt.interrupts++
+	jt.changed.Broadcast()
+	jt.mu.Unlock()
+	interruptProcesses(pids)
+}
+
+// Terminates the foreground commands run in a timeout group's scope with SIGTERM and wakes up the wait
+// builtin, the time the group was given ran out
+func (jt *JobTable) terminate(scope *cancelScope) {
+	jt.mu.Lock()
+	pids := []int{}
+	for pid, running := range jt.foreground {
+		if running.within(scope) {
+			pids = append(pids, pid)
+		}
+	}
+	jt.changed.Broadcast()
+	jt.mu.Unlock()
+	for _, pid := range pids {
+		signalProcess(pid, syscall.SIGTERM)
+	}
+}
+
+// Records that a job was killed by a signal
+func (jt *JobTable) killed(job *Job, sig syscall.Signal) {
+	jt.mu.Lock()
+	job.signal = sig
+	jt.mu.Unlock()
+	jt.update(job, JobDone, 128+int(sig))
+}
+
+// Blocks until the job is no longer running, returns its state at that point
+func (jt *JobTable) wait(job *Job) JobState {
+	jt.mu.Lock()
+	defer jt.mu.Unlock()
+	for job.state == JobRunning {
+		jt.changed.Wait()
+	}
+	return job.state
+}
+
+// Blocks until the job has finished, stopped jobs are waited on until they are resumed and exit
+func (jt *JobTable) waitDone(job *Job) int {
+	jt.mu.Lock()
+	defer jt.mu.Unlock()
+	for job.state != JobDone {
+		jt.changed.Wait()
+	}
+	return job.status
+}
+
+// Blocks until the job has finished like waitDone, unless the shell catches a SIGINT or cancelled says the
+// timeout group running the wait was cancelled first. Returns the job's status and whether it finished, the
+// status is 130 when a SIGINT ended the wait
+func (jt *JobTable) waitInterruptible(job *Job, cancelled func() bool) (int, bool) {
+	jt.mu.Lock()
+	defer jt.mu.Unlock()
+	interrupts := jt.interrupts
+	for job.state != JobDone {
+		switch {
+		case jt.interrupts != interrupts:
+			return 130, false
+		case cancelled():
+			return 0, false
+		}
+		jt.changed.Wait()
+	}
+	return job.status, true
+}
+
+// Removes finished jobs and returns their notices so they can be reported before the next prompt
+func (jt *JobTable) reap() []string {
+	jt.mu.Lock()
+	defer jt.mu.Unlock()
+
+	notices := []string{}
+	for _, job := range jt.sorted() {
+		if job.state == JobDone {
+			notices = append(notices, jt.format(job))
+		}
+	}
+	for _, job := range jt.sorted() {
+		if job.state == JobDone {
+			jt.removeLocked(job)
+		}
+	}
+	return notices
+}
+
+// Number of jobs that haven't finished
+func (jt *JobTable) active() int {
+	jt.mu.Lock()
+	defer jt.mu.Unlock()
+	n := 0
+	for _, job := range jt.jobs {
+		if job.state != JobDone {
+			n++
+		}
+	}
+	return n
+}
+
+// Whether any job is stopped
+func (jt *JobTable) stopped() bool {
+	jt.mu.Lock()
+	defer jt.mu.Unlock()
+	for _, job := range jt.jobs {
+		if job.state == JobStopped {
+			return true
+		}
+	}
+	return false
+}
+
+// Jobs ordered by id, must be called with the lock held
+func (jt *JobTable) sorted() []*Job {
+	jobs := append([]*Job{}, jt.jobs...)
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].id < jobs[j].id })
+	return jobs
+}
+
+// Resolves a job designator: %N, %+, %%, %-, %name (command prefix) and %?str (command substring).
+// An empty spec refers to the current job
+func (jt *JobTable) resolve(spec string) (*Job, error) {
+	jt.mu.Lock()
+	defer jt.mu.Unlock()
+
+	if spec == "" {
+		spec = "%+"
+	}
+	if !strings.HasPrefix(spec, "%") {
+		return nil, fmt.Errorf("%s: no such job", spec)
+	}
+
+	designator := spec[1:]
+	switch {
+	case designator == "" || designator == "+" || designator == "%":
+		if len(jt.jobs) > 0 {
+			return jt.jobs[len(jt.jobs)-1], nil
+		}
+		return nil, fmt.Errorf("current: no such job")
+	case designator == "-":
+		if len(jt.jobs) > 1 {
+			return jt.jobs[len(jt.jobs)-2], nil
+		}
+		if len(jt.jobs) == 1 {
+			return jt.jobs[0], nil
+		}
+		return nil, fmt.Errorf("previous: no such job")
+	}
+
+	if id, err := strconv.Atoi(designator); err == nil {
+		for _, job := range jt.jobs {
+			if job.id == id {
+				return job, nil
+			}
+		}
+		return nil, fmt.Errorf("%s: no such job", spec)
+	}
+
+	match := func(job *Job) bool { return strings.HasPrefix(job.command, designator) }
+	if strings.HasPrefix(designator, "?") {
+		match = func(job *Job) bool { return strings.Contains(job.command, designator[1:]) }
+	}
+
+	var found *Job
+	for _, job := range jt.jobs {
+		if match(job) {
+			if found != nil {
+				return nil, fmt.Errorf("%s: ambiguous job spec", spec)
+			}
+			found = job
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("%s: no such job", spec)
+	}
+	return found, nil
+}
+
+// Marker of a job in listings: + for the current job, - for the previous one. Must be called with the lock held
+func (jt *JobTable) mark(job *Job) string {
+	if n := len(jt.jobs); n > 0 && jt.jobs[n-1] == job {
+		return "+"
+	} else if n > 1 && jt.jobs[n-2] == job {
+		return "-"
+	}
+	return " "
+}
+
+// State of a job as listed, a finished job shows how it ended: Done, Exit and its status when it failed, or
+// the signal that killed it
+func (job *Job) describe() string {
+	if job.state == JobDone && job.signal != 0 {
+		name := job.signal.String()
+		return strings.ToUpper(name[:1]) + name[1:]
+	}
+	if job.state == JobDone && job.status != 0 {
+		return fmt.Sprintf("Exit %d", job.status)
+	}
+	return job.state.String()
+}
+
+// Command of a job as listed, with the & it was started with while it still runs in the background
+func (job *Job) commandLine() string {
+	if job.state == JobRunning {
+		return job.command + " &"
+	}
+	return job.command
+}
+
+// Formats a job the way bash reports it, every state change notice and jobs listing goes through it.
+// Must be called with the lock held
+func (jt *JobTable) format(job *Job) string {
+	return fmt.Sprintf("[%d]%s  %-24s%s", job.id, jt.mark(job), job.describe(), job.commandLine())
+}
+
+// Formats a job for jobs -l, with its process id, and its process group id when the process doesn't lead it.
+// Must be called with the lock held
+func (jt *JobTable) formatLong(job *Job) string {
+	ids := strconv.Itoa(job.pid)
+	if job.pgid != job.pid {
+		ids += " " + strconv.Itoa(job.pgid)
+	}
+	return fmt.Sprintf("[%d]%s %s %-24s%s", job.id, jt.mark(job), ids, job.describe(), job.commandLine())
+}
+
+// ** Job Control **
+// ------------------------------------------------------------------------------------------
+
+// Starts an external command in its own process group without waiting for it, and registers it as a job.
+// The job doesn't read the terminal, its stdin is only what it is redirected from
+func (s *Shell) startJob(cmd Command, std Streams) error {
+	ext, release, err := s.external(cmd, Streams{nil, std.stdout, std.stderr, std.scope})
+	if err != nil {
+		return err
+	}
+	setProcessGroup(ext)
+
+	err = ext.Start()
+	release()
+	if err != nil {
+		return fmt.Errorf("%s: %v", cmd.op, err)
+	}
+
+	job := s.jobs.add(ext, strings.Join(append([]string{cmd.op}, cmd.args...), " "))
+	trackJob(job)
+	s.background = job.pid
+	// Scripts don't announce their jobs, the notice would mix with their output
+	if s.options.Get("interactive") {
+		fmt.Fprintf(std.stdout, "[%d] %d\n", job.id, job.pid)
+	}
+	go s.monitorJob(job)
+
+	return nil
+}
+
+// Whether the shell may exit: the first attempt while jobs are stopped is refused with a warning, another one
+// right after it leaves them behind
+func (s *Shell) mayExit() bool {
+	if s.exitWarned || !s.jobs.stopped() {
+		return true
+	}
+	fmt.Fprint(os.Stderr, "There are stopped jobs.\n")
+	s.exitWarned = true
+	return false
+}
+
+// Sends SIGHUP to every job that is still alive, unless it was disowned with -h
+func (s *Shell) hangupJobs() {
+	s.jobs.mu.Lock()
+	jobs := append([]*Job{}, s.jobs.jobs...)
+	s.jobs.mu.Unlock()
+
+	for _, job := range jobs {
+		if job.state != JobDone && !job.nohup {
+			hangupJob(job)
+		}
+	}
+}
+
+// Prints and forgets jobs that finished since the last prompt
+func (s *Shell) reportJobs() {
+	for _, notice := range s.jobs.reap() {
+		fmt.Println(notice)
+	}
+}
+
+// Shell builtin jobs, lists the jobs in the table and reports the finished ones a last time before forgetting
+// them. -l adds process ids, -p prints only the process group ids, -r and -s only list running or stopped jobs
+func (s *Shell) jobsBuiltin(args []string, std Streams) error {
+	long, pids, running, stopped := false, false, false, false
+	specs := []string{}
+	for _, arg := range args {
+		if !strings.HasPrefix(arg, "-") || arg == "-" {
+			specs = append(specs, arg)
+			continue
+		}
+		for _, f := range arg[1:] {
+			switch f {
+			case 'l':
+				long = true
+			case 'p':
+				pids = true
+			case 'r':
+				running = true
+			case 's':
+				stopped = true
+			default:
+				return fmt.Errorf("jobs: -%c: invalid option\njobs: usage: jobs [-lprs] [jobspec ...]", f)
+			}
+		}
+	}
+
+	jobs := []*Job{}
+	for _, spec := range specs {
+		job, err := s.jobs.resolve(spec)
+		if err != nil {
+			return fmt.Errorf("jobs: %v", err)
+		}
+		jobs = append(jobs, job)
+	}
+
+	s.jobs.mu.Lock()
+	if len(specs) == 0 {
+		jobs = s.jobs.sorted()
+	}
+	for _, job := range jobs {
+		switch {
+		case running && job.state != JobRunning, stopped && job.state != JobStopped:
+		case pids:
+			fmt.Fprintln(std.stdout, job.pgid)
+		case long:
+			fmt.Fprintln(std.stdout, s.jobs.formatLong(job))
+		default:
+			fmt.Fprintln(std.stdout, s.jobs.format(job))
+		}
+	}
+	for _, job := range jobs {
+		if job.state == JobDone {
+			s.jobs.removeLocked(job)
+		}
+	}
+	s.jobs.mu.Unlock()
+
+	return nil
+}
+
+// Shell builtin fg, resumes a job in the foreground and waits for it, its status is the job's
+func (s *Shell) fg(args []string, std Streams) error {
+	if len(args) > 1 {
+		return fmt.Errorf("fg: Expected [0:1] argument, received %d", len(args))
+	}
+	spec := ""
+	if len(args) == 1 {
+		spec = args[0]
+	}
+	job, err := s.jobs.resolve(spec)
+	if err != nil {
+		return fmt.Errorf("fg: %v", err)
+	}
+
+	fmt.Fprintln(std.stdout, job.command)
+	status, err := s.foregroundJob(job)
+	if err != nil {
+		return fmt.Errorf("fg: %v", err)
+	}
+	if status != 0 {
+		return ExitStatus(status)
+	}
+
+	return nil
+}
+
+// Shell builtin bg, resumes stopped jobs in the background
+func (s *Shell) bg(args []string, std Streams) error {
+	if len(args) == 0 {
+		args = []string{""}
+	}
+	for _, spec := range args {
+		job, err := s.jobs.resolve(spec)
+		if err != nil {
+			return fmt.Errorf("bg: %v", err)
+		}
+		if job.state == JobRunning {
+			fmt.Fprintf(std.stdout, "bg: job %d already in background\n", job.id)
+			continue
+		}
+		s.jobs.update(job, JobRunning, job.status)
+		if err := continueJob(job); err != nil {
+			return fmt.Errorf("bg: %v", err)
+		}
+		s.jobs.mu.Lock()
+		fmt.Fprintf(std.stdout, "[%d]%s %s\n", job.id, s.jobs.mark(job), job.commandLine())
+		s.jobs.mu.Unlock()
+	}
+
+	return nil
+}
+
+// Shell builtin wait, waits for the given jobs, or every job when called without arguments. Its status is the
+// one of the last job given, 130 when Ctrl+C or a timeout interrupts it
+func (s *Shell) wait(args []string, std Streams) error {
+	jobs := []*Job{}
+	if len(args) == 0 {
+		s.jobs.mu.Lock()
+		jobs = append(jobs, s.jobs.jobs...)
+		s.jobs.mu.Unlock()
+	}
+	for _, spec := range args {
+		job, err := s.jobs.resolve(spec)
+		if err != nil {
+			return fmt.Errorf("wait: %v", err)
+		}
+		jobs = append(jobs, job)
+	}
+
+	status := 0
+	for _, job := range jobs {
+		var finished bool
+		if status, finished = s.jobs.waitInterruptible(job, std.scope.cancelled); !finished {
+			// The terminal echoed ^C, finish its line
+			if status == 130 && s.options.Get("interactive") {
+				fmt.Println()
+			}
+			return ExitStatus(130)
+		}
+	}
+	for _, notice := range s.jobs.reap() {
+		fmt.Fprintln(std.stdout, notice)
+	}
+	if len(args) > 0 && status != 0 {
+		return ExitStatus(status)
+	}
+	return nil
+}
+
+// Shell builtin kill, sends a signal, TERM unless -s name, -name or -number picks another, to processes and to
+// jobs given by job spec. -l lists the signals, or converts the given numbers (or exit statuses) to names and
+// names to numbers
+func (s *Shell) kill(args []string, std Streams) error {
+	usage := fmt.Errorf("kill: usage: kill [-s sigspec | -n signum | -sigspec] pid | jobspec ... or kill -l [sigspec]")
+	if len(args) == 0 {
+		return usage
+	}
+
+	sig := syscall.SIGTERM
+	i := 0
+	switch arg := args[0]; {
+	case arg == "-l" || arg == "-L":
+		return listSignals(args[1:], std)
+	case arg == "-s" || arg == "-n":
+		if len(args) < 2 {
+			return fmt.Errorf("kill: %s: option requires an argument", arg)
+		}
+		i = 2
+		var ok bool
+		if sig, ok = parseSignal(args[1]); !ok {
+			return fmt.Errorf("kill: %s: invalid signal specification", args[1])
+		}
+	case arg == "--":
+		i = 1
+	case len(arg) > 1 && arg[0] == '-':
+		i = 1
+		var ok bool
+		if sig, ok = parseSignal(arg[1:]); !ok {
+			return fmt.Errorf("kill: %s: invalid signal specification", arg[1:])
+		}
+	}
+	if i < len(args) && args[i] == "--" {
+		i++
+	}
+	if i >= len(args) {
+		return usage
+	}
+
+	var err error
+	for _, target := range args[i:] {
+		if strings.HasPrefix(target, "%") {
+			job, jobErr := s.jobs.resolve(target)
+			if jobErr != nil {
+				err = fmt.Errorf("kill: %v", jobErr)
+				s.report(err)
+				continue
+			}
+			if signalErr := signalJob(job, sig); signalErr != nil {
+				err = fmt.Errorf("kill: %s: %v", target, signalErr)
+				s.report(err)
+			}
+			continue
+		}
+		pid, convErr := strconv.Atoi(target)
+		if convErr != nil {
+			err = fmt.Errorf("kill: %s: arguments must be process or job IDs", target)
+			s.report(err)
+			continue
+		}
+		if signalErr := signalProcess(pid, sig); signalErr != nil {
+			err = fmt.Errorf("kill: (%d) - %s", pid, describeError(signalErr))
+			s.report(err)
+		}
+	}
+	if err != nil {
+		return ExitStatus(1)
+	}
+
+	return nil
+}
+
+// Signal named by a kill argument: a number, or a name with or without SIG in any case
+func parseSignal(spec string) (syscall.Signal, bool) {
+	if n, err := strconv.Atoi(spec); err == nil {
+		if n == 0 {
+			return 0, true
+		}
+		for _, sig := range signalNames {
+			if int(sig) == n {
+				return sig, true
+			}
+		}
+		return 0, false
+	}
+	sig, ok := signalNames[strings.TrimPrefix(strings.ToUpper(spec), "SIG")]
+	return sig, ok
+}
+
+// Prints the table of signals for kill -l, or converts each argument: a number to the name of its signal
+// (exit statuses above 128 to the signal that caused them), a name to its number
+func listSignals(args []string, std Streams) error {
+	names := make(map[syscall.Signal]string, len(signalNames))
+	numbers := []int{}
+	for name, sig := range signalNames {
+		names[sig] = name
+		numbers = append(numbers, int(sig))
+	}
+	sort.Ints(numbers)
+
+	if len(args) == 0 {
+		for i, n := range numbers {
+			sep := "\t"
+			if i%5 == 4 || i == len(numbers)-1 {
+				sep = "\n"
+			}
+			fmt.Fprintf(std.stdout, "%2d) SIG%-8s%s", n, names[syscall.Signal(n)], sep)
+		}
+		return nil
+	}
+
+	for _, arg := range args {
+		n, err := strconv.Atoi(arg)
+		if err != nil {
+			sig, ok := parseSignal(arg)
+			if !ok {
+				return fmt.Errorf("kill: %s: invalid signal specification", arg)
+			}
+			fmt.Fprintln(std.stdout, int(sig))
+			continue
+		}
+		if n > 128 {
+			n -= 128
+		}
+		name, exists := names[syscall.Signal(n)]
+		if !exists {
+			return fmt.Errorf("kill: %s: invalid signal specification", arg)
+		}
+		fmt.Fprintln(std.stdout, name)
+	}
+	return nil
+}
+
+// Shell builtin disown, removes jobs from the table so the shell neither reports nor hangs them up.
+// With -h the jobs stay in the table but are spared the SIGHUP on exit, -a applies to every job
+func (s *Shell) disown(args []string, std Streams) error {
+	keep, all := false, false
+	specs := []string{}
+	for _, arg := range args {
+		switch arg {
+		case "-h":
+			keep = true
+		case "-a":
+			all = true
+		default:
+			specs = append(specs, arg)
+		}
+	}
+
+	jobs := []*Job{}
+	if all {
+		s.jobs.mu.Lock()
+		jobs = append(jobs, s.jobs.jobs...)
+		s.jobs.mu.Unlock()
+	} else {
+		if len(specs) == 0 {
+			specs = []string{""}
+		}
+		for _, spec := range specs {
+			job, err := s.jobs.resolve(spec)
+			if err != nil {
+				return fmt.Errorf("disown: %v", err)
+			}
+			jobs = append(jobs, job)
+		}
+	}
+
+	for _, job := range jobs {
+		if keep {
+			job.nohup = true
+		} else {
+			s.jobs.remove(job)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,164 @@
+//go:build !windows
+
+package shell
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/term"
+)
+
+// Puts the command in a process group of its own so signals aimed at the shell's group don't reach it
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// Puts a pipeline stage in the pipeline's process group, pgid 0 makes it the leader of a new group which it
+// moves to the foreground of the terminal before it runs, so Ctrl+C reaches the pipeline's processes only
+func joinProcessGroup(cmd *exec.Cmd, pgid int) {
+	if pgid == 0 {
+		signal.Ignore(syscall.SIGTTOU)
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true, Foreground: true, Ctty: int(os.Stdin.Fd())}
+		return
+	}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true, Pgid: pgid}
+}
+
+// Takes the terminal back from a pipeline's process group once it has finished
+func reclaimTerminal() {
+	setForeground(int(os.Stdin.Fd()), syscall.Getpgrp())
+}
+
+// Jobs are tracked through their process groups, see setProcessGroup
+func trackJob(job *Job) {}
+
+// Waits on the job's process, tracking stops and continues until it exits
+func (s *Shell) monitorJob(job *Job) {
+	for {
+		var ws syscall.WaitStatus
+		_, err := syscall.Wait4(job.pid, &ws, syscall.WUNTRACED|syscall.WCONTINUED, nil)
+		switch {
+		case err == syscall.EINTR:
+			continue
+		case err != nil:
+			s.jobs.update(job, JobDone, 1)
+			return
+		case ws.Stopped():
+			s.jobs.update(job, JobStopped, job.status)
+		case ws.Continued():
+			s.jobs.update(job, JobRunning, job.status)
+		case ws.Signaled():
+			s.jobs.killed(job, ws.Signal())
+			return
+		default:
+			s.jobs.update(job, JobDone, ws.ExitStatus())
+			return
+		}
+	}
+}
+
+// Sends SIGINT to foreground processes the terminal didn't already deliver it to: those in a process group of
+// their own didn't get the Ctrl+C the shell did, and without a terminal nothing reached the shell's group
+func interruptProcesses(pids []int) {
+	shell := syscall.Getpgrp()
+	tty := term.IsTerminal(int(os.Stdin.Fd()))
+	signaled := make(map[int]bool)
+	for _, pid := range pids {
+		pgid, err := syscall.Getpgid(pid)
+		switch {
+		case err != nil || signaled[pgid]:
+		case pgid != shell:
+			signaled[pgid] = true
+			syscall.Kill(-pgid, syscall.SIGINT)
+		case !tty:
+			syscall.Kill(pid, syscall.SIGINT)
+		}
+	}
+}
+
+// Signals kill knows by name
+var signalNames = map[string]syscall.Signal{
+	"HUP": syscall.SIGHUP, "INT": syscall.SIGINT, "QUIT": syscall.SIGQUIT, "ILL": syscall.SIGILL,
+	"TRAP": syscall.SIGTRAP, "ABRT": syscall.SIGABRT, "BUS": syscall.SIGBUS, "FPE": syscall.SIGFPE,
+	"KILL": syscall.SIGKILL, "USR1": syscall.SIGUSR1, "SEGV": syscall.SIGSEGV, "USR2": syscall.SIGUSR2,
+	"PIPE": syscall.SIGPIPE, "ALRM": syscall.SIGALRM, "TERM": syscall.SIGTERM, "CHLD": syscall.SIGCHLD,
+	"CONT": syscall.SIGCONT, "STOP": syscall.SIGSTOP, "TSTP": syscall.SIGTSTP, "TTIN": syscall.SIGTTIN,
+	"TTOU": syscall.SIGTTOU, "URG": syscall.SIGURG, "XCPU": syscall.SIGXCPU, "XFSZ": syscall.SIGXFSZ,
+	"VTALRM": syscall.SIGVTALRM, "PROF": syscall.SIGPROF, "WINCH": syscall.SIGWINCH, "IO": syscall.SIGIO,
+	"SYS": syscall.SIGSYS,
+}
+
+// Sends a signal to a process, or to a process group when pid is negative
+func signalProcess(pid int, sig syscall.Signal) error {
+	return syscall.Kill(pid, sig)
+}
+
+// Sends a signal to the job's process group. A stopped job is continued after a signal that ends it, for
+// the signal to be acted upon
+func signalJob(job *Job, sig syscall.Signal) error {
+	if err := syscall.Kill(-job.pgid, sig); err != nil {
+		return err
+	}
+	switch sig {
+	case 0, syscall.SIGCONT, syscall.SIGSTOP, syscall.SIGTSTP, syscall.SIGTTIN, syscall.SIGTTOU:
+	default:
+		if job.state == JobStopped {
+			syscall.Kill(-job.pgid, syscall.SIGCONT)
+		}
+	}
+	return nil
+}
+
+// Sends SIGCONT to the job's process group
+func continueJob(job *Job) error {
+	return syscall.Kill(-job.pid, syscall.SIGCONT)
+}
+
+// Sends SIGHUP to the job's process group, stopped jobs are continued so they can act on it
+func hangupJob(job *Job) {
+	syscall.Kill(-job.pid, syscall.SIGHUP)
+	if job.state == JobStopped {
+		syscall.Kill(-job.pid, syscall.SIGCONT)
+	}
+}
+
+// Hands the terminal to the job's process group, resumes it and waits until it finishes or stops again,
+// returns the exit status of the job as the one of a foreground command
+func (s *Shell) foregroundJob(job *Job) (int, error) {
+	tty := int(os.Stdin.Fd())
+	signal.Ignore(syscall.SIGTTOU)
+	setForeground(tty, job.pid)
+	defer setForeground(tty, syscall.Getpgrp())
+
+	if job.state == JobStopped {
+		s.jobs.update(job, JobRunning, job.status)
+		if err := continueJob(job); err != nil {
+			return 1, err
+		}
+	}
+
+	if s.jobs.wait(job) == JobDone {
+		s.jobs.remove(job)
+		// The terminal echoed ^C, finish its line
+		if job.status == 128+int(syscall.SIGINT) {
+			fmt.Println()
+		}
+		return job.status, nil
+	}
+
+	s.jobs.mu.Lock()
+	fmt.Printf("\n%s\n", s.jobs.format(job))
+	s.jobs.mu.Unlock()
+	return 128 + int(syscall.SIGTSTP), nil
+}
+
+// Makes pgid the foreground process group of the terminal, does nothing when fd is not a terminal
+func setForeground(fd int, pgid int) {
+	pg := int32(pgid)
+	syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), uintptr(syscall.TIOCSPGRP), uintptr(unsafe.Pointer(&pg)))
+}
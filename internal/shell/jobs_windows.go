@@ -0,0 +1,145 @@
+//go:build windows
+
+package shell
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"sync"
+	"syscall"
+)
+
+// Job Objects group a background job's process with the processes it starts, so kill and hangups reach the
+// whole tree like a process group signal does on unix. CTRL_BREAK events stand in for SIGINT
+var (
+	kernel32                     = syscall.NewLazyDLL("kernel32.dll")
+	procCreateJobObject          = kernel32.NewProc("CreateJobObjectW")
+	procAssignProcessToJobObject = kernel32.NewProc("AssignProcessToJobObject")
+	procTerminateJobObject       = kernel32.NewProc("TerminateJobObject")
+	procGenerateConsoleCtrlEvent = kernel32.NewProc("GenerateConsoleCtrlEvent")
+)
+
+const (
+	processSetQuota = 0x0100
+	ctrlBreakEvent  = 1
+)
+
+// Job Objects of the background jobs by process id, and the signal each job was last sent
+var (
+	jobObjectsMu sync.Mutex
+	jobObjects   = make(map[int]syscall.Handle)
+	jobSignals   = make(map[int]syscall.Signal)
+)
+
+// Puts the command in a process group of its own so console control events aimed at the shell don't reach it
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP}
+}
+
+// Pipeline stages stay in the shell's process group, consoles have no foreground group to hand over
+func joinProcessGroup(cmd *exec.Cmd, pgid int) {}
+
+func reclaimTerminal() {}
+
+// Puts a background job's process in a Job Object of its own, the processes it starts join it. Without one
+// the job is still run, kill then only reaches its own process
+func trackJob(job *Job) {
+	object, _, _ := procCreateJobObject.Call(0, 0)
+	if object == 0 {
+		return
+	}
+	process, err := syscall.OpenProcess(processSetQuota|syscall.PROCESS_TERMINATE, false, uint32(job.pid))
+	if err != nil {
+		syscall.CloseHandle(syscall.Handle(object))
+		return
+	}
+	defer syscall.CloseHandle(process)
+	if ok, _, _ := procAssignProcessToJobObject.Call(object, uintptr(process)); ok == 0 {
+		syscall.CloseHandle(syscall.Handle(object))
+		return
+	}
+	jobObjectsMu.Lock()
+	jobObjects[job.pid] = syscall.Handle(object)
+	jobObjectsMu.Unlock()
+}
+
+// Waits on the job's process until it exits, windows has no notion of stopped processes. A job that exits
+// after kill sent it a signal is reported as killed by it
+func (s *Shell) monitorJob(job *Job) {
+	job.cmd.Wait()
+	jobObjectsMu.Lock()
+	if object, exists := jobObjects[job.pid]; exists {
+		syscall.CloseHandle(object)
+		delete(jobObjects, job.pid)
+	}
+	sig, signaled := jobSignals[job.pid]
+	delete(jobSignals, job.pid)
+	jobObjectsMu.Unlock()
+	if signaled {
+		s.jobs.killed(job, sig)
+		return
+	}
+	s.jobs.update(job, JobDone, job.cmd.ProcessState.ExitCode())
+}
+
+func continueJob(job *Job) error {
+	return fmt.Errorf("job control is not supported on windows")
+}
+
+// The console sends Ctrl+C to every process attached to it, there is nothing to pass on
+func interruptProcesses(pids []int) {}
+
+// Signals kill knows by name, every one of them terminates the process
+var signalNames = map[string]syscall.Signal{
+	"INT":  syscall.SIGINT,
+	"KILL": syscall.SIGKILL,
+	"TERM": syscall.SIGTERM,
+}
+
+// Windows can't deliver signals: SIGINT becomes a CTRL_BREAK event for the process group the process leads,
+// other signals terminate it, with its Job Object when it is a background job. Signal 0 only checks that
+// it exists
+func signalProcess(pid int, sig syscall.Signal) error {
+	proc, err := os.FindProcess(pid)
+	if err != nil || sig == 0 {
+		return err
+	}
+	defer proc.Release()
+	if sig == syscall.SIGINT {
+		if ok, _, err := procGenerateConsoleCtrlEvent.Call(ctrlBreakEvent, uintptr(pid)); ok == 0 {
+			return err
+		}
+		return nil
+	}
+	jobObjectsMu.Lock()
+	object, exists := jobObjects[pid]
+	if exists {
+		jobSignals[pid] = sig
+	}
+	jobObjectsMu.Unlock()
+	if exists {
+		if ok, _, err := procTerminateJobObject.Call(uintptr(object), uintptr(128+int(sig))); ok == 0 {
+			return err
+		}
+		return nil
+	}
+	return proc.Kill()
+}
+
+// Sends a signal to the job, see signalProcess
+func signalJob(job *Job, sig syscall.Signal) error {
+	return signalProcess(job.pid, sig)
+}
+
+// Windows has no SIGHUP, jobs are terminated instead
+func hangupJob(job *Job) {
+	signalProcess(job.pid, syscall.SIGTERM)
+}
+
+// Waits for the job to finish in the foreground
+func (s *Shell) foregroundJob(job *Job) (int, error) {
+	status := s.jobs.waitDone(job)
+	s.jobs.remove(job)
+	return status, nil
+}
@@ -0,0 +1,272 @@
+package shell
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ** Options **
+// ------------------------------------------------------------------------------------------
+
+// Shell behavior toggles, every option the shell consults is registered in optionDefaults
+type Options struct {
+	values map[string]bool
+}
+
+// Registered options and their default values
+var optionDefaults = map[string]bool{
+	"alias_preview":        true,  // show what an alias typed as the first word expands to, dimmed after the line
+	"autocd":               false, // a command that names a directory cds into it
+	"clobber_confirm":      false, // an interactive > onto an existing file asks before truncating it, >| doesn't ask
+	"color_stderr":         false, // what external commands write to stderr on the terminal is shown in red
+	"complete_ignore_case": false, // completion matches candidates case-insensitively
+	"complete_processes":   false, // kill completes system process IDs, by PID or process name, besides job specs
+	"dirhistory":           false, // ↑ and Ctrl+R offer commands previously run in the current directory first
+	"dirhistory_strict":    false, // ↑ and Ctrl+R only offer commands previously run in the current directory
+	"dotglob":              false, // globs and completion include files starting with '.'
+	"emacs":                true,  // the line editor takes emacs-style keys
+	"errexit":              false, // the shell exits when a command fails outside an && or || list
+	"functions_first":      false, // functions take precedence over builtins of the same name, as in bash
+	"glob_collate":         false, // glob matches are sorted in the locale's collation order instead of byte order
+	"globcomplete":         true,  // Tab on a word containing glob characters expands it in place
+	"histappend":           true,  // the history store keeps every session's lines; unset, an interactive shell's list overwrites it on exit
+	"histencrypt":          false, // history file entries are encrypted with a key kept in the system keyring, HISTSTORE=sqlite is refused
+	"histexpand":           true,  // !! and other ! history events are expanded before a line is run
+	"histsecrets":          false, // lines that look like they hold passwords, tokens or keys are kept out of history
+	"interactive":          false, // the shell reads commands from a terminal (readonly)
+	"keep_tty_changes":     false, // terminal modes changed by a foreground command persist instead of being reset
+	"login_shell":          false, // the shell was started as a login shell (readonly)
+	"noclobber":            false, // > refuses to overwrite existing files, >| forces it
+	"nullglob":             false, // a glob matching no files expands to nothing instead of itself
+	"prompt_marks":         false, // emit OSC 133 semantic prompt marks around prompts and command output
+	"rm_confirm":           false, // an interactive rm asks first when globs matched more than RM_CONFIRM_THRESHOLD (10) files
+	"rusage":               false, // print max RSS, CPU times and context switches after each external command
+	"vi":                   false, // the line editor has vi-style insert and command modes, Esc switches to commands
+	"xtrace":               false, // print each command with its arguments, after PS4, before it runs
+}
+
+// Single-letter flags of set for options, also reported by $-
+var optionFlags = map[byte]string{
+	'e': "errexit",
+	'x': "xtrace",
+	'C': "noclobber",
+	'H': "histexpand",
+}
+
+// Options describing how the shell was started, they can be queried but not set
+var readonlyOptions = map[string]bool{
+	"interactive": true,
+	"login_shell": true,
+}
+
+// Options of which exactly one is on, enabling one disables the other and disabling one enables the other
+var exclusiveOptions = map[string]string{
+	"emacs": "vi",
+	"vi":    "emacs",
+}
+
+// Creates an Options set holding the default values
+func NewOptions() *Options {
+	o := &Options{values: make(map[string]bool)}
+	for name, value := range optionDefaults {
+		o.values[name] = value
+	}
+	return o
+}
+
+// Value of an option, unknown options are off
+func (o *Options) Get(name string) bool {
+	return o.values[name]
+}
+
+// Sets an option, fails for unknown and readonly option names
+func (o *Options) Set(name string, value bool) error {
+	if _, exists := o.values[name]; !exists {
+		return fmt.Errorf("%s: invalid shell option name", name)
+	}
+	if readonlyOptions[name] {
+		return fmt.Errorf("%s: cannot set readonly option", name)
+	}
+	o.values[name] = value
+	if other, exists := exclusiveOptions[name]; exists {
+		o.values[other] = !value
+	}
+	return nil
+}
+
+// Names of all options, sorted
+func (o *Options) Names() []string {
+	names := []string{}
+	for name := range o.values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Single-letter flags of the enabled options as reported by $-
+func (s *Shell) flags() string {
+	flags := ""
+	if s.options.Get("interactive") {
+		flags += "i"
+	}
+	if s.options.Get("login_shell") {
+		flags += "l"
+	}
+	for _, flag := range "exCH" {
+		if s.options.Get(optionFlags[byte(flag)]) {
+			flags += string(flag)
+		}
+	}
+	return flags
+}
+
+// Shell builtin shopt: -s enables, -u disables, -q only reports through the exit status, -p prints reusable commands
+func (s *Shell) shopt(args []string, std Streams) error {
+	var enable, disable, quiet, reusable bool
+
+	i := 0
+	for ; i < len(args) && strings.HasPrefix(args[i], "-"); i++ {
+		for _, f := range args[i][1:] {
+			switch f {
+			case 's':
+				enable = true
+			case 'u':
+				disable = true
+			case 'q':
+				quiet = true
+			case 'p':
+				reusable = true
+			default:
+				return fmt.Errorf("shopt: -%c: invalid option\nshopt: usage: shopt [-pqsu] [optname ...]", f)
+			}
+		}
+	}
+	names := args[i:]
+	if enable && disable {
+		return fmt.Errorf("shopt: cannot set and unset shell options simultaneously")
+	}
+
+	if (enable || disable) && len(names) > 0 {
+		for _, name := range names {
+			if err := s.options.Set(name, enable); err != nil {
+				return fmt.Errorf("shopt: %v", err)
+			}
+		}
+		return nil
+	}
+
+	if len(names) == 0 {
+		for _, name := range s.options.Names() {
+			if (enable && !s.options.Get(name)) || (disable && s.options.Get(name)) {
+				continue
+			}
+			names = append(names, name)
+		}
+	}
+
+	allOn := true
+	for _, name := range names {
+		if _, exists := optionDefaults[name]; !exists {
+			return fmt.Errorf("shopt: %s: invalid shell option name", name)
+		}
+		on := s.options.Get(name)
+		allOn = allOn && on
+		switch {
+		case quiet:
+		case reusable && on:
+			fmt.Fprintf(std.stdout, "shopt -s %s\n", name)
+		case reusable:
+			fmt.Fprintf(std.stdout, "shopt -u %s\n", name)
+		case on:
+			fmt.Fprintf(std.stdout, "%-16s\ton\n", name)
+		default:
+			fmt.Fprintf(std.stdout, "%-16s\toff\n", name)
+		}
+	}
+	if quiet && !allOn {
+		return ExitStatus(1)
+	}
+
+	return nil
+}
+
+// Shell builtin setopt, zsh-style enabling of options, lists the enabled ones without arguments
+func (s *Shell) setopt(args []string, std Streams) error {
+	return s.shopt(append([]string{"-s"}, args...), std)
+}
+
+// Shell builtin unsetopt, zsh-style disabling of options
+func (s *Shell) unsetopt(args []string, std Streams) error {
+	if len(args) == 0 {
+		return s.shopt([]string{"-u"}, std)
+	}
+	return s.shopt(append([]string{"-u"}, args...), std)
+}
+
+// Shell builtin set: without arguments lists every shell variable. -o name and +o name (or the single-letter
+// flag, -e +e and so on) enable and disable options, -o alone lists them and +o prints set commands restoring them
+func (s *Shell) set(args []string, std Streams) error {
+	if len(args) == 0 {
+		names := []string{}
+		for name := range s.vars {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintln(std.stdout, s.formatSet(name))
+		}
+		return nil
+	}
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		// The arguments after -- or the options replace the positional parameters
+		if arg == "--" || len(arg) < 2 || (arg[0] != '-' && arg[0] != '+') {
+			if arg == "--" {
+				i++
+			}
+			s.positional = append([]string{}, args[i:]...)
+			return nil
+		}
+		enable := arg[0] == '-'
+		if arg == "-o" || arg == "+o" {
+			if i+1 >= len(args) {
+				s.listSetOptions(std, enable)
+				continue
+			}
+			i++
+			if err := s.options.Set(args[i], enable); err != nil {
+				return fmt.Errorf("set: %v", err)
+			}
+			continue
+		}
+		for _, f := range []byte(arg[1:]) {
+			name, exists := optionFlags[f]
+			if !exists {
+				return fmt.Errorf("set: %c%c: invalid option\nset: usage: set [-eCHx] [-o option] [+o option]", arg[0], f)
+			}
+			s.options.Set(name, enable)
+		}
+	}
+	return nil
+}
+
+// Lists the options for set -o (name and on/off) or set +o (set commands)
+func (s *Shell) listSetOptions(std Streams, table bool) {
+	for _, name := range s.options.Names() {
+		on := s.options.Get(name)
+		switch {
+		case table && on:
+			fmt.Fprintf(std.stdout, "%-16s\ton\n", name)
+		case table:
+			fmt.Fprintf(std.stdout, "%-16s\toff\n", name)
+		case readonlyOptions[name]:
+		case on:
+			fmt.Fprintf(std.stdout, "set -o %s\n", name)
+		default:
+			fmt.Fprintf(std.stdout, "set +o %s\n", name)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package shell
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"testing"
+)
+
+// Shell with a few aliases and history entries to exercise alias and history expansion, the history file is
+// neither read nor written
+func fuzzShell(f *testing.F) *Shell {
+	f.Setenv("HISTFILE", "")
+	s := NewShell()
+	s.cmdAlias["ll"] = "ls -l "
+	s.cmdAlias["loop"] = "loop again"
+	s.cmdAlias["pipe"] = "echo x | cat"
+	s.cmdAlias["empty"] = ""
+	for _, line := range []string{"echo one two", "ls -la /tmp", "git commit -m 'message'"} {
+		s.editor.History().Add(line, "/")
+	}
+	return s
+}
+
+// Feeds command lines through history expansion, the parsers and word expansion, none of which may panic
+// whatever the line holds. Commands are parsed and expanded but never run
+func FuzzParseCommand(f *testing.F) {
+	for _, seed := range []string{
+		"",
+		"echo hello world",
+		"echo 'single' \"double $HOME\" \\escaped",
+		"echo 'unterminated",
+		"echo \"unterminated $",
+		"echo trailing\\",
+		"ls | grep x && echo ok || echo fail; sleep 1 &",
+		"a | | b",
+		"&& ||  ; ; & |",
+		"echo > out 2>> err 2>&1 &> both >| forced 3>&- <",
+		"cat <<EOF <<- EOF2 <<< word",
+		"A=1 B=\"two words\" env",
+		"A=~/x:~root/y",
+		"echo ${HOME} ${ ${} ${?} $- $0 $? $$ ${UNSET}x",
+		"echo *.go ?[a-z]* [ [] \\* '*'",
+		"echo ~ ~+ ~- ~1 ~root ~\"quoted\" a~b",
+		"ll -h; loop; pipe | empty",
+		"!! !-1 !$ !^ !* !echo !?two? ^one^uno ! !",
+		"echo \x01\x02\x03\x04 marks",
+		"echo 日本語 ${日本}",
+		"if a; then b && c; elif d | e; then f & else g; fi || h",
+		"case $x in (a|\"b\") c ;; *) d;; esac",
+		"f() { a; b | c; } && { d; }",
+		"timeout 1.5 { a && b; } || timeout 2 c",
+		"if; then fi then else >& fi",
+		"timeout 2 { a; b; } | c | { d; } && if e; then f; fi | case g in g) h;; esac",
+		"a | if | } | fi",
+	} {
+		f.Add(seed)
+	}
+
+	s := fuzzShell(f)
+	f.Fuzz(func(t *testing.T, line string) {
+		if expanded, err := s.expandHistory(line); err == nil {
+			line = expanded
+		}
+		(&splitter{}).split(line)
+		s.parseCommand(line)
+		for _, cmd := range s.stack {
+			s.expandCommand(cmd)
+		}
+	})
+}
+
+// A compound command joined by | to other commands is a stage of a pipeline, whose stages of simple commands
+// may be pipelines themselves
+func TestCompoundPipeline(t *testing.T) {
+	s := scriptShell(t)
+	for line, want := range map[string][]string{
+		"timeout 2 { echo a; echo b; } | cat":                  {"*shell.timeoutCommand", "[]shell.Command"},
+		"if true; then echo a; fi | cat":                       {"*shell.ifCommand", "[]shell.Command"},
+		"echo a | tr a b | { cat; } | case x in *) cat;; esac": {"[]shell.Command", "*shell.groupCommand", "*shell.caseCommand"},
+	} {
+		b, err := s.readCompound(line, func(string) (string, error) { return "", io.EOF })
+		if err != nil || len(b) != 1 {
+			t.Errorf("readCompound(%q) = %d nodes, %v, want a pipeline", line, len(b), err)
+			continue
+		}
+		pipeline, ok := b[0].compound.(*pipelineCommand)
+		if !ok {
+			t.Errorf("readCompound(%q) = %T, want a pipeline", line, b[0].compound)
+			continue
+		}
+		got := []string{}
+		for _, stage := range pipeline.stages {
+			if stage.compound != nil {
+				got = append(got, fmt.Sprintf("%T", stage.compound))
+			} else {
+				got = append(got, fmt.Sprintf("%T", stage.stack))
+			}
+		}
+		if !slices.Equal(got, want) {
+			t.Errorf("readCompound(%q) stages = %v, want %v", line, got, want)
+		}
+	}
+
+	for _, line := range []string{"echo a | fi", "{ echo a; } | }", "if true; then echo; fi | then"} {
+		if _, err := s.readCompound(line, func(string) (string, error) { return "", io.EOF }); err == nil {
+			t.Errorf("readCompound(%q) succeeded, want a syntax error", line)
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package shell
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// ** printf **
+// ------------------------------------------------------------------------------------------
+
+// Shell builtin printf, formats args like printf(1): %s, %b (expanding backslash escapes), %q (quoted for reuse
+// as shell input), %c, %d, %i, %o, %u, %x, %X, %e, %f, %g and %% with flags, width and precision. The format is
+// reused while arguments remain. -v name assigns the output to a variable instead of printing it
+func (s *Shell) printf(args []string, std Streams) error {
+	target := ""
+	if len(args) > 0 && args[0] == "-v" {
+		if len(args) < 2 {
+			return fmt.Errorf("printf: -v: option requires an argument")
+		}
+		target = args[1]
+		if !isValidName(target) {
+			return fmt.Errorf("printf: '%s': not a valid identifier", target)
+		}
+		args = args[2:]
+	}
+	if len(args) > 0 && args[0] == "--" {
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		return fmt.Errorf("printf: usage: %s", builtinDocs["printf"].Usage)
+	}
+
+	out, err := formatPrintf(args[0], args[1:])
+	if target != "" {
+		if err := s.setVar(target, out); err != nil {
+			return fmt.Errorf("printf: %v", err)
+		}
+	} else {
+		io.WriteString(std.stdout, out)
+	}
+	return err
+}
+
+// Applies a printf format to args, repeating it until every argument is used. Missing arguments are empty
+// strings or zero. Arguments that aren't numbers are reported after the whole output is produced
+func formatPrintf(format string, args []string) (string, error) {
+	var out strings.Builder
+	var errs []error
+	for {
+		used := 0
+		next := func() string {
+			used++
+			if used <= len(args) {
+				return args[used-1]
+			}
+			return ""
+		}
+		number := func() int64 {
+			arg := next()
+			n, err := parseNumber(arg)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("printf: %s: invalid number", arg))
+			}
+			return n
+		}
+
+		for i := 0; i < len(format); {
+			switch {
+			case format[i] == '\\':
+				text, n := unescape(format[i:], false)
+				out.WriteString(text)
+				i += n
+				continue
+			case format[i] != '%':
+				out.WriteByte(format[i])
+				i++
+				continue
+			case strings.HasPrefix(format[i:], "%%"):
+				out.WriteByte('%')
+				i += 2
+				continue
+			}
+
+			j := i + 1
+			for j < len(format) && strings.IndexByte("-+ #0", format[j]) >= 0 {
+				j++
+			}
+			for j < len(format) && format[j] >= '0' && format[j] <= '9' {
+				j++
+			}
+			if j < len(format) && format[j] == '.' {
+				j++
+				for j < len(format) && format[j] >= '0' && format[j] <= '9' {
+					j++
+				}
+			}
+			if j == len(format) {
+				return out.String(), fmt.Errorf("printf: %s: missing format character", format[i:])
+			}
+			spec, verb := format[i:j], format[j]
+			switch verb {
+			case 's':
+				fmt.Fprintf(&out, spec+"s", next())
+			case 'b':
+				arg, stop := unescapeAll(next())
+				fmt.Fprintf(&out, spec+"s", arg)
+				if stop {
+					return out.String(), errors.Join(errs...)
+				}
+			case 'q':
+				quoted := escapeWord(next())
+				if quoted == "" {
+					quoted = "''"
+				}
+				fmt.Fprintf(&out, spec+"s", quoted)
+			case 'c':
+				r, _ := utf8.DecodeRuneInString(next())
+				if r != utf8.RuneError {
+					fmt.Fprintf(&out, spec+"c", r)
+				}
+			case 'd', 'i':
+				fmt.Fprintf(&out, spec+"d", number())
+			case 'o', 'x', 'X':
+				fmt.Fprintf(&out, spec+string(verb), number())
+			case 'u':
+				fmt.Fprintf(&out, spec+"d", uint64(number()))
+			case 'e', 'E', 'f', 'F', 'g', 'G':
+				arg := next()
+				f, err := strconv.ParseFloat(strings.TrimSpace(arg), 64)
+				if err != nil && arg != "" {
+					errs = append(errs, fmt.Errorf("printf: %s: invalid number", arg))
+				}
+				fmt.Fprintf(&out, spec+string(verb), f)
+			default:
+				return out.String(), fmt.Errorf("printf: %c: invalid format character", verb)
+			}
+			i = j + 1
+		}
+
+		if used == 0 || used >= len(args) {
+			break
+		}
+		args = args[used:]
+	}
+	return out.String(), errors.Join(errs...)
+}
+
+// Integer argument of printf: decimal, 0x hexadecimal, 0 octal, or 'c for the code of character c
+func parseNumber(arg string) (int64, error) {
+	arg = strings.TrimSpace(arg)
+	if arg == "" {
+		return 0, nil
+	}
+	if arg[0] == '\'' || arg[0] == '"' {
+		r, _ := utf8.DecodeRuneInString(arg[1:])
+		return int64(r), nil
+	}
+	return strconv.ParseInt(arg, 0, 64)
+}
+
+// Backslash escapes standing for one character
+var simpleEscapes = map[byte]string{
+	'\\': "\\", 'a': "\a", 'b': "\b", 'e': "\033", 'f': "\f", 'n': "\n", 'r': "\r", 't': "\t", 'v': "\v", '"': "\"", '\'': "'",
+}
+
+// Expands the backslash escape at the start of text, returning it and how many bytes it took. Octal escapes are
+// \NNN, in a %b argument \0NNN too
+func unescape(text string, argument bool) (string, int) {
+	if len(text) < 2 {
+		return text, len(text)
+	}
+	if s, ok := simpleEscapes[text[1]]; ok {
+		return s, 2
+	}
+
+	digits := func(start, max int, base int, valid string) (string, int) {
+		end := start
+		for end < len(text) && end-start < max && strings.IndexByte(valid, text[end]) >= 0 {
+			end++
+		}
+		if end == start {
+			return text[:2], 2
+		}
+		n, _ := strconv.ParseUint(text[start:end], base, 32)
+		return string([]byte{byte(n)}), end
+	}
+	switch {
+	case text[1] == 'x':
+		return digits(2, 2, 16, "0123456789abcdefABCDEF")
+	case argument && text[1] == '0':
+		if len(text) == 2 || text[2] < '0' || text[2] > '7' {
+			return "\x00", 2
+		}
+		return digits(2, 3, 8, "01234567")
+	case text[1] >= '0' && text[1] <= '7':
+		return digits(1, 3, 8, "01234567")
+	}
+	return text[:2], 2
+}
+
+// Unescapes a whole %b argument, stop tells a \c was met: it ends the argument and the output of printf
+func unescapeAll(text string) (unescaped string, stop bool) {
+	var out strings.Builder
+	for i := 0; i < len(text); {
+		if text[i] != '\\' {
+			out.WriteByte(text[i])
+			i++
+			continue
+		}
+		if strings.HasPrefix(text[i:], "\\c") {
+			return out.String(), true
+		}
+		expanded, n := unescape(text[i:], true)
+		out.WriteString(expanded)
+		i += n
+	}
+	return out.String(), false
+}
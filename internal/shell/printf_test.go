@@ -0,0 +1,50 @@
+package shell
+
+import (
+	"testing"
+)
+
+// printf formats, escapes and reuses its format like printf(1)
+func TestFormatPrintf(t *testing.T) {
+	for _, test := range []struct {
+		format string
+		args   []string
+		want   string
+		err    string
+	}{
+		{"%s-%s\n", []string{"a", "b"}, "a-b\n", ""},
+		{"%s=%d\n", []string{"a", "1", "b", "2"}, "a=1\nb=2\n", ""},
+		{"%s %s|", []string{"x", "y", "z"}, "x y|z |", ""},
+		{"%d %s|", nil, "0 |", ""},
+		{"no verbs\n", []string{"ignored", "too"}, "no verbs\n", ""},
+		{"100%%\n", nil, "100%\n", ""},
+		{"[%5s][%-5s][%.2s]", []string{"ab", "cd", "efgh"}, "[   ab][cd   ][ef]", ""},
+		{"%d %i %o %x %X %u", []string{"10", "0x10", "8", "255", "255", "-1"}, "10 16 10 ff FF 18446744073709551615", ""},
+		{"%d %x", []string{"'A", "\"a"}, "65 61", ""},
+		{"%05.1f %e %g", []string{"3.14159", "1500", "0.5"}, "003.1 1.500000e+03 0.5", ""},
+		{"%c%c", []string{"héllo", "é"}, "hé", ""},
+		{"%q %q %q", []string{"a b", "it's", ""}, `a\ b it\'s ''`, ""},
+		{"%q %q", []string{"cost$HOME.txt", "a!b;`c`"}, "cost\\$HOME.txt a\\!b\\;\\`c\\`", ""},
+		{"%q %q %q %q", []string{"star*.log", "a?b x[1]", "~user", "#notes~#"}, `star\*.log a\?b\ x\[1] \~user \#notes~#`, ""},
+
+		// Octal escapes are \NNN, a %b argument takes \0NNN too: in the format \0101 is \010 then 1
+		{`\101\0101|`, nil, "A\x081|", ""},
+		{"%b|%b|%b|%b", []string{`\0101`, `\101`, `\01234`, `\0`}, "A|A|S4|\x00", ""},
+		{`%b \x41\t\e[0m`, []string{`tab\there`}, "tab\there A\t\033[0m", ""},
+		{"%s %b %s\n", []string{"a", `b\cc`, "d"}, "a b", ""},
+
+		{"%d,", []string{"1", "abc", "3"}, "1,0,3,", "printf: abc: invalid number"},
+		{"%f", []string{"1.5x"}, "0.000000", "printf: 1.5x: invalid number"},
+		{"a%5", nil, "a", "printf: %5: missing format character"},
+		{"a%zb", nil, "a", "printf: z: invalid format character"},
+	} {
+		got, err := formatPrintf(test.format, test.args)
+		errText := ""
+		if err != nil {
+			errText = err.Error()
+		}
+		if got != test.want || errText != test.err {
+			t.Errorf("formatPrintf(%q, %q) = %q, %q, want %q, %q", test.format, test.args, got, errText, test.want, test.err)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package shell
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/codecrafters-io/shell-starter-go/internal/color"
+	"github.com/codecrafters-io/shell-starter-go/internal/editor"
+)
+
+// ** Prompt **
+// ------------------------------------------------------------------------------------------
+
+// Widest cwd shown by the \p prompt escape when PROMPT_DIRWIDTH isn't set
+const defaultDirWidth = 30
+
+// Prompt shown by the line editor: the PROMPT_THEME theme, or PS1, or a prompt character colored by the status
+// of the last command after the count of jobs. Wrapped in semantic prompt marks when prompt_marks is set
+func (s *Shell) prompt() string {
+	prompt, themed := s.statusPromptChar()+" ", false
+	if jobs := s.jobsIndicator(); jobs != "" {
+		prompt = color.Wrap(color.Yellow, jobs) + " " + prompt
+	}
+	if name, exists := s.getVar("PROMPT_THEME"); exists && name != "" {
+		if theme, ok := s.themePrompt(name); ok {
+			prompt, themed = theme, true
+		}
+	}
+	if ps1, exists := s.getVar("PS1"); !themed && exists && ps1 != "" {
+		prompt = s.expandPrompt(ps1)
+	}
+	if !color.Enabled(os.Stdout) {
+		prompt = color.Strip(prompt)
+	}
+	if s.options.Get("prompt_marks") {
+		return "\033]133;A\007" + prompt + "\033]133;B\007"
+	}
+	return prompt
+}
+
+// The continuation prompt shown while reading the rest of a command, such as here-document lines: PS2, "> " by default
+func (s *Shell) secondaryPrompt() string {
+	prompt := "> "
+	if ps2, exists := s.getVar("PS2"); exists && ps2 != "" {
+		prompt = s.expandPrompt(ps2)
+	}
+	if !color.Enabled(os.Stdout) {
+		prompt = color.Strip(prompt)
+	}
+	return prompt
+}
+
+// Emits a semantic prompt mark (OSC 133) so terminals can jump between prompts, tell where a command's output
+// starts (C) and show its exit status (D;status)
+func (s *Shell) promptMark(mark string) {
+	if s.options.Get("prompt_marks") {
+		fmt.Print("\033]133;" + mark + "\007")
+	}
+}
+
+// Expands the backslash escapes of PS1: \w cwd, \W its last component, \p cwd abbreviated to PROMPT_DIRWIDTH
+// columns, \u user, \h host, \j number of jobs, \$ '#' for root and '$' otherwise, \e escape (for colors), \n newline, \\ backslash
+func (s *Shell) expandPrompt(ps1 string) string {
+	var prompt strings.Builder
+	for i := 0; i < len(ps1); i++ {
+		if ps1[i] != '\\' || i == len(ps1)-1 {
+			prompt.WriteByte(ps1[i])
+			continue
+		}
+		i++
+		switch ps1[i] {
+		case 'w':
+			prompt.WriteString(s.promptDir())
+		case 'W':
+			if dir := s.promptDir(); dir == "/" || dir == "~" {
+				prompt.WriteString(dir)
+			} else {
+				prompt.WriteString(filepath.Base(dir))
+			}
+		case 'p':
+			width := defaultDirWidth
+			if value, exists := s.getVar("PROMPT_DIRWIDTH"); exists {
+				if n, err := strconv.Atoi(value); err == nil && n > 0 {
+					width = n
+				}
+			}
+			prompt.WriteString(abbreviateDir(s.promptDir(), width))
+		case 'u':
+			user, _ := s.getVar("USER")
+			prompt.WriteString(user)
+		case 'h':
+			host, _ := os.Hostname()
+			host, _, _ = strings.Cut(host, ".")
+			prompt.WriteString(host)
+		case 'j':
+			prompt.WriteString(strconv.Itoa(s.jobs.active()))
+		case '$':
+			prompt.WriteString(promptChar())
+		case 'e':
+			prompt.WriteByte(0x1b)
+		case 'n':
+			prompt.WriteString("\r\n")
+		case '\\':
+			prompt.WriteByte('\\')
+		default:
+			prompt.WriteByte('\\')
+			prompt.WriteByte(ps1[i])
+		}
+	}
+	return prompt.String()
+}
+
+// The default prompt's '$', green after a command succeeded and red after it failed
+func (s *Shell) statusPromptChar() string {
+	if s.status == 0 {
+		return color.Wrap(color.Green, "$")
+	}
+	return color.Wrap(color.Red, "$")
+}
+
+// Count of the running and stopped jobs as shown in prompts, "[2 jobs]" or PROMPT_JOBS with %d replaced by
+// the count. Nothing when there are no jobs or PROMPT_JOBS is empty
+func (s *Shell) jobsIndicator() string {
+	n := s.jobs.active()
+	if n == 0 {
+		return ""
+	}
+	if format, exists := s.getVar("PROMPT_JOBS"); exists {
+		return strings.ReplaceAll(format, "%d", strconv.Itoa(n))
+	}
+	if n == 1 {
+		return "[1 job]"
+	}
+	return fmt.Sprintf("[%d jobs]", n)
+}
+
+// '#' for root and '$' otherwise
+func promptChar() string {
+	if os.Geteuid() == 0 {
+		return "#"
+	}
+	return "$"
+}
+
+// Current directory with $HOME collapsed to ~
+func (s *Shell) promptDir() string {
+	dir, err := os.Getwd()
+	if err != nil {
+		return "?"
+	}
+	return s.collapseHome(dir)
+}
+
+// Replaces $HOME at the start of dir with ~
+func (s *Shell) collapseHome(dir string) string {
+	home, _ := s.getVar("HOME")
+	if home != "" && home != "/" && (dir == home || strings.HasPrefix(dir, home+string(os.PathSeparator))) {
+		dir = "~" + dir[len(home):]
+	}
+	return dir
+}
+
+// Shortens the middle components of dir to their first letter, starting with the outermost, until it fits in
+// width columns (~/projects/myshell/src becomes ~/p/m/src). The first and last components are kept whole
+func abbreviateDir(dir string, width int) string {
+	sep := string(os.PathSeparator)
+	parts := strings.Split(dir, sep)
+	for i := 1; i < len(parts)-1 && editor.StringWidth(strings.Join(parts, sep)) > width; i++ {
+		if part := parts[i]; part != "" {
+			n := 1
+			if strings.HasPrefix(part, ".") && len(part) > 1 {
+				n = 2
+			}
+			// Keep whole runes so non-ASCII names aren't cut in the middle of a character
+			parts[i] = string([]rune(part)[:min(n, len([]rune(part)))])
+		}
+	}
+	return strings.Join(parts, sep)
+}
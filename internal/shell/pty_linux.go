@@ -0,0 +1,125 @@
+package shell
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"golang.org/x/term"
+)
+
+// ** Pseudo-terminals **
+// ------------------------------------------------------------------------------------------
+
+// Opens a new pseudo-terminal: the master end the shell reads and writes, the slave end a command gets as
+// its terminal
+func openPty() (master, slave *os.File, err error) {
+	master, err = os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	unlock := int32(0)
+	if err := ioctl(master.Fd(), syscall.TIOCSPTLCK, unsafe.Pointer(&unlock)); err != nil {
+		master.Close()
+		return nil, nil, err
+	}
+	var n uint32
+	if err := ioctl(master.Fd(), syscall.TIOCGPTN, unsafe.Pointer(&n)); err != nil {
+		master.Close()
+		return nil, nil, err
+	}
+	slave, err = os.OpenFile(fmt.Sprintf("/dev/pts/%d", n), os.O_RDWR|syscall.O_NOCTTY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		master.Close()
+		return nil, nil, err
+	}
+	return master, slave, nil
+}
+
+func ioctl(fd uintptr, request uintptr, arg unsafe.Pointer) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, request, uintptr(arg)); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// Gives the pseudo-terminal the size of the shell's terminal
+func resizePty(master *os.File) {
+	var size [4]uint16 // rows, columns and the pixel sizes
+	if ioctl(os.Stdout.Fd(), syscall.TIOCGWINSZ, unsafe.Pointer(&size)) == nil {
+		ioctl(master.Fd(), syscall.TIOCSWINSZ, unsafe.Pointer(&size))
+	}
+}
+
+// Follows the shell's terminal size on the pseudo-terminal until the returned function is called
+func followResizes(master *os.File) (stop func()) {
+	resizePty(master)
+	winch := make(chan os.Signal, 1)
+	signal.Notify(winch, syscall.SIGWINCH)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-winch:
+				resizePty(master)
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(winch)
+		close(done)
+	}
+}
+
+// Runs a command on a pseudo-terminal of its own, as the leader of a new session controlled by it. What the
+// command writes goes to output, keys typed on the shell's terminal go to it unprocessed: the
+// pseudo-terminal turns Ctrl+C and Ctrl+Z into signals for the command's foreground process group
+func (s *Shell) runOnPty(ext *exec.Cmd, output io.Writer) error {
+	master, slave, err := openPty()
+	if err != nil {
+		return err
+	}
+	defer master.Close()
+	stopResizes := followResizes(master)
+	defer stopResizes()
+
+	ext.Stdin, ext.Stdout, ext.Stderr = slave, slave, slave
+	ext.SysProcAttr = &syscall.SysProcAttr{Setsid: true, Setctty: true}
+	err = ext.Start()
+	slave.Close()
+	if err != nil {
+		return err
+	}
+	defer s.jobs.setRunning([]int{ext.Process.Pid}, nil)()
+
+	fd := int(os.Stdin.Fd())
+	if state, err := term.MakeRaw(fd); err == nil {
+		defer term.Restore(fd, state)
+	}
+	input, release := interruptible(os.Stdin)
+	typed := make(chan struct{})
+	go func() {
+		io.Copy(master, input)
+		close(typed)
+	}()
+	copied := make(chan struct{})
+	go func() {
+		// Reading the master fails with EIO once every process holding the slave end is gone
+		io.Copy(output, master)
+		close(copied)
+	}()
+
+	err = ext.Wait()
+	<-copied
+	input.SetReadDeadline(time.Now())
+	<-typed
+	release()
+	return err
+}
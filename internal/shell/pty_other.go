@@ -0,0 +1,24 @@
+//go:build !linux
+
+package shell
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+)
+
+// Pseudo-terminals are only opened on linux
+
+func openPty() (master, slave *os.File, err error) {
+	return nil, nil, fmt.Errorf("pseudo-terminals are not supported on this platform")
+}
+
+func followResizes(master *os.File) (stop func()) {
+	return func() {}
+}
+
+func (s *Shell) runOnPty(ext *exec.Cmd, output io.Writer) error {
+	return fmt.Errorf("pseudo-terminals are not supported on this platform")
+}
@@ -0,0 +1,173 @@
+package shell
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// ** read **
+// ------------------------------------------------------------------------------------------
+
+// Shell builtin read, reads a line from stdin and splits it on IFS into words assigned to the names, the last
+// name getting the rest of the line (REPLY without names). -a assigns the words to an array instead, -r keeps
+// backslashes, -p shows a prompt when reading from a terminal and -t gives up after a number of seconds, assigning
+// what was read. Fails at end of file, with 130 on Ctrl+C and with 142 on timeout
+func (s *Shell) read(args []string, std Streams) error {
+	raw := false
+	array, prompt := "", ""
+	timeout := time.Duration(-1)
+	i := 0
+	for ; i < len(args) && strings.HasPrefix(args[i], "-") && args[i] != "-"; i++ {
+		switch args[i] {
+		case "--":
+			i++
+		case "-r":
+			raw = true
+			continue
+		case "-a", "-p", "-t":
+			if i+1 >= len(args) {
+				return fmt.Errorf("read: %s: option requires an argument", args[i])
+			}
+			switch args[i] {
+			case "-a":
+				array = args[i+1]
+			case "-p":
+				prompt = args[i+1]
+			case "-t":
+				seconds, err := strconv.ParseFloat(args[i+1], 64)
+				if err != nil || seconds < 0 {
+					return fmt.Errorf("read: %s: invalid timeout specification", args[i+1])
+				}
+				timeout = time.Duration(seconds * float64(time.Second))
+			}
+			i++
+			continue
+		default:
+			return fmt.Errorf("read: %s: invalid option\nread: usage: %s", args[i], builtinDocs["read"].Usage)
+		}
+		break
+	}
+	names := args[i:]
+	for _, name := range append(names, array) {
+		if name != "" && !isValidName(name) {
+			return fmt.Errorf("read: '%s': not a valid identifier", name)
+		}
+	}
+
+	if prompt != "" && term.IsTerminal(int(std.stdin.Fd())) {
+		fmt.Fprint(std.stderr, prompt)
+	}
+
+	// Ctrl+C and the timeout both end the read by moving its deadline
+	stdin, release := interruptible(std.stdin)
+	defer release()
+	if timeout >= 0 {
+		stdin.SetReadDeadline(time.Now().Add(timeout))
+	}
+	var interrupted atomic.Bool
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	done := make(chan struct{})
+	defer func() {
+		signal.Stop(interrupt)
+		close(done)
+	}()
+	go func() {
+		select {
+		case <-interrupt:
+			interrupted.Store(true)
+			stdin.SetReadDeadline(time.Now())
+		case <-done:
+		}
+	}()
+
+	line, err := readLine(stdin, raw)
+	switch {
+	case errors.Is(err, os.ErrDeadlineExceeded) && interrupted.Load():
+		// The terminal echoed ^C, finish its line
+		if term.IsTerminal(int(std.stdin.Fd())) {
+			fmt.Fprintln(std.stderr)
+		}
+		return ExitStatus(130)
+	case errors.Is(err, os.ErrDeadlineExceeded):
+		err = ExitStatus(142)
+	case err != nil && line == "":
+		return ExitStatus(1)
+	}
+
+	ifs := " \t\n"
+	if value, exists := s.getVar("IFS"); exists {
+		ifs = value
+	}
+	switch {
+	case array != "":
+		if err := s.setArray(array, strings.FieldsFunc(line, func(r rune) bool { return strings.ContainsRune(ifs, r) })); err != nil {
+			return fmt.Errorf("read: %v", err)
+		}
+	case len(names) == 0:
+		if err := s.setVar("REPLY", line); err != nil {
+			return fmt.Errorf("read: %v", err)
+		}
+	default:
+		for n, name := range names {
+			word := ""
+			if n == len(names)-1 {
+				word = strings.Trim(line, ifs)
+			} else {
+				line = strings.TrimLeft(line, ifs)
+				end := strings.IndexAny(line, ifs)
+				if end == -1 {
+					end = len(line)
+				}
+				word, line = line[:end], line[end:]
+			}
+			if err := s.setVar(name, word); err != nil {
+				return fmt.Errorf("read: %v", err)
+			}
+		}
+	}
+	return err
+}
+
+// Reads one line a byte at a time, so nothing past the newline is consumed from a shared stdin. Unless raw,
+// a backslash escapes the next character and a backslash-newline continues the line
+func readLine(r io.Reader, raw bool) (string, error) {
+	var line strings.Builder
+	var buf [1]byte
+	escaped := false
+	for {
+		n, err := r.Read(buf[:])
+		if n == 0 {
+			if err == nil {
+				continue
+			}
+			if err == io.EOF || err == os.ErrClosed {
+				err = io.EOF
+			}
+			return line.String(), err
+		}
+		c := buf[0]
+		switch {
+		case escaped:
+			escaped = false
+			if c != '\n' {
+				line.WriteByte(c)
+			}
+		case c == '\\' && !raw:
+			escaped = true
+		case c == '\n':
+			return line.String(), nil
+		default:
+			line.WriteByte(c)
+		}
+	}
+}
@@ -0,0 +1,29 @@
+//go:build !windows
+
+package shell
+
+import (
+	"os"
+	"syscall"
+)
+
+// Nonblocking duplicate of file, its reads go through the runtime poller so a read deadline can cut them short.
+// release puts the file back in blocking mode, the mode is shared with everything reading it
+func interruptible(file *os.File) (*os.File, func()) {
+	// The duplicate is marked close-on-exec before a command can be started with it
+	syscall.ForkLock.RLock()
+	fd, err := syscall.Dup(int(file.Fd()))
+	if err == nil {
+		syscall.CloseOnExec(fd)
+	}
+	syscall.ForkLock.RUnlock()
+	if err != nil {
+		return file, func() {}
+	}
+	syscall.SetNonblock(fd, true)
+	dup := os.NewFile(uintptr(fd), file.Name())
+	return dup, func() {
+		syscall.SetNonblock(fd, false)
+		dup.Close()
+	}
+}
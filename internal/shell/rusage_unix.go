@@ -0,0 +1,31 @@
+//go:build !windows
+
+package shell
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"syscall"
+	"time"
+)
+
+// Prints the resource usage of a finished command to stderr, in the style of time -v
+func reportUsage(state *os.ProcessState) {
+	usage, ok := state.SysUsage().(*syscall.Rusage)
+	if !ok {
+		return
+	}
+
+	// ru_maxrss is in kilobytes on linux but in bytes on macOS
+	maxRSS := int64(usage.Maxrss)
+	if runtime.GOOS == "darwin" {
+		maxRSS /= 1024
+	}
+
+	fmt.Fprintf(os.Stderr, "\tMaximum resident set size (kbytes): %d\n", maxRSS)
+	fmt.Fprintf(os.Stderr, "\tUser time (seconds): %.2f\n", time.Duration(usage.Utime.Nano()).Seconds())
+	fmt.Fprintf(os.Stderr, "\tSystem time (seconds): %.2f\n", time.Duration(usage.Stime.Nano()).Seconds())
+	fmt.Fprintf(os.Stderr, "\tVoluntary context switches: %d\n", usage.Nvcsw)
+	fmt.Fprintf(os.Stderr, "\tInvoluntary context switches: %d\n", usage.Nivcsw)
+}
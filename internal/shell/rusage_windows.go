@@ -0,0 +1,14 @@
+//go:build windows
+
+package shell
+
+import (
+	"fmt"
+	"os"
+)
+
+// Prints the CPU times of a finished command to stderr, windows doesn't report memory or context switches
+func reportUsage(state *os.ProcessState) {
+	fmt.Fprintf(os.Stderr, "\tUser time (seconds): %.2f\n", state.UserTime().Seconds())
+	fmt.Fprintf(os.Stderr, "\tSystem time (seconds): %.2f\n", state.SystemTime().Seconds())
+}
@@ -0,0 +1,67 @@
+package shell
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ** Safety **
+// ------------------------------------------------------------------------------------------
+
+// Files rm removes without asking when rm_confirm is set and globs named them, RM_CONFIRM_THRESHOLD overrides it
+const defaultRemoveThreshold = 10
+
+// Runs before a simple command once it is expanded, the command isn't run when it fails
+func (s *Shell) preExec(cmd Command) error {
+	if cmd.op == "rm" && s.options.Get("rm_confirm") {
+		return s.confirmRemoval(cmd)
+	}
+	return nil
+}
+
+// With rm_confirm, an interactive rm whose globs matched more files than the threshold asks before it runs
+func (s *Shell) confirmRemoval(cmd Command) error {
+	threshold := defaultRemoveThreshold
+	if value, exists := s.getVar("RM_CONFIRM_THRESHOLD"); exists {
+		if n, err := strconv.Atoi(strings.TrimSpace(value)); err == nil {
+			threshold = n
+		}
+	}
+	if cmd.globbed <= threshold || !s.options.Get("interactive") {
+		return nil
+	}
+	if !confirm(fmt.Sprintf("rm: remove %d files matched by globs?", cmd.globbed)) {
+		return ExitStatus(1)
+	}
+	return nil
+}
+
+// With clobber_confirm, an interactive shell asks before a redirection truncates an existing regular file
+func (s *Shell) confirmOverwrite(path string) bool {
+	if !s.options.Get("clobber_confirm") || !s.options.Get("interactive") {
+		return true
+	}
+	if fi, err := os.Stat(path); err != nil || !fi.Mode().IsRegular() {
+		return true
+	}
+	return confirm(fmt.Sprintf("overwrite %s?", path))
+}
+
+// Asks a yes or no question on the terminal, only an answer starting with y agrees. The terminal is in
+// cooked mode while commands run, the answer is read a byte at a time so nothing past it is consumed
+func confirm(question string) bool {
+	fmt.Fprintf(os.Stderr, "%s [y/N] ", question)
+	var answer []byte
+	var buf [1]byte
+	for {
+		n, err := os.Stdin.Read(buf[:])
+		if err != nil || n > 0 && buf[0] == '\n' {
+			break
+		}
+		answer = append(answer, buf[:n]...)
+	}
+	reply := strings.ToLower(strings.TrimSpace(string(answer)))
+	return strings.HasPrefix(reply, "y")
+}
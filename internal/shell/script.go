@@ -0,0 +1,102 @@
+package shell
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ** Scripts **
+// ------------------------------------------------------------------------------------------
+
+// Source of the lines a command reads after its own, here-document bodies: the line editor when typed, the
+// rest of the input when run from a script. The prompt is only shown by the editor
+type lineSource func(prompt string) (string, error)
+
+// Runs the commands read from input without prompting or touching the terminal, line by line through the
+// same parser and executor as typed lines. A line ending in a backslash continues on the next one. Returns
+// the status of the last command
+func (s *Shell) RunScript(input io.Reader) int {
+	scanner := bufio.NewScanner(input)
+	scanner.Buffer(nil, 1<<20)
+	next := func(string) (string, error) {
+		if scanner.Scan() {
+			return scanner.Text(), nil
+		}
+		if err := scanner.Err(); err != nil {
+			return "", err
+		}
+		return "", io.EOF
+	}
+
+	for {
+		line, err := next("")
+		if err != nil {
+			break
+		}
+		for strings.HasSuffix(line, "\\") && !strings.HasSuffix(line, "\\\\") {
+			more, err := next("")
+			if err != nil {
+				break
+			}
+			line = line[:len(line)-1] + more
+		}
+		s.execute(line, next)
+	}
+	return s.status
+}
+
+// Runs a script file, as RunScript does. Returns 127 when the file can't be opened
+func (s *Shell) runFile(path string) int {
+	file, err := os.Open(path)
+	if err != nil {
+		s.report(fmt.Errorf("%s: %s", path, describeError(err)))
+		return 127
+	}
+	defer file.Close()
+	return s.RunScript(file)
+}
+
+// Startup file of interactive shells, in the home directory
+const rcFile = ".myshellrc"
+
+// Runs ~/.myshellrc when the shell starts interactively, unless --norc was given, for aliases, exports, prompt
+// settings and options to persist. A missing file is skipped, errors of its commands are reported and the
+// shell starts anyway
+func (s *Shell) loadRC() {
+	if !s.options.Get("interactive") || s.norc {
+		return
+	}
+	path := filepath.Join(s.tilde("~"), rcFile)
+	if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+		return
+	}
+	s.runFile(path)
+}
+
+// Line without its comment: an unquoted # starting a word and everything after it, a #! line included
+func stripComment(line string) string {
+	var single, double, escaped bool
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case escaped:
+			escaped = false
+		case c == '\\' && !single:
+			escaped = true
+		case c == '\'' && !double:
+			single = !single
+		case c == '"' && !single:
+			double = !double
+		case single || double:
+		case c == '#' && (i == 0 || strings.IndexByte(" \t;&|()", line[i-1]) != -1):
+			return line[:i]
+		}
+	}
+	return line
+}
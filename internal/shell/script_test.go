@@ -0,0 +1,204 @@
+package shell
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Runs fn with the shell's stdout and stderr going to a file, returns what was written
+func captureOutput(t *testing.T, fn func()) string {
+	t.Helper()
+	out, err := os.CreateTemp(t.TempDir(), "out")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	stdout, stderr := os.Stdout, os.Stderr
+	os.Stdout, os.Stderr = out, out
+	defer func() { os.Stdout, os.Stderr = stdout, stderr }()
+
+	fn()
+
+	if _, err := out.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// Shell run from a temporary directory, the debug log is written to the current directory
+func scriptShell(t *testing.T) *Shell {
+	t.Helper()
+	t.Setenv("HISTFILE", "")
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(cwd) })
+	return NewShell()
+}
+
+// Lines run as a script behave exactly as when typed one after the other at the prompt
+func TestScriptMatchesInteractive(t *testing.T) {
+	scripts := map[string][]string{
+		"pipelines":          {"echo one two | tr a-z A-Z", "printf 'b\\na\\n' | sort | head -1", "echo x | cat | cat"},
+		"lists":              {"true && echo and", "false && echo skipped", "false || echo or; echo after", "false; echo $?"},
+		"redirects":          {"echo out > f", "echo more >> f", "cat < f", "cat missing 2> err; cat err", "echo both &> g; cat g"},
+		"variables":          {"X=1", "echo $X ${X}y", "Y=2 env | grep '^Y='", "echo ${Y}unset", "export Z=3; env | grep '^Z='"},
+		"quoting":            {"echo 'a  b' \"c  $X\" d\\ e", "echo \"nested 'quotes'\"", "echo a\\|b"},
+		"builtins":           {"cd /", "pwd", "type echo", "alias hi='echo hi'", "hi there", "unalias hi", "hi"},
+		"status":             {"nosuchcommand", "echo $?", "sh -c 'exit 7'", "echo $?", "true | false", "echo $?"},
+		"if":                 {"if true; then echo yes; fi", "if false; then echo no; elif true; then echo elif; fi", "if true; then false; fi || echo or"},
+		"case":               {"case foo in b*) echo b ;; f*|x) echo f ;; esac", "case a/b in \"*\") echo no ;; *) echo any ;; esac && echo and"},
+		"functions":          {"greet() { echo hello $1; }", "greet world | cat", "type greet", "f () { false; }", "f || echo $#"},
+		"local":              {"x=1", "f() { local x=2 y=3; echo $x $y; }", "f; echo $x $y"},
+		"return":             {"f() { echo a; return 3; echo no; }", "f; echo $?", "g() { if false; then :; else return; fi; echo no; }", "g; echo $?", "return 1; echo $?"},
+		"unset":              {"x=1 y=2; export y", "f() { echo f; }", "unset x y f", "echo [$x]; env | grep -c '^y='; f"},
+		"comments":           {"# a comment alone", "echo a # b", "echo 'c # d' e#f \\#g"},
+		"timeout":            {"timeout 5 { echo in | cat; }; echo $?", "timeout 0.1 { sleep 2; echo no; }; echo $?"},
+		"jobs":               {"sh -c 'exit 3' &", "wait %1; echo $?", "sleep 0.1 & wait; echo $?"},
+		"here-string":        {"cat <<< 'here string'", "read line <<< input; echo $line"},
+		"compound pipelines": {"timeout 2 { echo a; echo b; } | cat", "if true; then echo a; fi | tr a b", "{ echo x; false; } | { read l; echo $l; }; echo $?"},
+	}
+
+	for name, lines := range scripts {
+		t.Run(name, func(t *testing.T) {
+			interactive := scriptShell(t)
+			typed := captureOutput(t, func() {
+				for _, line := range lines {
+					interactive.runLine(line)
+				}
+			})
+
+			script := scriptShell(t)
+			var status int
+			run := captureOutput(t, func() {
+				status = script.RunScript(strings.NewReader(strings.Join(lines, "\n") + "\n"))
+			})
+
+			if typed == "" {
+				t.Fatal("typed lines printed nothing")
+			}
+			if run != typed {
+				t.Errorf("script output differs from typed output\nscript:\n%s\ntyped:\n%s", run, typed)
+			}
+			if status != interactive.status {
+				t.Errorf("script status %d, typed status %d", status, interactive.status)
+			}
+		})
+	}
+}
+
+// Scripts read here-document bodies and continued lines from the input instead of the terminal
+func TestScriptReadsFollowingLines(t *testing.T) {
+	s := scriptShell(t)
+	input := "cat <<EOF\nfirst\n  second\nEOF\ncat <<-END | tr a-z A-Z\n\tindented\n\tEND\necho joined \\\nline\n" +
+		"if false\nthen\n  echo no\nelse\n  cat <<EOF\nin else\nEOF\nfi\nfalse\n"
+	var status int
+	out := captureOutput(t, func() {
+		status = s.RunScript(strings.NewReader(input))
+	})
+
+	want := "first\n  second\nINDENTED\njoined line\nin else\n"
+	if out != want {
+		t.Errorf("output %q, want %q", out, want)
+	}
+	if status != 1 {
+		t.Errorf("status %d, want the last command's 1", status)
+	}
+}
+
+// Here-document bodies have their parameters expanded when the command runs, unless the delimiter is quoted
+func TestHeredocExpansion(t *testing.T) {
+	for _, test := range []struct {
+		name, script, want string
+	}{
+		{"unquoted", "X=hi; cat <<EOF\n$X ${X}! $NOPE.\nEOF\n", "hi hi! .\n"},
+		{"escapes", "X=hi; cat <<EOF\n\\$X \\\\ \\\"$ a\\\nb\nEOF\n", "$X \\ \\\"$ ab\n"},
+		{"tabs stripped", "X=hi; cat <<-EOF\n\t$X\n\tEOF\n", "hi\n"},
+		{"empty", "cat <<EOF\nEOF\necho $?\n", "0\n"},
+		{"single quoted", "X=hi; cat <<'EOF'\n$X \\$X\nEOF\n", "$X \\$X\n"},
+		{"double quoted", "X=hi; cat <<\"EOF\"\n$X\nEOF\n", "$X\n"},
+		{"partly quoted", "X=hi; cat <<E'O'F\n$X\nEOF\n", "$X\n"},
+		{"escaped", "X=hi; cat <<\\EOF\n$X\nEOF\n", "$X\n"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			s := scriptShell(t)
+			out := captureOutput(t, func() { s.RunScript(strings.NewReader(test.script)) })
+			if out != test.want {
+				t.Errorf("output %q, want %q", out, test.want)
+			}
+		})
+	}
+}
+
+// ${name[i]} picks an array element, ${name[@]} gives every element as its own word
+func TestArrayExpansion(t *testing.T) {
+	for _, test := range []struct {
+		name, script, want string
+	}{
+		{"elements", "declare -a a=(x y z); i=2; echo ${a[1]} ${a[$i]} ${a[i-2]} ${a[-1]} [${a[3]}]\n", "y z x z []\n"},
+		{"all", "declare -a a=(x 'y z'); printf '[%s]' \"${a[@]}\" \"${a[*]}\"; echo\n", "[x][y z][x y z]\n"},
+		{"read", "read -a r <<< 'p q'; echo ${r[1]} $r ${r[@]}\n", "q p p q\n"},
+		{"scalar", "s=v; echo ${s[0]} ${s[@]} [${s[1]}]\n", "v v []\n"},
+		{"unset", "printf '[%s]' \"${nope[@]}\"; echo\n", "[]\n"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			s := scriptShell(t)
+			out := captureOutput(t, func() { s.RunScript(strings.NewReader(test.script)) })
+			if out != test.want {
+				t.Errorf("output %q, want %q", out, test.want)
+			}
+		})
+	}
+}
+
+// Compound commands run as pipeline stages, with the status of the last stage
+func TestCompoundPipelineOutput(t *testing.T) {
+	for _, test := range []struct {
+		name, script, want string
+	}{
+		{"timeout", "timeout 2 { echo a; echo b; } | cat -n\n", "     1\ta\n     2\tb\n"},
+		{"if", "if true; then echo a; fi | tr a X\n", "X\n"},
+		{"stages", "printf 'x\\ny\\n' | { read l; echo got $l; cat; } | case c in c) tr a-z A-Z;; esac\n", "GOT X\nY\n"},
+		{"status", "{ echo; false; } | { cat; true; }; echo $?; true | if true; then false; fi; echo $?\n", "\n0\n1\n"},
+		{"next line", "{ echo a; } |\ncat\n", "a\n"},
+		{"timed out", "timeout 0.1 { sleep 2; echo no; } | cat; echo $?\n", "0\n"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			s := scriptShell(t)
+			out := captureOutput(t, func() { s.RunScript(strings.NewReader(test.script)) })
+			if out != test.want {
+				t.Errorf("output %q, want %q", out, test.want)
+			}
+		})
+	}
+}
+
+// The transcripts in testdata replay as they were recorded
+func TestTranscripts(t *testing.T) {
+	paths, err := filepath.Glob("testdata/*.expect")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range paths {
+		path, _ := filepath.Abs(path)
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			s := scriptShell(t)
+			status := 0
+			log := captureOutput(t, func() { status = s.runExpect(path) })
+			if status != 0 {
+				t.Errorf("transcript didn't replay, status %d:\n%s", status, log)
+			}
+		})
+	}
+}
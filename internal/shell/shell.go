@@ -1,36 +1,120 @@
 package shell
 
 import (
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
 	"os/exec"
+	"os/signal"
+	"os/user"
 	"path/filepath"
 	"runtime"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unicode"
 
+	"github.com/codecrafters-io/shell-starter-go/internal/color"
 	debuggger "github.com/codecrafters-io/shell-starter-go/internal/debugger"
+	"github.com/codecrafters-io/shell-starter-go/internal/editor"
 	"golang.org/x/term"
 )
 
 // ** Structs **
 // ------------------------------------------------------------------------------------------
 
-type CommandFunc func(args []string, next CommandFunc) error
+// Shell builtin, writes to the streams it is given instead of the shell's own so it can run as a pipeline stage
+type CommandFunc func(args []string, std Streams) error
 
 type Shell struct {
-	debug    debuggger.Debugger
-	stack    []Command
-	commands map[string]CommandFunc
-	aliases  map[string]string
+	debug      debuggger.Debugger
+	stack      []Command
+	commands   map[string]CommandFunc
+	functions  map[string]*function
+	cmdAlias   map[string]string
+	vars       map[string]*Variable
+	options    *Options
+	jobs       *JobTable
+	editor     *editor.Editor
+	tty        *TerminalState
+	complete   *CompletionCache
+	hash       *LookupCache
+	startup    []startupPhase
+	completers map[string]Completer
+	name       string       // $0, argv[0] as the shell was invoked, a leading '-' marks a login shell
+	status     int          // $?, exit status of the last command
+	dirStack   []string     // directories saved by pushd, newest first; the current directory is the implied top entry
+	histKey    []byte       // key of the encrypted history file, looked up in the keyring on first use
+	histKeyErr error        // why the key couldn't be had, it is only looked up once
+	histDB     *sqliteStore // history database with HISTSTORE=sqlite, whose sqlite3 runs for the session
+	exitWarned bool         // the last exit was refused because jobs are stopped, the next line may exit anyway
+	positional []string     // $1, $2 and on, the arguments of the script being run
+	locals     []*VarScope  // variables made local by the functions being run, innermost last
+	returning  bool         // return ran, the rest of the function's body is skipped
+	background int          // $!, process ID of the last command started in the background
+	conditions int          // if conditions being run, errexit leaves their failures alone
+	pipelines  int          // pipelines of compound commands being run, their stages leave the terminal to the shell
+	script     string       // script file named on the command line, run instead of reading commands
+	expect     string       // transcript given with --expect, replayed and checked instead of reading commands
+	command    *string      // command string given with -c, run instead of reading commands
+	scopes     cancelScopes
+	norc       bool                 // --norc: ~/.myshellrc isn't run
+	profile    io.Writer            // where --profile-startup reports the startup phases, nil without it
+	running    *editor.HistoryEntry // history entry of the line being run, finished once it has run
 }
 
 type Command struct {
 	op          string
 	args        []string
-	stdout      string
-	stderr      string
+	assignments []string // NAME=value words before op, set only while the command runs
+	background  bool
+	connector   string // operator joining the command to nextCommand: "&&", "||", "|", "&" or ";"
 	nextCommand *Command
+	globbed     int // arguments that came from glob matches, counted as the command is expanded
+}
+
+// Standard streams of a command, and the cancellation scope of the innermost timeout group running it
+type Streams struct {
+	stdin, stdout, stderr *os.File
+	scope                 *cancelScope // nil outside of timeout groups
+}
+
+// The shell's own standard streams
+func standardStreams() Streams {
+	return Streams{os.Stdin, os.Stdout, os.Stderr, nil}
+}
+
+// Output redirection parsed from an operator token
+type Redirect struct {
+	fd     int
+	append bool
+	force  bool // >| overrides noclobber
+	dup    int  // fd duplicated by >&N, -1 when the target is a file
+	both   bool // &> and &>> redirect stdout and stderr to the same file
+}
+
+// Error carrying only an exit status, for commands that fail without a message
+type ExitStatus int
+
+func (e ExitStatus) Error() string {
+	return fmt.Sprintf("exit status %d", int(e))
+}
+
+// Error of a command that couldn't be run, with the status it stands for: 127 when it wasn't found, 126 when it
+// was found but can't be executed
+type CommandError struct {
+	status int
+	msg    string
+}
+
+func (e *CommandError) Error() string {
+	return e.msg
 }
 
 type TerminalState struct {
@@ -41,89 +125,400 @@ type TerminalState struct {
 // ------------------------------------------------------------------------------------------
 
 // Creates new Shell instance.
-// Shell contains builtin commands, aliases for paths, a command stack and a debugger/logger
+// Shell contains builtin commands, functions, command aliases, shell variables, options, a job table, a line editor, a command stack and a debugger/logger
 func NewShell() *Shell {
 	s := &Shell{
-		debug:    debuggger.Debugger{},
-		stack:    []Command{},
-		commands: make(map[string]CommandFunc),
-		aliases:  map[string]string{"~": os.Getenv("HOME")},
+		debug:     debuggger.Debugger{},
+		stack:     []Command{},
+		commands:  make(map[string]CommandFunc),
+		functions: make(map[string]*function),
+		cmdAlias:  make(map[string]string),
+		vars:      make(map[string]*Variable),
+		options:   NewOptions(),
+		jobs:      NewJobTable(),
+		editor:    editor.NewEditor(),
+		complete:  NewCompletionCache(),
+		hash:      NewLookupCache(),
+	}
+	s.phase("variables", s.initVariables)
+	color.Getenv = func(name string) string {
+		value, _ := s.getVar(name)
+		return value
 	}
-	s.initCommands()
+	s.phase("commands", func() {
+		s.initCommands()
+		s.initCompleters()
+	})
+	s.phase("invocation", s.detectInvocation)
+	s.phase("editor", s.initEditor)
+	s.phase("history", s.loadHistory)
 	// s.debug.Enable()
 	return s
 }
 
 func (s *Shell) Run() {
-	termState, err := s.setupTerminal()
+	// Startup ends here when commands don't come from the terminal, rc files only run interactively
+	if s.command != nil || s.expect != "" || s.script != "" || !term.IsTerminal(int(os.Stdin.Fd())) {
+		s.reportStartup()
+	}
+	if s.command != nil {
+		s.shutdown(s.RunScript(strings.NewReader(*s.command)))
+	}
+	if s.expect != "" {
+		s.shutdown(s.runExpect(s.expect))
+	}
+	if s.script != "" {
+		s.shutdown(s.runFile(s.script))
+	}
+	// Commands piped or redirected into the shell run as a script
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		s.shutdown(s.RunScript(os.Stdin))
+	}
+
+	s.phase("rc", s.loadRC)
+	var termState *TerminalState
+	var err error
+	s.phase("terminal", func() { termState, err = s.setupTerminal() })
 	if err != nil {
 		fmt.Printf("Error setting up terminal: %v\n", err)
 		return
 	}
+	s.tty = termState
 	defer s.restoreTerminal(termState)
+	s.reportStartup()
+
+	// The terminal went away: take the jobs down with the shell
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	go func() {
+		<-hangup
+		s.shutdown(129)
+	}()
+
+	// Ctrl+C while a command runs in the cooked terminal interrupts the command, not the shell: a SIGINT
+	// reaching the shell is passed on to the foreground command. Catching SIGINT instead of ignoring it keeps
+	// the default disposition for the children
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	go func() {
+		for range interrupt {
+			s.jobs.interrupt()
+			s.scopes.cancel(130)
+		}
+	}()
+
+	// Writing to the shell's stdout or stderr after its reader went away fails with EPIPE instead of killing
+	// the shell. Commands it runs still die of SIGPIPE, the disposition of a caught signal isn't inherited
+	brokenPipe := make(chan os.Signal, 1)
+	signal.Notify(brokenPipe, syscall.SIGPIPE)
+	go func() {
+		for range brokenPipe {
+		}
+	}()
 
-	var input strings.Builder
 	for {
-		fmt.Fprint(os.Stdout, "$ ")
+		s.reportJobs()
+		cwd, _ := os.Getwd()
+		s.editor.History().SetScope(cwd, s.historyScope())
+		s.editor.History().SetLimit(s.historySize())
+		s.editor.ViMode = s.options.Get("vi")
+		s.editor.KeyTimeout = s.keyTimeout()
+		line, err := s.editor.ReadLine(s.prompt())
+		if err == editor.ErrInterrupted {
+			continue
+		}
+		// Leaving stopped jobs behind takes a second exit, right after the refused one
+		refused := s.exitWarned
+		if err == io.EOF && !s.mayExit() {
+			continue
+		}
+		if err != nil {
+			if err == io.EOF {
+				fmt.Println("exit")
+			}
+			s.shutdown(s.status)
+		}
+		line, ok := s.acceptLine(line, cwd)
+		if !ok {
+			continue
+		}
+		s.runLine(line)
+		s.finishHistory()
+		if refused {
+			s.exitWarned = false
+		}
+	}
+}
+
+// Expands the history events of a line read at the prompt in cwd and adds it to the history. ok is false when
+// the expansion failed and the line doesn't run
+func (s *Shell) acceptLine(line, cwd string) (accepted string, ok bool) {
+	if s.options.Get("histexpand") {
+		expanded, err := s.expandHistory(line)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\r\n", err)
+			s.status = 1
+			return "", false
+		}
+		// The expanded line is shown before it runs and stored in history in place of the typed one
+		if expanded != line {
+			fmt.Print(expanded + "\r\n")
+			line = expanded
+		}
+	}
+	s.running = nil
+	if strings.TrimSpace(line) != "" && !s.secret(line) {
+		if entry, added := s.editor.History().Add(line, cwd); added {
+			s.saveHistory(entry)
+			s.running = &entry
+		}
+	}
+	return line, true
+}
+
+// Records the status and duration of the line accepted last in its history entry, in memory and in the store
+func (s *Shell) finishHistory() {
+	if s.running == nil {
+		return
+	}
+	entry := *s.running
+	s.running = nil
+	entry.Status, entry.Duration, entry.Finished = s.status, time.Since(entry.Time), true
+	s.editor.History().Finish(entry)
+	if store, err := s.historyStore(); err == nil {
+		store.Finish(entry)
+	}
+}
+
+// Exits the shell: running jobs get SIGHUP and the terminal is restored
+func (s *Shell) shutdown(code int) {
+	s.hangupJobs()
+	s.flushHistory()
+	if s.histDB != nil {
+		s.histDB.Close()
+	}
+	s.restoreTerminal(s.tty)
+	os.Exit(code)
+}
+
+// Parses and executes one line typed at the prompt, reporting errors
+func (s *Shell) runLine(line string) {
+	s.execute(line, s.editor.ReadLine)
+}
+
+// Parses and executes one line of input, reporting errors. Here-document bodies are read from next
+func (s *Shell) execute(line string, next lineSource) {
+	command := strings.TrimSpace(stripComment(line))
+	if command == "" {
+		return
+	}
+	compound, err := s.readCompound(command, next)
+	if err == editor.ErrInterrupted {
+		return
+	}
+	if err != nil {
+		s.report(err)
+		s.status = 2
+		return
+	}
+	if compound == nil {
+		s.parseCommand(command)
+		if err := s.readHeredocs(next); err != nil {
+			s.stack = []Command{}
+			return
+		}
+	}
+	if compound != nil || len(s.stack) > 0 {
+		s.cookTerminal()
+		s.promptMark("C")
+		var err error
+		if compound != nil {
+			err = s.runBlock(compound, standardStreams())
+		} else {
+			err = s.executeCommand(s.stack[0], standardStreams())
+		}
+		if sig, ok := signaled(err); ok && sig == syscall.SIGINT {
+			// The terminal echoed ^C, finish its line so the prompt starts on a fresh one
+			fmt.Println()
+			s.editor.Discard()
+		}
+		s.report(err)
+		s.promptMark(fmt.Sprintf("D;%d", exitCode(err)))
+		s.rawTerminal()
+		s.stack = []Command{}
+	}
+}
 
-		var buf [1]byte
-		for {
-			n, err := os.Stdin.Read(buf[:])
-			if err != nil || n == 0 {
+// Reads the bodies of the here-documents opened on the parsed line from the lines that follow it, in order,
+// prompting with PS2. Each delimiter word is replaced by its body; <<- strips leading tabs from the body lines
+// and the delimiter. Parameters in the body are expanded when the command runs unless the delimiter was quoted.
+// Ctrl+C abandons the whole command
+func (s *Shell) readHeredocs(next lineSource) error {
+	for c := range s.stack {
+		args := s.stack[c].args
+		for i := 0; i < len(args)-1; i++ {
+			if args[i] != "<<" && args[i] != "<<-" {
 				continue
 			}
-
-			switch buf[0] {
-			case 9: // Tab
-				completed := s.TabComplete(input.String())
-				if completed != input.String() {
-					fmt.Print("\r\033[K$ " + completed + " ")
-					input.Reset()
-					input.WriteString(completed)
+			delimiter, literal := strings.CutPrefix(args[i+1], quotedDelimiter)
+			var body strings.Builder
+			for {
+				line, err := next(s.secondaryPrompt())
+				if err == editor.ErrInterrupted {
+					return err
 				}
-
-			case 13: // Enter
-				fmt.Println()
-				command := strings.TrimSpace(input.String())
-				if command != "" {
-					s.parseCommand(command)
-					if len(s.stack) > 0 {
-						s.executeCommand(s.stack[0])
-						s.stack = []Command{}
-					}
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "warning: here-document delimited by end-of-file (wanted '%s')\n", delimiter)
+					break
 				}
-				input.Reset()
-				break
-
-			case 127, 8: // Backspace (Unix) or Backspace (Windows)
-				if input.Len() > 0 {
-					str := input.String()
-					input.Reset()
-					input.WriteString(str[:len(str)-1])
-					fmt.Print("\b \b")
+				if args[i] == "<<-" {
+					line = strings.TrimLeft(line, "\t")
 				}
+				if line == delimiter {
+					break
+				}
+				body.WriteString(line + "\n")
+			}
+			args[i+1] = body.String()
+			if !literal {
+				args[i+1] = markHeredoc(args[i+1])
+			}
+			i++
+		}
+	}
+	return nil
+}
 
-			case 3: // Ctrl+C
-				fmt.Println("\n^C")
-				input.Reset()
-				break
+// Marks the parameter references of a here-document body to be expanded as in double quotes, without splitting.
+// A backslash escapes $, ` and \ and removes a newline after it
+func markHeredoc(body string) string {
+	var marked strings.Builder
+	for i := 0; i < len(body); i++ {
+		switch {
+		case body[i] == '\\' && i+1 < len(body) && strings.IndexByte("\\$`\n", body[i+1]) != -1:
+			i++
+			if body[i] != '\n' {
+				marked.WriteByte(body[i])
+			}
+		case body[i] == '$':
+			name, end, ok := parameterName(body, i)
+			if !ok {
+				marked.WriteByte('$')
+				continue
+			}
+			marked.WriteString(quotedParameter + name + quotedParameter)
+			i = end - 1
+		default:
+			marked.WriteByte(body[i])
+		}
+	}
+	return marked.String()
+}
 
-			case 4: // Ctrl+D
-				if input.Len() == 0 {
-					fmt.Println("exit")
-					os.Exit(0)
-				}
+// Entries kept in history, HISTSIZE when it is a number (negative for no limit)
+func (s *Shell) historySize() int {
+	if value, exists := s.getVar("HISTSIZE"); exists {
+		if size, err := strconv.Atoi(strings.TrimSpace(value)); err == nil {
+			return size
+		}
+	}
+	return editor.DefaultHistorySize
+}
 
-			default:
-				if buf[0] >= 32 { // Only print printable characters
-					input.WriteByte(buf[0])
-					fmt.Print(string(buf[0]))
-				}
+// How long the editor waits for the rest of a key sequence after an Esc: KEYTIMEOUT in hundredths of a second
+// as in zsh, raised on high-latency links where arrow keys arrive split. 0 waits as long as it takes
+func (s *Shell) keyTimeout() time.Duration {
+	if value, exists := s.getVar("KEYTIMEOUT"); exists {
+		if n, err := strconv.Atoi(strings.TrimSpace(value)); err == nil && n >= 0 {
+			return time.Duration(n) * 10 * time.Millisecond
+		}
+	}
+	return editor.DefaultKeyTimeout
+}
+
+// How history recall treats commands run in other directories, set by the dirhistory options
+func (s *Shell) historyScope() editor.HistoryScope {
+	switch {
+	case s.options.Get("dirhistory_strict"):
+		return editor.ScopeRestrict
+	case s.options.Get("dirhistory"):
+		return editor.ScopePrefer
+	}
+	return editor.ScopeAll
+}
+
+// Line editor hooks: Tab completion and shell commands bound to keys
+func (s *Shell) initEditor() {
+	s.editor.Complete = func(line string) string {
+		completed := s.TabComplete(line)
+		// A completed parameter may be followed by more of the word, no space is added after it
+		if _, _, parameter := partialParameter(completed); parameter || strings.HasSuffix(completed, "}") {
+			return completed
+		}
+		if completed != line && !strings.HasSuffix(completed, string(os.PathSeparator)) {
+			completed += " "
+		}
+		return completed
+	}
+	s.editor.Hint = s.aliasHint
+	s.editor.Execute = s.runLine
+	s.editor.Quote = escapeWord
+	s.editor.Cook = s.cookTerminal
+	s.editor.Raw = s.rawTerminal
+	// PATH is searched for a finder the first time one of its keys is used, not at startup
+	s.editor.EnableFinder(func() string {
+		for _, finder := range []string{"fzf", "sk"} {
+			if path, exists := s.find(finder); exists {
+				return path
 			}
+		}
+		return ""
+	})
+}
 
-			if buf[0] == 13 { // Enter was pressed
-				break
+// Records whether the shell is interactive (attached to a terminal) and whether it is a login shell
+// (argv[0] starting with '-', or started with -l/--login), so rc files can branch on $- and shopt
+func (s *Shell) detectInvocation() {
+	interactive := term.IsTerminal(int(os.Stdin.Fd())) && term.IsTerminal(int(os.Stdout.Fd()))
+	s.name = os.Args[0]
+	login := strings.HasPrefix(os.Args[0], "-")
+	// Options come first, the first other argument names a script file that gets the rest as $1, $2 and on.
+	// -c takes a command string instead, the arguments after it are $0, $1 and on
+arguments:
+	for i := 1; i < len(os.Args); i++ {
+		arg := os.Args[i]
+		if !strings.HasPrefix(arg, "-") {
+			s.script, s.name, s.positional = arg, arg, os.Args[i+1:]
+			interactive = false
+			break
+		}
+		switch {
+		case arg == "-l" || arg == "--login":
+			login = true
+		case arg == "--norc":
+			s.norc = true
+		case arg == "-c":
+			if i+1 >= len(os.Args) {
+				fmt.Fprintln(os.Stderr, "-c: option requires an argument")
+				os.Exit(2)
+			}
+			s.command = &os.Args[i+1]
+			if i+2 < len(os.Args) {
+				s.name, s.positional = os.Args[i+2], os.Args[i+3:]
 			}
+			interactive = false
+			break arguments
+		case arg == "--expect" && i+1 < len(os.Args):
+			i++
+			s.expect = os.Args[i]
+			interactive = false
+		}
+	}
+	s.options.values["interactive"] = interactive
+	s.options.values["login_shell"] = login
+	if interactive {
+		for name, value := range defaultAliases {
+			s.cmdAlias[name] = value
 		}
 	}
 }
@@ -137,10 +532,38 @@ func (s *Shell) initCommands() {
 	s.commands["cd"] = s.cd
 	s.commands["cls"] = s.clear
 	s.commands["clear"] = s.clear
+	s.commands["jobs"] = s.jobsBuiltin
+	s.commands["fg"] = s.fg
+	s.commands["bg"] = s.bg
+	s.commands["wait"] = s.wait
+	s.commands["disown"] = s.disown
+	s.commands["kill"] = s.kill
+	s.commands["capture"] = s.capture
+	s.commands["exec"] = s.exec
+	s.commands["declare"] = s.declare
+	s.commands["typeset"] = s.declare
+	s.commands["export"] = s.export
+	s.commands["local"] = s.local
+	s.commands["return"] = s._return
+	s.commands["unset"] = s.unset
+	s.commands["shopt"] = s.shopt
+	s.commands["set"] = s.set
+	s.commands["setopt"] = s.setopt
+	s.commands["unsetopt"] = s.unsetopt
+	s.commands["bind"] = s.bind
+	s.commands["help"] = s.help
+	s.commands["alias"] = s.alias
+	s.commands["unalias"] = s.unalias
+	s.commands["history"] = s.history
+	s.commands["dirs"] = s.dirs
+	s.commands["pushd"] = s.pushd
+	s.commands["popd"] = s.popd
+	s.commands["printf"] = s.printf
+	s.commands["read"] = s.read
 }
 
 // Shell command parser, parses command into op (operation) and args (arguments for the operation).
-// Supports > and &&
+// Supports >, && and & (run in background)
 func (s *Shell) parseCommand(input string) {
 	var current Command
 	var current_token strings.Builder
@@ -148,11 +571,33 @@ func (s *Shell) parseCommand(input string) {
 	isFirst := true
 
 	s.stack = []Command{}
+	// The parser marks expansions in words with control characters, any typed or pasted are dropped
+	input = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(unquotedParameter+quotedParameter+globMark+tildeMark+quotedDelimiter, r) {
+			return -1
+		}
+		return r
+	}, input)
+
+	// Alias expansion: where the current word starts and whether any of it is quoted, where the expansion of each
+	// alias ends (an alias isn't expanded again inside its own expansion) and where the expansion of an alias whose
+	// value ends with a space ends, the word following it is alias-expanded too
+	tokenStart, quoted := -1, false
+	expanding := make(map[string]int)
+	chainEnd := -1
 
 	flushToken := func() {
+		start, wasQuoted := tokenStart, quoted
+		tokenStart, quoted = -1, false
 		if current_token.Len() > 0 {
 			token := current_token.String()
-			if isFirst {
+			if n := len(current.args); wasQuoted && n > 0 && (current.args[n-1] == "<<" || current.args[n-1] == "<<-") {
+				token = quotedDelimiter + token
+			}
+			// A leading NAME=value word whose name isn't quoted is an assignment, not the command
+			if name, _, ok := strings.Cut(token, "="); isFirst && ok && start >= 0 && isValidName(name) && strings.HasPrefix(input[start:], name+"=") {
+				current.assignments = append(current.assignments, token)
+			} else if isFirst {
 				current.op = token
 				isFirst = false
 			} else {
@@ -162,309 +607,1440 @@ func (s *Shell) parseCommand(input string) {
 		}
 	}
 
-	pushCommand := func() {
+	pushCommand := func(connector string) {
 		flushToken()
-		if current.op != "" {
+		if current.op != "" || len(current.assignments) > 0 {
+			current.connector = connector
 			s.stack = append(s.stack, current)
-			current = Command{}
-			isFirst = true
 		}
+		current = Command{}
+		isFirst = true
 	}
 
-	for i := 0; i < len(input); i++ {
+	// Replaces the word ending at end with its alias, the caller rescans from the start of the expansion
+	expandAlias := func(end int) bool {
+		if quoted || tokenStart == -1 {
+			return false
+		}
+		if !isFirst {
+			if chainEnd == -1 || tokenStart < chainEnd {
+				return false
+			}
+			chainEnd = -1
+		}
+		word := input[tokenStart:end]
+		value, exists := s.cmdAlias[word]
+		if !exists || expanding[word] > tokenStart {
+			return false
+		}
+
+		input = input[:tokenStart] + value + input[end:]
+		for name, aliasEnd := range expanding {
+			if aliasEnd > tokenStart {
+				expanding[name] = aliasEnd + len(value) - len(word)
+			}
+		}
+		expanding[word] = tokenStart + len(value)
+		if strings.HasSuffix(value, " ") {
+			chainEnd = tokenStart + len(value)
+		}
+		current_token.Reset()
+		return true
+	}
+
+	for i := 0; i <= len(input); i++ {
+		if i == len(input) || !singleQuote && !doubleQuote && !backslash && strings.ContainsRune(" &|;<>", rune(input[i])) {
+			if start := tokenStart; expandAlias(i) {
+				i = start - 1
+				tokenStart = -1
+				continue
+			}
+			if i == len(input) {
+				break
+			}
+		}
 		c := rune(input[i])
 
 		switch {
 		case backslash:
-			current_token.WriteRune(c)
+			// Inside double quotes a backslash only escapes \ " $ and `
+			if doubleQuote && !strings.ContainsRune(doubleQuotedSpecials, c) {
+				current_token.WriteByte('\\')
+			}
+			current_token.WriteByte(input[i])
 			backslash = false
 			continue
-		case c == '\\':
+		case c == '\\' && !singleQuote:
 			backslash = true
+			quoted = true
 			continue
 		case c == '\'':
 			if !doubleQuote {
 				singleQuote = !singleQuote
+				quoted = true
 				continue
 			}
 		case c == '"':
 			if !singleQuote {
 				doubleQuote = !doubleQuote
+				quoted = true
 				continue
 			}
 		}
 
 		if !singleQuote && !doubleQuote {
 			if c == '>' {
+				op := ">"
+				if token := current_token.String(); token != "" && strings.Trim(token, "0123456789") == "" {
+					op = token + op
+					current_token.Reset()
+				}
+				flushToken()
+				if i < len(input)-1 && (input[i+1] == '>' || input[i+1] == '|') {
+					op += string(input[i+1])
+					i++
+				}
+				if i < len(input)-1 && input[i+1] == '&' {
+					j := i + 2
+					for j < len(input) && (input[j] >= '0' && input[j] <= '9' || input[j] == '-') {
+						j++
+					}
+					if j > i+2 {
+						op += input[i+1 : j]
+						i = j - 1
+					}
+				}
+				current.args = append(current.args, op)
+				continue
+			}
+			if c == '<' {
+				flushToken()
+				op := "<"
+				if strings.HasPrefix(input[i+1:], "<<") {
+					op = "<<<"
+				} else if strings.HasPrefix(input[i+1:], "<-") {
+					op = "<<-"
+				} else if strings.HasPrefix(input[i+1:], "<") {
+					op = "<<"
+				}
+				i += len(op) - 1
+				current.args = append(current.args, op)
+				continue
+			}
+			if c == '&' && i < len(input)-1 && input[i+1] == '>' {
 				flushToken()
-				current.args = append(current.args, ">")
+				op := "&>"
+				i++
+				if i < len(input)-1 && input[i+1] == '>' {
+					op += ">"
+					i++
+				}
+				current.args = append(current.args, op)
 				continue
 			}
-			if i < len(input)-1 && c == '&' && input[i+1] == '&' {
-				pushCommand()
+			if i < len(input)-1 && (c == '&' || c == '|') && input[i+1] == input[i] {
+				pushCommand(input[i : i+2])
 				i++
 				continue
 			}
+			if c == '&' {
+				current.background = true
+				pushCommand("&")
+				continue
+			}
+			if c == '|' || c == ';' {
+				pushCommand(string(c))
+				continue
+			}
+		}
+
+		// Parameters are expanded when the command runs, the word keeps a reference to them until then
+		if c == '$' && !singleQuote {
+			if name, end, ok := parameterName(input, i); ok {
+				if tokenStart == -1 {
+					tokenStart = i
+				}
+				mark := unquotedParameter
+				if doubleQuote {
+					mark = quotedParameter
+				}
+				current_token.WriteString(mark + name + mark)
+				i = end - 1
+				continue
+			}
+		}
+
+		// A tilde prefix is kept marked until the command runs, ~- and the directory stack may change before then
+		if c == '~' && !singleQuote && !doubleQuote {
+			token := current_token.String()
+			name, value, assignment := strings.Cut(token, "=")
+			assignment = assignment && isFirst && isValidName(name) && (value == "" || strings.HasSuffix(value, ":"))
+			if end, ok := tildePrefix(input, i, assignment); ok && (token == "" && !quoted || assignment) {
+				if tokenStart == -1 {
+					tokenStart = i
+				}
+				current_token.WriteString(tildeMark + input[i:end] + tildeMark)
+				i = end - 1
+				continue
+			}
 		}
 
 		if c == ' ' && !singleQuote && !doubleQuote {
 			flushToken()
 		} else {
-			current_token.WriteRune(c)
+			if tokenStart == -1 {
+				tokenStart = i
+			}
+			// Unquoted glob characters are marked, the word is matched against files when the command runs
+			if !singleQuote && !doubleQuote && strings.ContainsRune("*?[", c) {
+				current_token.WriteString(globMark)
+			}
+			current_token.WriteByte(input[i])
 		}
 	}
 
-	pushCommand()
+	pushCommand("")
 
 	for i := 0; i < len(s.stack)-1; i++ {
 		s.stack[i].nextCommand = &s.stack[i+1]
 	}
 }
 
-// Shell generic command execution, contains logic to whether execute builtin or external commands, prints out error if not found
-func (s *Shell) executeCommand(cmd Command) error {
-	var nextFunc CommandFunc
-	if cmd.nextCommand != nil {
-		nextFunc = func(args []string, _ CommandFunc) error {
-			return s.executeCommand(*cmd.nextCommand)
+// Name of the $NAME or ${NAME} parameter starting at input[i] and where the reference ends, ok is false
+// when the '$' doesn't start one
+func parameterName(input string, i int) (name string, end int, ok bool) {
+	end = i + 1
+	switch {
+	case end < len(input) && input[end] == '{':
+		closing := strings.IndexByte(input[end:], '}')
+		if closing == -1 {
+			return "", 0, false
 		}
+		name, end = input[end+1:end+closing], end+closing+1
+		if !isValidName(name) && !isPositional(name) && !isElement(name) && (len(name) != 1 || !strings.Contains(specialParameters, name)) {
+			return "", 0, false
+		}
+	case end < len(input) && strings.IndexByte(specialParameters, input[end]) != -1:
+		name, end = input[end:end+1], end+1
+	default:
+		for end < len(input) && isValidName(input[i+1:end+1]) {
+			end++
+		}
+		if end == i+1 {
+			return "", 0, false
+		}
+		name = input[i+1 : end]
 	}
-
-	s.debug.Log(cmd.op, cmd.args)
-	if shellCmd, exists := s.commands[cmd.op]; exists {
-		return shellCmd(cmd.args, nextFunc)
-	} else if _, exists := find(cmd.op); exists {
-		return s.executeExternal(cmd, nextFunc)
-	} else {
-		fmt.Printf("%s: command not found\n", cmd.op)
-		return fmt.Errorf("%s: command not found\n", cmd.op)
-	}
+	return name, end, true
 }
 
-// Shell external command execution, work in-progress
-// TODO: Needs to pipe to  file and not write out to the console if there is '>', '1>', '2>'
-func (s *Shell) executeExternal(cmd Command, next CommandFunc) error {
-	ext := exec.Command(cmd.op, cmd.args...)
-	writer, err := s.pipe(&cmd.args)
-	if err != nil {
-		return err
-	}
-	if writer != os.Stdout {
-		defer writer.Close()
+// End of the tilde prefix starting at input[i]: the characters up to a slash or the end of the word, or a colon
+// in an assignment. ok is false when any of them is quoted or special, the tilde is then taken literally
+func tildePrefix(input string, i int, assignment bool) (end int, ok bool) {
+	for end = i + 1; end < len(input); end++ {
+		c := input[end]
+		if strings.IndexByte(" \t&|;<>/", c) != -1 || assignment && c == ':' {
+			break
+		}
+		if strings.IndexByte("'\"\\$`*?[", c) != -1 {
+			return 0, false
+		}
 	}
+	return end, true
+}
 
-	ext.Stdout = writer
+// Parameter references in parsed words, the name between two marks. The marks are control characters that
+// have no business in a command line, the parser drops them from its input
+const (
+	unquotedParameter = "\x01"
+	quotedParameter   = "\x02"
+)
 
-	err = ext.Run()
-	if err != nil {
-		return fmt.Errorf("%s: %v", cmd.op, err)
-	}
+// Precedes an unquoted glob character in parsed words
+const globMark = "\x03"
 
-	if next != nil {
-		return next(nil, nil)
-	}
-	return nil
-}
+// Tilde prefixes in parsed words, between two marks
+const tildeMark = "\x04"
 
-// ** Term **
-// ------------------------------------------------------------------------------------------
+// Precedes a here-document delimiter that was quoted, the body is then taken literally
+const quotedDelimiter = "\x05"
 
-func (s *Shell) setupTerminal() (*TerminalState, error) {
-	if runtime.GOOS == "windows" {
-		oldState, err := term.MakeRaw(int(os.Stdin.Fd()))
-		if err != nil {
-			return nil, err
+// Copy of a parsed command with the tilde prefixes and parameters in its words expanded, parameters from
+// the shell variables, which include the environment, and the words with unquoted glob characters replaced by the files they match. A word made
+// only of unquoted parameters that expand to nothing is dropped, as in bash
+func (s *Shell) expandCommand(cmd Command) Command {
+	expanded := cmd
+	expanded.assignments = make([]string, len(cmd.assignments))
+	for i, assignment := range cmd.assignments {
+		expanded.assignments[i], _ = s.expandWord(s.expandTilde(assignment))
+		expanded.assignments[i] = strings.ReplaceAll(expanded.assignments[i], globMark, "")
+	}
+	expanded.args = []string{}
+	target, heredoc := false, false
+	for _, word := range append([]string{cmd.op}, cmd.args...) {
+		if elements, ok := s.expandElements(word); ok {
+			expanded.args = append(expanded.args, elements...)
+			target = false
+			continue
 		}
-		return &TerminalState{oldState: oldState}, nil
+		word, keep := s.expandWord(s.expandTilde(word))
+		switch {
+		// An empty here-document body is still one
+		case !keep && !heredoc:
+		case strings.Contains(word, globMark) && !target:
+			matches := s.glob(word)
+			if len(matches) != 1 || matches[0] != strings.ReplaceAll(word, globMark, "") {
+				expanded.globbed += len(matches)
+			}
+			expanded.args = append(expanded.args, matches...)
+		default:
+			expanded.args = append(expanded.args, strings.ReplaceAll(word, globMark, ""))
+		}
+		// Redirection targets aren't globbed
+		redirect, ok := parseRedirect(word)
+		target = ok && redirect.dup == -1 || slices.Contains([]string{"<", "<<", "<<-", "<<<"}, word)
+		heredoc = word == "<<" || word == "<<-"
 	}
-
-	oldState, err := term.MakeRaw(int(os.Stdin.Fd()))
-	if err != nil {
-		return nil, err
+	expanded.op = ""
+	if len(expanded.args) > 0 {
+		expanded.op, expanded.args = expanded.args[0], expanded.args[1:]
 	}
-	return &TerminalState{oldState: oldState}, nil
+	return expanded
 }
 
-func (s *Shell) restoreTerminal(ts *TerminalState) {
-	if ts != nil && ts.oldState != nil {
-		term.Restore(int(os.Stdin.Fd()), ts.oldState)
+// Arguments of a word made of a single $@ or ${name[@]} reference, quoted or not, or an unquoted $* or
+// ${name[*]}: one for each positional parameter or array element. "$*" and "${name[*]}" join them instead,
+// ok is false for them and other words
+func (s *Shell) expandElements(word string) (elements []string, ok bool) {
+	if len(word) < 3 || word[0] != word[len(word)-1] || !strings.Contains(unquotedParameter+quotedParameter, word[:1]) {
+		return nil, false
 	}
+	name, quoted := word[1:len(word)-1], word[:1] == quotedParameter
+	if strings.ContainsAny(name, unquotedParameter+quotedParameter) {
+		return nil, false
+	}
+	switch {
+	case name == "@" || name == "*" && !quoted:
+		return s.positional, true
+	case isElement(name) && (strings.HasSuffix(name, "[@]") || strings.HasSuffix(name, "[*]") && !quoted):
+		base, _, _ := strings.Cut(name, "[")
+		return s.arrayElements(base), true
+	}
+	return nil, false
 }
 
-func (s *Shell) TabComplete(input string) string {
-	if input == "" {
-		return input
+// Files matching a word holding marked glob characters, sorted. Glob characters that were quoted match
+// themselves. Without a match the word is kept as it is, or dropped with nullglob
+func (s *Shell) glob(word string) []string {
+	literal := strings.ReplaceAll(word, globMark, "")
+	matches, _ := filepath.Glob(globPattern(word, runtime.GOOS != "windows"))
+	files := []string{}
+	for _, match := range matches {
+		if !s.hidden(filepath.Base(match), filepath.Base(literal)) {
+			files = append(files, match)
+		}
 	}
-
-	words := strings.Fields(input)
-	if len(words) == 0 {
-		return input
+	if len(files) == 0 && !s.options.Get("nullglob") {
+		return []string{literal}
 	}
+	s.sortMatches(files)
+	return files
+}
 
-	if len(words) == 1 && !strings.Contains(input, " ") {
-		return s.completeCommand(words[0])
+// Match pattern of a word holding marked glob characters, with the unmarked ones escaped when escape is set
+// (filepath.Match on Windows has no escapes)
+func globPattern(word string, escape bool) string {
+	var pattern strings.Builder
+	for i := 0; i < len(word); i++ {
+		switch {
+		case word[i] == globMark[0] && i+1 < len(word):
+			i++
+			pattern.WriteByte(word[i])
+		case escape && strings.IndexByte("*?[\\", word[i]) != -1:
+			pattern.WriteByte('\\')
+			pattern.WriteByte(word[i])
+		default:
+			pattern.WriteByte(word[i])
+		}
 	}
-
-	return s.completePath(input)
+	return pattern.String()
 }
 
-func (s *Shell) completeCommand(partial string) string {
-	matches := []string{}
+// Sorts glob matches the same way everywhere: in byte order, or with glob_collate in the collation order of
+// the LC_ALL, LC_COLLATE or LANG locale when it isn't C or POSIX
+func (s *Shell) sortMatches(files []string) {
+	if !s.options.Get("glob_collate") || s.collationLocale() == "C" {
+		sort.Strings(files)
+		return
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		return collate(files[i], files[j]) < 0
+	})
+}
 
-	// Check built-in commands
-	for cmd := range s.commands {
-		if strings.HasPrefix(cmd, partial) {
-			matches = append(matches, cmd)
+// Locale deciding the collation order, C when none is set
+func (s *Shell) collationLocale() string {
+	for _, name := range []string{"LC_ALL", "LC_COLLATE", "LANG"} {
+		if value, _ := s.getVar(name); value != "" {
+			if value == "POSIX" || value == "C" || strings.HasPrefix(value, "C.") {
+				return "C"
+			}
+			return value
 		}
 	}
+	return "C"
+}
 
-	// Check executables in PATH
-	if path, exists := find(partial); exists {
-		matches = append(matches, filepath.Base(path))
+// Compares strings the way most locales collate them: by their letters and digits ignoring case, then
+// with punctuation, then lowercase before uppercase
+func collate(a, b string) int {
+	key := func(s string) string {
+		return strings.Map(func(r rune) rune {
+			if unicode.IsLetter(r) || unicode.IsDigit(r) {
+				return unicode.ToLower(r)
+			}
+			return -1
+		}, s)
 	}
-
-	if len(matches) == 0 {
-		return partial
+	if c := strings.Compare(key(a), key(b)); c != 0 {
+		return c
 	}
-
-	if len(matches) == 1 {
-		return matches[0]
+	if c := strings.Compare(strings.ToLower(a), strings.ToLower(b)); c != 0 {
+		return c
 	}
-
-	return s.findCommonPrefix(matches)
+	return strings.Compare(b, a)
 }
 
-// ** Builtins **
-// ------------------------------------------------------------------------------------------
-
-// Shell builtin exit
-func (s *Shell) exit(args []string, next CommandFunc) error {
-	if len(args) > 1 {
-		return fmt.Errorf("Error: Expected [0:1] argument, received %d", len(args))
-	} else if len(args) == 0 {
-		os.Exit(0)
-	} else {
-		code, err := strconv.Atoi(args[0])
-		if err != nil {
-			return err
+// Expands the marked tilde prefixes of a word
+func (s *Shell) expandTilde(word string) string {
+	for {
+		i := strings.Index(word, tildeMark)
+		if i == -1 {
+			return word
 		}
-		os.Exit(code)
+		end := strings.Index(word[i+1:], tildeMark) + i + 1
+		word = word[:i] + s.tilde(word[i+1:end]) + word[end+1:]
 	}
-	return nil
 }
 
-// Shell builtin pipe, used for external and echo
-func (s *Shell) pipe(args *[]string) (*os.File, error) {
-	var writer *os.File = os.Stdout
+// Directory a tilde prefix stands for: ~ is HOME, ~user the home directory of user, ~+ the current directory
+// and ~- the previous one (OLDPWD), ~N ~+N ~-N directory stack entries. A prefix naming nothing stays as it is
+func (s *Shell) tilde(prefix string) string {
+	switch name := prefix[1:]; name {
+	case "":
+		if home, exists := s.getVar("HOME"); exists {
+			return home
+		}
+		if u, err := user.Current(); err == nil {
+			return u.HomeDir
+		}
+	case "+":
+		if pwd, err := os.Getwd(); err == nil {
+			return pwd
+		}
+	case "-":
+		if oldpwd, exists := s.getVar("OLDPWD"); exists {
+			return oldpwd
+		}
+	default:
+		if dir, ok := s.expandDirStack(prefix); ok {
+			return dir
+		}
+		if u, err := user.Lookup(name); err == nil {
+			return u.HomeDir
+		}
+	}
+	return prefix
+}
 
-	for i := 0; i < len(*args); i++ {
-		if i >= len(*args)-1 {
+// Expands the parameter references of a word, keep is false when the word vanishes
+func (s *Shell) expandWord(word string) (expanded string, keep bool) {
+	if !strings.ContainsAny(word, unquotedParameter+quotedParameter) {
+		return word, word != ""
+	}
+	var out strings.Builder
+	for word != "" {
+		i := strings.IndexAny(word, unquotedParameter+quotedParameter)
+		if i == -1 {
+			out.WriteString(word)
+			keep = true
 			break
 		}
+		out.WriteString(word[:i])
+		keep = keep || i > 0 || word[i:i+1] == quotedParameter
+		end := strings.IndexByte(word[i+1:], word[i]) + i + 1
+		value, _ := s.getVar(word[i+1 : end])
+		out.WriteString(value)
+		word = word[end+1:]
+	}
+	return out.String(), keep || out.Len() > 0
+}
 
-		if strings.HasPrefix((*args)[i], ">") || strings.HasPrefix((*args)[i], "1>") {
-			fi := strings.TrimSpace((*args)[i+1])
-			dir := filepath.Dir(fi)
-			if err := os.MkdirAll(dir, 0755); err != nil {
-				return nil, fmt.Errorf("Error creating directory: %v", err)
-			}
-			file, err := os.Create(fi)
-			if err != nil {
-				return nil, fmt.Errorf("Error creating output file: %v", err)
-			}
-			writer = file
-
-			*args = append((*args)[:i], (*args)[i+2:]...)
+// Shell command list execution: after each command (or pipeline) the connector decides whether the next one runs,
+// && when it succeeded, || when it failed, ; and & always. A skipped command keeps the status for the one after it
+func (s *Shell) executeCommand(cmd Command, std Streams) error {
+	last, err := s.executePipeline(&cmd, std)
+	s.status = exitCode(err)
+	s.exitOnError(last, err)
+	for last.nextCommand != nil {
+		// An interrupted command abandons the rest of the list, as does return
+		if sig, ok := signaled(err); ok && sig == syscall.SIGINT || s.returning {
 			break
 		}
+		next := last.nextCommand
+		if last.connector == "&&" && err != nil || last.connector == "||" && err == nil {
+			last = next
+			for last.connector == "|" && last.nextCommand != nil {
+				last = last.nextCommand
+			}
+			continue
+		}
+		s.report(err)
+		last, err = s.executePipeline(next, std)
+		s.status = exitCode(err)
+		s.exitOnError(last, err)
 	}
+	return err
+}
 
-	return writer, nil
+// With errexit, a failed pipeline exits the shell unless its status is tested by && or ||
+func (s *Shell) exitOnError(last *Command, err error) {
+	if err == nil || !s.options.Get("errexit") || last.connector == "&&" || last.connector == "||" || s.conditions > 0 {
+		return
+	}
+	s.report(err)
+	s.shutdown(s.status)
 }
 
-// Shell builtin echo
-func (s *Shell) echo(args []string, next CommandFunc) error {
-	var output strings.Builder
+// With xtrace, prints a command and its arguments on stderr after PS4 before it runs
+func (s *Shell) trace(cmd Command) {
+	if !s.options.Get("xtrace") {
+		return
+	}
+	ps4, exists := s.getVar("PS4")
+	if !exists {
+		ps4 = "+ "
+	}
+	// Redirections aren't part of the traced command
+	words := append([]string{}, cmd.assignments...)
+	if cmd.op != "" {
+		words = append(words, cmd.op)
+	}
+	for i := 0; i < len(cmd.args); i++ {
+		if redirect, ok := parseRedirect(cmd.args[i]); ok {
+			if redirect.dup == -1 {
+				i++
+			}
+			continue
+		}
+		if slices.Contains([]string{"<", "<<", "<<-", "<<<"}, cmd.args[i]) {
+			i++
+			continue
+		}
+		words = append(words, escapeWord(cmd.args[i]))
+	}
+	fmt.Fprintln(os.Stderr, ps4+strings.Join(words, " "))
+}
 
-	writer, err := s.pipe(&args)
+// Shell generic command execution, contains logic to whether execute builtin or external commands, prints out error if not found
+func (s *Shell) executeSimple(cmd Command, std Streams) error {
+	cmd = s.expandCommand(cmd)
+	s.debug.Log(cmd.op, cmd.args)
+	s.trace(cmd)
+	if cmd.op == "" {
+		return s.assign(cmd.assignments)
+	}
+	if err := s.preExec(cmd); err != nil {
+		return err
+	}
+	if len(cmd.assignments) > 0 {
+		scope, err := s.assignScoped(cmd.assignments)
+		defer scope.restore()
+		if err != nil {
+			return err
+		}
+	}
+	if fi, err := os.Stat(cmd.op); err == nil && fi.IsDir() && s.options.Get("autocd") && len(cmd.args) == 0 {
+		return s.cd([]string{cmd.op}, std)
+	}
+	if shellCmd, exists := s.internal(cmd.op); exists {
+		// exec applies its redirections to the shell itself
+		if cmd.op == "exec" && !s.isFunction(cmd.op) {
+			return shellCmd(cmd.args, standardStreams())
+		}
+		return s.redirected(cmd, std, shellCmd)
+	} else if _, exists := s.findCommand(cmd.op); exists {
+		if cmd.background {
+			return s.startJob(cmd, std)
+		}
+		return s.executeExternal(cmd, std)
+	} else {
+		return s.redirected(cmd, std, func([]string, Streams) error {
+			return notFound(cmd.op)
+		})
+	}
+}
+
+// Runs a command inside the shell (a builtin) with its redirections applied to the std streams it is given.
+// The error of a command whose stderr is redirected is written there, only its status is returned
+func (s *Shell) redirected(cmd Command, std Streams, run CommandFunc) error {
+	args := append([]string{}, cmd.args...)
+	redirected, release, err := s.pipe(&args, std)
 	if err != nil {
 		return err
 	}
-	if writer != os.Stdout {
-		defer writer.Close()
+	defer release()
+
+	err = run(args, redirected)
+	// A builtin whose output nobody reads anymore ends quietly, with the status of a process killed by SIGPIPE
+	if errors.Is(err, syscall.EPIPE) {
+		err = ExitStatus(128 + 13)
+	}
+	if err != nil && redirected.stderr != std.stderr {
+		s.reportTo(redirected.stderr, err)
+		err = ExitStatus(exitCode(err))
 	}
+	return err
+}
 
-	output.WriteString(strings.Join(args, " "))
-	fmt.Fprintln(writer, output.String())
+// Shell external command execution on the std streams, unless redirected
+func (s *Shell) executeExternal(cmd Command, std Streams) error {
+	ext, release, err := s.external(cmd, std)
+	if err != nil {
+		return err
+	}
+	defer release()
 
-	if next != nil {
-		return next(nil, nil)
+	// On a terminal the command gets a process group of its own holding the terminal, so Ctrl+Z stops only
+	// the command, which is then kept as a job
+	suspendable := canSuspend && s.pipelines == 0 && term.IsTerminal(int(os.Stdin.Fd()))
+	if suspendable {
+		joinProcessGroup(ext, 0)
+	}
+	painted := s.paintStderr(ext)
+	if err := ext.Start(); err != nil {
+		painted()
+		if suspendable {
+			reclaimTerminal()
+		}
+		return cannotExecute(cmd.op, err)
+	}
+	done := s.jobs.setRunning([]int{ext.Process.Pid}, std.scope)
+	if suspendable {
+		stopped := waitStopped(ext.Process.Pid)
+		done()
+		reclaimTerminal()
+		if stopped {
+			// The job's stderr is still copied while it runs
+			go painted()
+			return s.suspend(ext, strings.Join(append([]string{cmd.op}, cmd.args...), " "))
+		}
 	}
+	err = ext.Wait()
+	painted()
+	done()
+	if ext.ProcessState != nil && s.options.Get("rusage") {
+		reportUsage(ext.ProcessState)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", cmd.op, err)
+	}
+
 	return nil
 }
 
-// Shell builtin type, check for builtin or external command
-func (s *Shell) _type(args []string, next CommandFunc) error {
-	if len(args) != 1 {
-		return fmt.Errorf("Error: Expected 1 argument, received %d", len(args))
+// Builds an external command using the std streams, unless they are redirected. release closes the
+// redirection targets, the caller calls it once the command has started
+func (s *Shell) external(cmd Command, std Streams) (ext *exec.Cmd, release func(), err error) {
+	args := append([]string{}, cmd.args...)
+	redirected, release, err := s.pipe(&args, std)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	path, exists := s.find(cmd.op)
+	if !exists {
+		release()
+		return nil, nil, notFound(cmd.op)
+	}
+	ext = exec.Command(path, args...)
+	ext.Args[0] = cmd.op
+	ext.Stdin = redirected.stdin
+	ext.Stdout = redirected.stdout
+	ext.Stderr = redirected.stderr
+	// Built without touching the variables, builtin stages of a pipeline may be reading them concurrently
+	ext.Env = withAssignments(s.environ(), cmd.assignments)
+	return ext, release, nil
+}
+
+// Runs commands connected by |, every stage's stdout feeding the next stage's stdin. All stages run
+// concurrently: external ones as processes, builtin ones in goroutines of the shell writing to their pipes.
+// The pipeline's status is the last stage's, which is returned so the command list continues after it
+func (s *Shell) executePipeline(cmd *Command, std Streams) (*Command, error) {
+	if cmd.connector != "|" {
+		return cmd, s.executeSimple(*cmd, std)
+	}
+
+	stages := []Command{*cmd}
+	last := cmd
+	for ; last.connector == "|"; last = last.nextCommand {
+		if last.nextCommand == nil {
+			return last, fmt.Errorf("syntax error near unexpected token '|'")
+		}
+		stages = append(stages, *last.nextCommand)
+	}
+	if last.background {
+		return last, fmt.Errorf("%s: pipelines can't run in the background", last.op)
+	}
+
+	// Ends of the pipes between stages, stage i reads stdin[i] and writes stdout[i]
+	stdin := make([]*os.File, len(stages))
+	stdout := make([]*os.File, len(stages))
+	stdin[0], stdout[len(stages)-1] = std.stdin, std.stdout
+	// The ends of a stage's pipes are closed as soon as it started, for the stages next to it to see EOF and
+	// EPIPE, and once more when the pipeline is over whatever happened to it
+	var pipes openFiles
+	defer pipes.close()
+	closeStage := func(i int) {
+		if i > 0 {
+			stdin[i].Close()
+		}
+		if i < len(stages)-1 {
+			stdout[i].Close()
+		}
+	}
+	for i := 0; i < len(stages)-1; i++ {
+		reader, writer, err := os.Pipe()
+		if err != nil {
+			return last, fmt.Errorf("pipe: %v", err)
+		}
+		pipes.add(reader)
+		pipes.add(writer)
+		stdout[i], stdin[i+1] = writer, reader
+	}
+
+	errs := make([]error, len(stages))
+	procs := make([]*exec.Cmd, len(stages))
+	var builtins sync.WaitGroup
+	// A pipeline starting with an external command runs in a process group of its own, led by the first
+	// process started, which holds the terminal until the pipeline is over. Otherwise the stages stay in the
+	// shell's group: a builtin reading the terminal from the background would stop the shell
+	pgid := -1
+	for i, stage := range stages {
+		stage = s.expandCommand(stage)
+		s.trace(stage)
+		if _, internal := s.internal(stage.op); i == 0 && !internal && stage.op != "" && s.pipelines == 0 && term.IsTerminal(int(os.Stdin.Fd())) {
+			pgid = 0
+		}
+		std := Streams{stdin[i], stdout[i], std.stderr, std.scope}
+		// Assignments alone in a stage only last as long as it does, like in bash's subshell
+		if stage.op == "" {
+			closeStage(i)
+			continue
+		}
+		if err := s.preExec(stage); err != nil {
+			errs[i] = err
+			closeStage(i)
+			if i < len(stages)-1 {
+				s.report(errs[i])
+			}
+			continue
+		}
+		if builtin, exists := s.internal(stage.op); exists {
+			builtins.Add(1)
+			go func() {
+				defer builtins.Done()
+				errs[i] = s.redirected(stage, std, builtin)
+				closeStage(i)
+				if i < len(stages)-1 {
+					s.report(errs[i])
+				}
+			}()
+			continue
+		}
+		if _, exists := s.findCommand(stage.op); !exists {
+			errs[i] = notFound(stage.op)
+		} else if ext, release, err := s.external(stage, std); err != nil {
+			errs[i] = err
+		} else {
+			if pgid >= 0 {
+				joinProcessGroup(ext, pgid)
+			}
+			painted := s.paintStderr(ext)
+			defer painted()
+			if err := ext.Start(); err != nil {
+				errs[i] = cannotExecute(stage.op, err)
+			} else {
+				procs[i] = ext
+				if pgid == 0 {
+					pgid = ext.Process.Pid
+				}
+			}
+			release()
+		}
+		closeStage(i)
+		if i < len(stages)-1 {
+			s.report(errs[i])
+		}
+	}
+
+	pids := []int{}
+	for _, ext := range procs {
+		if ext != nil {
+			pids = append(pids, ext.Process.Pid)
+		}
 	}
-	if _, exists := s.commands[args[0]]; exists {
-		fmt.Println(args[0] + " is a shell builtin")
-	} else if fp, exists := find(args[0]); exists {
-		fmt.Println(args[0] + " is " + fp)
+	defer s.jobs.setRunning(pids, std.scope)()
+
+	builtins.Wait()
+	for i, ext := range procs {
+		if ext == nil {
+			continue
+		}
+		err := ext.Wait()
+		if s.options.Get("rusage") {
+			reportUsage(ext.ProcessState)
+		}
+		if err != nil {
+			errs[i] = fmt.Errorf("%s: %w", stages[i].op, err)
+		}
+	}
+	if pgid > 0 {
+		reclaimTerminal()
+	}
+
+	// Ctrl+C interrupts the pipeline as a whole, even when its last command exits normally on the signal
+	for _, err := range errs {
+		if sig, ok := signaled(err); ok && sig == syscall.SIGINT {
+			return last, err
+		}
+	}
+	return last, errs[len(stages)-1]
+}
+
+// ** Term **
+// ------------------------------------------------------------------------------------------
+
+func (s *Shell) setupTerminal() (*TerminalState, error) {
+	if runtime.GOOS == "windows" {
+		oldState, err := term.MakeRaw(int(os.Stdin.Fd()))
+		if err != nil {
+			return nil, err
+		}
+		return &TerminalState{oldState: oldState}, nil
+	}
+
+	oldState, err := term.MakeRaw(int(os.Stdin.Fd()))
+	if err != nil {
+		return nil, err
+	}
+	return &TerminalState{oldState: oldState}, nil
+}
+
+func (s *Shell) restoreTerminal(ts *TerminalState) {
+	if ts != nil && ts.oldState != nil {
+		term.Restore(int(os.Stdin.Fd()), ts.oldState)
+	}
+}
+
+// Leaves raw mode before running a foreground command, which gets the terminal modes the shell saved
+func (s *Shell) cookTerminal() {
+	if s.tty != nil {
+		term.Restore(int(os.Stdin.Fd()), s.tty.oldState)
+	}
+}
+
+// Returns to raw mode after a foreground command. Modes the command changed, or left behind when it crashed,
+// are reset unless keep_tty_changes is set, in which case they become the saved modes
+func (s *Shell) rawTerminal() {
+	if s.tty == nil {
+		return
+	}
+	fd := int(os.Stdin.Fd())
+	if s.options.Get("keep_tty_changes") {
+		if state, err := term.GetState(fd); err == nil {
+			s.tty.oldState = state
+		}
 	} else {
-		fmt.Println(args[0] + ": not found")
+		term.Restore(fd, s.tty.oldState)
+	}
+	term.MakeRaw(fd)
+}
+
+// Completes the word being typed at the end of input. The line is lexed with the parser's quoting rules, so
+// the word may be quoted or escaped; a command name, a path, or an argument of a command with its own completer
+// is completed and quoted back the way the word was started
+func (s *Shell) TabComplete(input string) string {
+	ctx := lexCompletion(input)
+	prefix := input[:ctx.start]
+
+	if s.options.Get("globcomplete") && ctx.quote == 0 && strings.ContainsAny(ctx.word, "*?[") {
+		if expanded := s.expandGlob(ctx.word); len(expanded) > 0 {
+			return prefix + strings.Join(expanded, " ")
+		}
+		return input
+	}
+
+	// A parameter being typed completes to variable names, a unique one closes the brace of ${
+	if name, braced, ok := partialParameter(input); ok && ctx.quote != '\'' {
+		matches := s.completeVariable(name)
+		switch {
+		case len(matches) == 1 && braced:
+			return input[:len(input)-len(name)] + matches[0] + "}"
+		case len(matches) == 1:
+			return input[:len(input)-len(name)] + matches[0]
+		case len(matches) > 1:
+			return input[:len(input)-len(name)] + s.findCommonPrefix(matches)
+		}
+		return input
+	}
+
+	var matches []string
+	switch {
+	case ctx.word == "" && ctx.command:
+		return input
+	case ctx.command && !strings.ContainsRune(ctx.word, os.PathSeparator) && !strings.HasPrefix(ctx.word, "~"):
+		matches = s.completeCommand(ctx.word)
+	case !ctx.command && s.completers[ctx.name] != nil:
+		matches = s.completeArgument(ctx.word, s.completers[ctx.name])
+	default:
+		matches = s.completePath(ctx.word)
+	}
+
+	if len(matches) == 0 {
+		return input
+	}
+	completed, unique := matches[0], len(matches) == 1
+	if !unique {
+		completed = s.findCommonPrefix(matches)
+	}
+	if completed == ctx.word && !unique {
+		return input
+	}
+	// A unique completion closes the quote, unless it is a directory still being descended into
+	closed := unique && !strings.HasSuffix(completed, string(os.PathSeparator))
+	return prefix + requote(completed, ctx.quote, closed)
+}
+
+// Finds a $NAME or ${NAME parameter reference being typed at the end of a line, returning the partial name
+func partialParameter(input string) (name string, braced bool, ok bool) {
+	start := len(input)
+	for start > 0 && isValidName("a"+input[start-1:len(input)]) {
+		start--
+	}
+	name, i := input[start:], start-1
+	if i >= 0 && input[i] == '{' {
+		braced = true
+		i--
+	}
+	if i < 0 || input[i] != '$' || i > 0 && input[i-1] == '\\' {
+		return "", false, false
+	}
+	return name, braced, name != "" || braced
+}
+
+// The word being completed at the end of a line, and its place in the command it belongs to
+type completionContext struct {
+	start   int    // offset of the word in the line, including an opening quote
+	word    string // the word with its quotes and escapes removed
+	quote   byte   // quote left open at the end of the line, 0 when none
+	command bool   // the word is in command position: first of a command, after ;, &, | or &&
+	name    string // command the word is an argument of
+}
+
+// Lexes a partially typed line like parseCommand does, finding where the last word starts and whether it is
+// quoted. Words after < or > are redirection targets, they are neither commands nor arguments
+func lexCompletion(input string) completionContext {
+	ctx := completionContext{start: len(input), command: true}
+	var word strings.Builder
+	var quote byte
+	inWord, backslash, redirect := false, false, false
+
+	startWord := func(i int) {
+		if !inWord {
+			inWord = true
+			ctx.start = i
+		}
 	}
-	if next != nil {
-		return next(nil, nil)
+	endWord := func() {
+		if inWord {
+			switch {
+			case redirect:
+				redirect = false
+			case ctx.command:
+				ctx.name, ctx.command = word.String(), false
+			}
+		}
+		inWord = false
+		word.Reset()
+		ctx.start = len(input)
+	}
+
+	for i := 0; i < len(input); i++ {
+		c := input[i]
+		switch {
+		case backslash:
+			// Inside double quotes a backslash only escapes \ " $ and `
+			if quote == '"' && !strings.ContainsRune("\\\"$`", rune(c)) {
+				word.WriteByte('\\')
+			}
+			word.WriteByte(c)
+			backslash = false
+		case quote == '\'':
+			if c == '\'' {
+				quote = 0
+			} else {
+				word.WriteByte(c)
+			}
+		case c == '\\':
+			startWord(i)
+			backslash = true
+		case quote == '"':
+			if c == '"' {
+				quote = 0
+			} else {
+				word.WriteByte(c)
+			}
+		case c == '\'' || c == '"':
+			startWord(i)
+			quote = c
+		case c == ' ' || c == '\t':
+			endWord()
+		case c == ';' || c == '&' || c == '|':
+			endWord()
+			ctx.command, ctx.name, redirect = true, "", false
+		case c == '<' || c == '>':
+			// Digits right before > are the fd being redirected, not a word
+			if inWord && strings.Trim(word.String(), "0123456789") == "" {
+				inWord = false
+				word.Reset()
+			}
+			endWord()
+			redirect = true
+		default:
+			startWord(i)
+			word.WriteByte(c)
+		}
+	}
+
+	ctx.word = word.String()
+	ctx.quote = quote
+	if redirect {
+		ctx.command = false
+		ctx.name = ""
+	}
+	return ctx
+}
+
+// Quotes a completed word the way it was started: inside the quote left open, which is closed when closed is
+// set, and escaped with backslashes otherwise
+func requote(word string, quote byte, closed bool) string {
+	end := ""
+	if closed {
+		end = string(quote)
 	}
+	switch quote {
+	case '\'':
+		return "'" + strings.ReplaceAll(word, "'", `'\''`) + end
+	case '"':
+		var quoted strings.Builder
+		for _, c := range word {
+			if strings.ContainsRune(doubleQuotedSpecials, c) {
+				quoted.WriteByte('\\')
+			}
+			quoted.WriteRune(c)
+		}
+		return `"` + quoted.String() + end
+	}
+	return escapeWord(word)
+}
+
+// Commands (builtins and executables in PATH) starting with partial
+func (s *Shell) completeCommand(partial string) []string {
+	matches := []string{}
+
+	// Check built-in commands and functions
+	for cmd := range s.commands {
+		if s.hasPrefix(cmd, partial) {
+			matches = append(matches, cmd)
+		}
+	}
+	for name := range s.functions {
+		if s.hasPrefix(name, partial) && !slices.Contains(matches, name) {
+			matches = append(matches, name)
+		}
+	}
+
+	// Check executables in PATH
+	path, _ := s.getVar("PATH")
+	for _, exe := range s.complete.Executables(path) {
+		if s.hasPrefix(exe, partial) && !slices.Contains(matches, exe) {
+			matches = append(matches, exe)
+		}
+	}
+	return matches
+}
+
+// Candidates of a per-command completer for word. Candidates that don't share the typed word as a prefix
+// (a process name completing to its PID) are only used when they are unambiguous
+func (s *Shell) completeArgument(word string, completer Completer) []string {
+	matches := completer(word)
+	if len(matches) > 1 && !strings.HasPrefix(s.findCommonPrefix(matches), word) {
+		return nil
+	}
+	return matches
+}
+
+// ** Builtins **
+// ------------------------------------------------------------------------------------------
+
+// Shell builtin exit, with the status of the last command when n is omitted. n is taken modulo 256, a
+// non-numeric n still exits, with status 2
+func (s *Shell) exit(args []string, std Streams) error {
+	if !s.mayExit() {
+		return ExitStatus(1)
+	}
+	if len(args) > 1 {
+		return fmt.Errorf("exit: too many arguments")
+	} else if len(args) == 0 {
+		s.shutdown(s.status)
+	} else {
+		code, err := strconv.Atoi(strings.TrimSpace(args[0]))
+		if err != nil {
+			fmt.Fprintf(std.stderr, "exit: %s: numeric argument required\n", args[0])
+			s.shutdown(2)
+		}
+		s.shutdown((code%256 + 256) % 256)
+	}
+	return nil
+}
+
+// Shell builtin pipe, used for externals and builtins: removes the redirections from args and opens their
+// targets, in order, so > out 2>&1 sends both streams to out. stdin comes from < file, from the body of a
+// here-document (<< and <<-, whose delimiter word was replaced by the body once it was read) or from the word
+// of a here-string (<<<) followed by a newline; stdout and stderr
+// are redirected by >, >>, >| with an optional fd, &>, &>> and the duplications 2>&1 and 1>&2. Streams that
+// aren't redirected are taken from std, release closes the files that were opened
+func (s *Shell) pipe(args *[]string, std Streams) (redirected Streams, release func(), err error) {
+	redirected = std
+	var opened openFiles
+	release = opened.close
+	fail := func(err error) (Streams, func(), error) {
+		release()
+		return Streams{}, nil, err
+	}
+
+	for i := 0; i < len(*args); {
+		token := (*args)[i]
+		input := token == "<" || token == "<<" || token == "<<-" || token == "<<<"
+		redirect, ok := parseRedirect(token)
+		if !input && (!ok || redirect.fd != 1 && redirect.fd != 2) {
+			i++
+			continue
+		}
+
+		if !input && redirect.dup != -1 {
+			switch {
+			case redirect.fd == 2 && redirect.dup == 1:
+				redirected.stderr = redirected.stdout
+			case redirect.fd == 1 && redirect.dup == 2:
+				redirected.stdout = redirected.stderr
+			case redirect.dup != redirect.fd:
+				return fail(fmt.Errorf("%d: bad file descriptor", redirect.dup))
+			}
+			*args = append(append([]string{}, (*args)[:i]...), (*args)[i+1:]...)
+			continue
+		}
+
+		if i == len(*args)-1 {
+			return fail(fmt.Errorf("syntax error near unexpected token 'newline'"))
+		}
+		var file *os.File
+		switch token {
+		case "<":
+			path := strings.TrimSpace((*args)[i+1])
+			if file, err = os.Open(path); err != nil {
+				return fail(fmt.Errorf("%s: %s", path, describeError(err)))
+			}
+		case "<<", "<<-":
+			if file, err = feed((*args)[i+1]); err != nil {
+				return fail(err)
+			}
+		case "<<<":
+			if file, err = feed((*args)[i+1] + "\n"); err != nil {
+				return fail(err)
+			}
+		default:
+			if file, err = s.openRedirect(strings.TrimSpace((*args)[i+1]), redirect); err != nil {
+				return fail(err)
+			}
+		}
+		// A later redirection of the same fd wins, the earlier target is still created like in other shells
+		opened.add(file)
+		switch {
+		case input:
+			redirected.stdin = file
+		case redirect.both:
+			redirected.stdout, redirected.stderr = file, file
+		case redirect.fd == 1:
+			redirected.stdout = file
+		default:
+			redirected.stderr = file
+		}
+		*args = append(append([]string{}, (*args)[:i]...), (*args)[i+2:]...)
+	}
+	return redirected, release, nil
+}
+
+// Files opened to run a command: redirection targets, here-document feeds and the pipes between stages.
+// Whichever way running the command ends, close is called and closes the ones still open
+type openFiles []*os.File
+
+func (f *openFiles) add(file *os.File) {
+	*f = append(*f, file)
+}
+
+// Closes every file, closing one twice is harmless
+func (f *openFiles) close() {
+	for _, file := range *f {
+		file.Close()
+	}
+	*f = nil
+}
+
+// Returns the read end of a pipe that delivers text. A goroutine writes it so text may exceed the pipe buffer,
+// the write fails once the reader is closed by a command that didn't read it all
+func feed(text string) (*os.File, error) {
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		return nil, fmt.Errorf("pipe: %v", err)
+	}
+	go func() {
+		io.WriteString(writer, text)
+		writer.Close()
+	}()
+	return reader, nil
+}
+
+// Shell builtin echo
+func (s *Shell) echo(args []string, std Streams) error {
+	if _, err := fmt.Fprintln(std.stdout, strings.Join(args, " ")); err != nil {
+		return fmt.Errorf("echo: write error: %w", err)
+	}
+
+	return nil
+}
+
+// Shell builtin type, tells how each name resolves as a command, in the order the shell resolves it: alias, reserved word,
+// function or builtin (in the order functions_first sets), external command. -p prints only the path of the executable, nothing for the others
+func (s *Shell) _type(args []string, std Streams) error {
+	pathOnly := len(args) > 0 && args[0] == "-p"
+	if pathOnly {
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		return fmt.Errorf("type: usage: %s", builtinDocs["type"].Usage)
+	}
+
+	var err error
+	for _, name := range args {
+		alias, aliased := s.cmdAlias[name]
+		_, builtin := s.commands[name]
+		fp, exists := s.find(name)
+		switch {
+		case (aliased || reservedWords[name] || builtin || s.isFunction(name)) && pathOnly:
+		case aliased:
+			fmt.Fprintf(std.stdout, "%s is aliased to '%s'\n", name, alias)
+		case reservedWords[name]:
+			fmt.Fprintln(std.stdout, name+" is a shell keyword")
+		case s.isFunction(name):
+			fmt.Fprintln(std.stdout, name+" is a function")
+			fmt.Fprintln(std.stdout, s.functions[name].source)
+		case builtin:
+			fmt.Fprintln(std.stdout, name+" is a shell builtin")
+		case exists && pathOnly:
+			fmt.Fprintln(std.stdout, fp)
+		case exists:
+			fmt.Fprintln(std.stdout, name+" is "+fp)
+		case pathOnly:
+			err = ExitStatus(1)
+		default:
+			fmt.Fprintln(std.stderr, name+": not found")
+			err = ExitStatus(1)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
 
 // Shell builtin pwd
-func (s *Shell) pwd(args []string, next CommandFunc) error {
+func (s *Shell) pwd(args []string, std Streams) error {
 	path, err := os.Getwd()
 	if err != nil {
 		return err
 	}
-	fmt.Println(path)
-	if next != nil {
-		return next(nil, nil)
-	}
+	fmt.Fprintln(std.stdout, path)
 	return nil
 }
 
-// Shell builtin clear
-func (s *Shell) clear(args []string, next CommandFunc) error {
-	switch runtime.GOOS {
-	case "linux":
+// Shell builtin clear, -x clears only the visible screen (ED 2) and -a the scrollback too (ED 2 and ED 3)
+func (s *Shell) clear(args []string, std Streams) error {
+	mode := ""
+	for _, arg := range args {
+		switch arg {
+		case "-x", "-a":
+			mode = arg
+		default:
+			return fmt.Errorf("clear: %s: invalid option\nclear: usage: %s", arg, builtinDocs["clear"].Usage)
+		}
+	}
+
+	switch {
+	case mode == "-x":
+		fmt.Fprint(std.stdout, "\033[H\033[2J")
+	case mode == "-a":
+		fmt.Fprint(std.stdout, "\033[H\033[2J\033[3J")
+	case runtime.GOOS == "linux":
 		cmd := exec.Command("clear")
-		cmd.Stdout = os.Stdout
+		cmd.Stdout = std.stdout
 		cmd.Run()
-	case "windows":
+	case runtime.GOOS == "windows":
 		cmd := exec.Command("cmd", "/c", "cls")
 		cmd.Stdout = os.Stdout
 		cmd.Run()
 	default:
 		return fmt.Errorf("Error: Unsupported OS")
 	}
-	if next != nil {
-		return next(nil, nil)
+	return nil
+}
+
+// Shell builtin exec, replaces the shell with a command (-a sets the argv[0] it sees), without a command
+// its redirections rewire the shell's own streams for the rest of the session
+func (s *Shell) exec(args []string, std Streams) error {
+	argv0 := ""
+	i := 0
+options:
+	for ; i < len(args) && strings.HasPrefix(args[i], "-"); i++ {
+		switch args[i] {
+		case "-a":
+			if i+1 >= len(args) {
+				return fmt.Errorf("exec: -a: option requires an argument")
+			}
+			i++
+			argv0 = args[i]
+		case "--":
+			i++
+			break options
+		default:
+			return fmt.Errorf("exec: %s: invalid option\nexec: usage: %s", args[i], builtinDocs["exec"].Usage)
+		}
+	}
+
+	// Redirections may come before, after or among the command words
+	command := []string{}
+	redirects := []int{}
+	for ; i < len(args); i++ {
+		redirect, ok := parseRedirect(args[i])
+		if !ok {
+			command = append(command, args[i])
+			continue
+		}
+		if redirect.fd != 1 && redirect.fd != 2 {
+			return fmt.Errorf("exec: %d: bad file descriptor", redirect.fd)
+		}
+		redirects = append(redirects, i)
+		if redirect.both || redirect.dup == -1 {
+			if i >= len(args)-1 {
+				return fmt.Errorf("exec: syntax error near unexpected token 'newline'")
+			}
+			i++
+		}
+	}
+	path := ""
+	if len(command) > 0 {
+		found, exists := s.find(command[0])
+		if !exists {
+			return fmt.Errorf("exec: %s: not found", command[0])
+		}
+		path = found
+	}
+
+	for _, i := range redirects {
+		redirect, _ := parseRedirect(args[i])
+		if redirect.both {
+			file, err := s.openRedirect(args[i+1], redirect)
+			if err != nil {
+				return fmt.Errorf("exec: %v", err)
+			}
+			if err := s.rewire(1, file); err != nil {
+				return fmt.Errorf("exec: %v", err)
+			}
+			if err := s.rewire(2, os.Stdout); err != nil {
+				return fmt.Errorf("exec: %v", err)
+			}
+			continue
+		}
+
+		var file *os.File
+		switch redirect.dup {
+		case -1:
+			f, err := s.openRedirect(args[i+1], redirect)
+			if err != nil {
+				return fmt.Errorf("exec: %v", err)
+			}
+			file = f
+		case 1:
+			file = os.Stdout
+		case 2:
+			file = os.Stderr
+		default:
+			return fmt.Errorf("exec: %d: bad file descriptor", redirect.dup)
+		}
+		if err := s.rewire(redirect.fd, file); err != nil {
+			return fmt.Errorf("exec: %v", err)
+		}
+	}
+
+	if path == "" {
+		return nil
+	}
+	return s.execCommand(path, command, argv0)
+}
+
+// Replaces the shell process with the command at path, argv0 overrides the name it is run under when not empty.
+// The terminal gets the modes the shell found it in and the command inherits the streams exec rewired
+func (s *Shell) execCommand(path string, args []string, argv0 string) error {
+	if argv0 == "" {
+		argv0 = args[0]
+	}
+
+	argv := append([]string{argv0}, args[1:]...)
+	s.restoreTerminal(s.tty)
+	if err := replaceProcess(path, argv, s.environ()); err != nil {
+		return fmt.Errorf("exec: %s: %v", args[0], err)
 	}
 	return nil
 }
 
+// Points the shell's stdout (1) or stderr (2) at file for the rest of the session, see redirectStream
+func (s *Shell) rewire(fd int, file *os.File) error {
+	if fd == 2 {
+		return redirectStream(&os.Stderr, file)
+	}
+	return redirectStream(&os.Stdout, file)
+}
+
 // Shell builtin cd
-func (s *Shell) cd(args []string, next CommandFunc) error {
+func (s *Shell) cd(args []string, std Streams) error {
 	if len(args) == 0 {
 		return fmt.Errorf("Error: No directory specified")
 	}
-	err := os.Chdir(s.replacePath(args[0]))
+	err := s.chdir(args[0])
 	if err != nil {
-		return fmt.Errorf("cd: %v: No such file or directory", args[0])
-	}
-	if next != nil {
-		return next(nil, nil)
+		return fmt.Errorf("cd: %v: %s", args[0], describeError(err))
 	}
 	return nil
 }
@@ -472,59 +2048,277 @@ func (s *Shell) cd(args []string, next CommandFunc) error {
 // ** Utils **
 // ------------------------------------------------------------------------------------------
 
-// Shell path aliases
-func (s *Shell) replacePath(path string) string {
-	for alias, origin := range s.aliases {
-		path = strings.Replace(path, alias, origin, 1)
+// Prints the error of a command line, failing external commands already reported on their own stderr
+func (s *Shell) report(err error) {
+	s.reportTo(os.Stderr, err)
+}
+
+// Prints the error of a command to w
+func (s *Shell) reportTo(w io.Writer, err error) {
+	var exitErr *exec.ExitError
+	var status ExitStatus
+	if err == nil || errors.As(err, &exitErr) || errors.As(err, &status) {
+		return
 	}
-	return path
+	fmt.Fprintln(w, err)
 }
 
-// Shell executable finder
-func find(exe string) (string, bool) {
-	paths := strings.Split(os.Getenv("PATH"), ":")
-	for _, path := range paths {
-		fp := filepath.Join(path, exe)
-		if _, err := os.Stat(fp); err == nil {
-			return fp, true
+// Exit status a command's error stands for
+func exitCode(err error) int {
+	var exitErr *exec.ExitError
+	var status ExitStatus
+	var cmdErr *CommandError
+	switch {
+	case err == nil:
+		return 0
+	case errors.As(err, &exitErr):
+		if sig, ok := signaled(err); ok {
+			return 128 + int(sig)
 		}
+		return exitErr.ExitCode()
+	case errors.As(err, &status):
+		return int(status)
+	case errors.As(err, &cmdErr):
+		return cmdErr.status
 	}
-	return "NOENT", false
+	return 1
 }
 
-// completePath handles file path completion
-func (s *Shell) completePath(input string) string {
-	lastSpace := strings.LastIndex(input, " ")
-	if lastSpace == -1 {
-		return input
+// Error for a command that isn't a builtin and wasn't found in PATH. A name with a slash is a path, which may
+// be missing, a directory or a file that isn't executable
+func notFound(name string) error {
+	if !strings.ContainsRune(name, '/') && !strings.ContainsRune(name, filepath.Separator) {
+		return &CommandError{127, name + ": command not found"}
+	}
+	fi, err := os.Stat(name)
+	switch {
+	case err != nil:
+		return &CommandError{127, name + ": " + describeError(err)}
+	case fi.IsDir():
+		return &CommandError{126, name + ": Is a directory"}
+	}
+	return &CommandError{126, name + ": Permission denied"}
+}
+
+// Error for a command found that failed to start
+func cannotExecute(name string, err error) error {
+	return &CommandError{126, name + ": " + describeError(err)}
+}
+
+// Signal that killed the command an error came from
+func signaled(err error) (syscall.Signal, bool) {
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return 0, false
+	}
+	if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return status.Signal(), true
+	}
+	return 0, false
+}
+
+// Opens a redirection target for writing, truncating it unless appending.
+// The file is created with mode 0666 so the umask decides its final permissions, missing parent directories are an error.
+// With noclobber set, > refuses to truncate an existing regular file unless forced with >|, with clobber_confirm
+// an interactive shell asks first
+func (s *Shell) openRedirect(path string, redirect Redirect) (*os.File, error) {
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if redirect.append {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	} else if s.options.Get("noclobber") && !redirect.force {
+		if fi, err := os.Stat(path); err == nil && fi.Mode().IsRegular() {
+			return nil, fmt.Errorf("%s: cannot overwrite existing file", path)
+		} else if err != nil {
+			flags = os.O_WRONLY | os.O_CREATE | os.O_EXCL
+		}
+	} else if !redirect.force && !s.confirmOverwrite(path) {
+		return nil, fmt.Errorf("%s: not overwritten", path)
+	}
+	file, err := os.OpenFile(path, flags, 0666)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, describeError(err))
+	}
+	return file, nil
+}
+
+// Splits a redirection operator such as >, 2>>, >|, 1>&2, &> into its parts
+func parseRedirect(token string) (Redirect, bool) {
+	redirect := Redirect{fd: 1, dup: -1}
+	if rest, ok := strings.CutPrefix(token, "&>"); ok {
+		redirect.both = true
+		redirect.append = rest == ">"
+		return redirect, rest == "" || rest == ">"
+	}
+	digits := strings.TrimLeft(token, "0123456789")
+	if n := len(token) - len(digits); n > 0 {
+		redirect.fd, _ = strconv.Atoi(token[:n])
 	}
+	if !strings.HasPrefix(digits, ">") {
+		return Redirect{}, false
+	}
+	rest := digits[1:]
+	switch {
+	case strings.HasPrefix(rest, ">"):
+		redirect.append = true
+		rest = rest[1:]
+	case strings.HasPrefix(rest, "|"):
+		redirect.force = true
+		rest = rest[1:]
+	}
+	if strings.HasPrefix(rest, "&") {
+		target, err := strconv.Atoi(rest[1:])
+		if err != nil {
+			return Redirect{}, false
+		}
+		redirect.dup = target
+		return redirect, true
+	}
+	return redirect, rest == ""
+}
+
+// Human-readable reason of a filesystem error, worded like the shell errors users are used to
+func describeError(err error) string {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return "No such file or directory"
+	case errors.Is(err, fs.ErrPermission):
+		return "Permission denied"
+	case errors.Is(err, syscall.EISDIR):
+		return "Is a directory"
+	case errors.Is(err, syscall.ENOTDIR):
+		return "Not a directory"
+	case errors.Is(err, syscall.ESRCH):
+		return "No such process"
+	}
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		return pathErr.Err.Error()
+	}
+	return err.Error()
+}
+
+// Expands the tilde prefix of a path typed on the line, for completion
+func (s *Shell) tildePath(path string) string {
+	if !strings.HasPrefix(path, "~") {
+		return path
+	}
+	prefix, rest, found := strings.Cut(path, "/")
+	if found {
+		rest = "/" + rest
+	}
+	return s.tilde(prefix) + rest
+}
+
+// Shell executable finder, resolves a command through the lookup cache using the shell's PATH
+func (s *Shell) find(exe string) (string, bool) {
+	path, _ := s.getVar("PATH")
+	return s.hash.Lookup(exe, path)
+}
+
+// Looks up a command about to run. Before it is reported as not found the PATH directory listings cached for
+// completion are dropped and the lookup is tried once more, so a binary installed moments ago in another
+// terminal runs (and completes) right away. PATH lookups themselves never remember a miss
+func (s *Shell) findCommand(name string) (string, bool) {
+	if path, exists := s.find(name); exists {
+		return path, true
+	}
+	path, _ := s.getVar("PATH")
+	s.complete.Forget(filepath.SplitList(path)...)
+	return s.find(name)
+}
 
-	prefix := input[:lastSpace+1]
-	partial := s.replacePath(input[lastSpace+1:])
+// Paths starting with partial, directories ending with a separator
+func (s *Shell) completePath(partial string) []string {
+	partial = s.tildePath(partial)
 
 	dir := "."
 	if filepath.Dir(partial) != "." {
 		dir = filepath.Dir(partial)
 	}
+	name := filepath.Base(partial)
+	if strings.HasSuffix(partial, string(os.PathSeparator)) {
+		dir, name = partial, ""
+	}
 
-	pattern := filepath.Join(dir, filepath.Base(partial)+"*")
-	matches, err := filepath.Glob(pattern)
-	if err != nil || len(matches) == 0 {
-		return input
+	matches := s.matchEntries(dir, name)
+	for i, match := range matches {
+		if dir == "." && strings.HasPrefix(partial, "."+string(os.PathSeparator)) {
+			match = "." + string(os.PathSeparator) + match
+		}
+		if fi, err := os.Stat(matches[i]); err == nil && fi.IsDir() {
+			match += string(os.PathSeparator)
+		}
+		matches[i] = match
 	}
+	return matches
+}
 
-	if len(matches) == 1 {
-		fi, err := os.Stat(matches[0])
-		if err != nil {
-			return input
+// matchEntries lists the entries of dir starting with partial, honoring the dotglob and complete_ignore_case options
+func (s *Shell) matchEntries(dir, partial string) []string {
+	entries, err := s.complete.List(dir)
+	if err != nil {
+		return nil
+	}
+
+	matches := []string{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if s.hasPrefix(name, partial) && !s.hidden(name, partial) {
+			matches = append(matches, filepath.Join(dir, name))
 		}
-		if fi.IsDir() {
-			return prefix + matches[0] + string(os.PathSeparator)
+	}
+	return matches
+}
+
+// hasPrefix reports whether a completion candidate starts with partial, ignoring case when complete_ignore_case is set
+func (s *Shell) hasPrefix(candidate, partial string) bool {
+	if s.options.Get("complete_ignore_case") {
+		return strings.HasPrefix(strings.ToLower(candidate), strings.ToLower(partial))
+	}
+	return strings.HasPrefix(candidate, partial)
+}
+
+// hidden reports whether a dotfile should be left out of a glob or completion, it is included when
+// the pattern itself starts with '.' or dotglob is set
+func (s *Shell) hidden(name, pattern string) bool {
+	return strings.HasPrefix(name, ".") && !strings.HasPrefix(pattern, ".") && !s.options.Get("dotglob")
+}
+
+// Expands the glob pattern of the word being completed in place, like bash's glob-expand-word. The matches are escaped
+func (s *Shell) expandGlob(word string) []string {
+	pattern := s.tildePath(word)
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil
+	}
+
+	expanded := []string{}
+	for _, match := range matches {
+		if s.hidden(filepath.Base(match), filepath.Base(pattern)) {
+			continue
 		}
-		return prefix + matches[0]
+		expanded = append(expanded, escapeWord(match))
 	}
+	return expanded
+}
+
+// Characters the parser and the expansions treat specially outside quotes: blanks, quotes and operators, the
+// $ of parameters, glob characters and the ! of history events. ~ and # are only special starting a word
+const unquotedSpecials = " \\'\"><&|;()$`*?[!"
 
-	return prefix + s.findCommonPrefix(matches)
+// Characters a backslash escapes inside double quotes, the others keep it
+const doubleQuotedSpecials = "\\\"$`"
+
+// escapeWord backslash-escapes characters the parser would otherwise treat specially
+func escapeWord(word string) string {
+	var escaped strings.Builder
+	for i, c := range word {
+		if strings.ContainsRune(unquotedSpecials, c) || i == 0 && (c == '~' || c == '#') {
+			escaped.WriteRune('\\')
+		}
+		escaped.WriteRune(c)
+	}
+	return escaped.String()
 }
 
 // findCommonPrefix finds the longest common prefix among strings
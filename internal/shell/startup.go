@@ -0,0 +1,56 @@
+package shell
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// ** Startup Profile **
+// ------------------------------------------------------------------------------------------
+
+// How long one startup phase took
+type startupPhase struct {
+	name     string
+	duration time.Duration
+}
+
+// Runs one startup phase, timing it for --profile-startup. Anything expensive (directory listings for
+// completion, PATH lookups such as the fuzzy finder's) is left out of startup and loaded on first use instead.
+// The phases run by NewShell are followed by the rc file and the terminal setup in Run
+func (s *Shell) phase(name string, init func()) {
+	start := time.Now()
+	init()
+	s.startup = append(s.startup, startupPhase{name: name, duration: time.Since(start)})
+}
+
+// Reports the startup phases once startup is over, on w, when the shell runs
+func (s *Shell) ProfileStartup(w io.Writer) {
+	s.profile = w
+}
+
+// Prints the startup profile when one was asked for, in the raw terminal's line ends once it is set up
+func (s *Shell) reportStartup() {
+	if s.profile == nil {
+		return
+	}
+	var report strings.Builder
+	s.ReportStartup(&report)
+	text := report.String()
+	if s.tty != nil {
+		text = strings.ReplaceAll(text, "\n", "\r\n")
+	}
+	io.WriteString(s.profile, text)
+	s.profile = nil
+}
+
+// Prints how long each startup phase took and the total
+func (s *Shell) ReportStartup(w io.Writer) {
+	var total time.Duration
+	for _, phase := range s.startup {
+		fmt.Fprintf(w, "%-12s %8.3fms\n", phase.name, float64(phase.duration.Microseconds())/1000)
+		total += phase.duration
+	}
+	fmt.Fprintf(w, "%-12s %8.3fms\n", "total", float64(total.Microseconds())/1000)
+}
@@ -0,0 +1,169 @@
+package shell
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/codecrafters-io/shell-starter-go/internal/editor"
+)
+
+// ** State **
+// ------------------------------------------------------------------------------------------
+
+// Snapshot of a shell session that host applications can serialize, as JSON, to checkpoint the session and
+// resume it later with RestoreState
+type State struct {
+	Dir        string                   `json:"dir"`
+	Variables  map[string]VariableState `json:"variables"`
+	Aliases    map[string]string        `json:"aliases"`
+	Functions  map[string]string        `json:"functions"` // definitions as they were typed
+	Options    map[string]bool          `json:"options"`
+	Positional []string                 `json:"positional"`
+	DirStack   []string                 `json:"dir_stack"`
+	Status     int                      `json:"status"`
+	Jobs       []JobInfo                `json:"jobs"`
+	History    []editor.HistoryEntry    `json:"history"`
+}
+
+// Shell variable as kept in a State
+type VariableState struct {
+	Value    string   `json:"value,omitempty"`
+	Array    []string `json:"array,omitempty"`
+	IsArray  bool     `json:"is_array,omitempty"`
+	Integer  bool     `json:"integer,omitempty"`
+	Exported bool     `json:"exported,omitempty"`
+	Readonly bool     `json:"readonly,omitempty"`
+}
+
+// Job as kept in a State, for information only: its processes belong to the session that started them
+type JobInfo struct {
+	ID      int    `json:"id"`
+	PID     int    `json:"pid"`
+	Command string `json:"command"`
+	State   string `json:"state"`
+	Status  int    `json:"status"`
+}
+
+// Snapshot of the session: current directory, variables, aliases, functions, options, positional
+// parameters, directory stack, last status, jobs and history
+func (s *Shell) State() State {
+	state := State{
+		Variables:  make(map[string]VariableState),
+		Aliases:    make(map[string]string),
+		Functions:  make(map[string]string),
+		Options:    make(map[string]bool),
+		Positional: append([]string{}, s.positional...),
+		DirStack:   append([]string{}, s.dirStack...),
+		Status:     s.status,
+		Jobs:       []JobInfo{},
+		History:    append([]editor.HistoryEntry{}, s.editor.History().Entries()...),
+	}
+	state.Dir, _ = os.Getwd()
+	for name, v := range s.vars {
+		state.Variables[name] = VariableState{
+			Value:    v.value,
+			Array:    append([]string(nil), v.array...),
+			IsArray:  v.isArray,
+			Integer:  v.integer,
+			Exported: v.exported,
+			Readonly: v.readonly,
+		}
+	}
+	for name, value := range s.cmdAlias {
+		state.Aliases[name] = value
+	}
+	for name, fn := range s.functions {
+		state.Functions[name] = fn.source
+	}
+	for _, name := range s.options.Names() {
+		state.Options[name] = s.options.Get(name)
+	}
+	s.jobs.mu.Lock()
+	defer s.jobs.mu.Unlock()
+	for _, job := range s.jobs.sorted() {
+		state.Jobs = append(state.Jobs, JobInfo{
+			ID:      job.id,
+			PID:     job.pid,
+			Command: job.command,
+			State:   job.state.String(),
+			Status:  job.status,
+		})
+	}
+	return state
+}
+
+// Resumes a session from a snapshot taken by State, replacing the variables, aliases, functions, positional
+// parameters, directory stack and history, setting the options and changing to the directory. Readonly and
+// unknown options are left alone and jobs aren't restored. Fails when the directory is gone or a function
+// doesn't parse, after restoring everything else
+func (s *Shell) RestoreState(state State) error {
+	s.vars = make(map[string]*Variable)
+	for name, v := range state.Variables {
+		s.vars[name] = &Variable{
+			value:    v.Value,
+			array:    append([]string(nil), v.Array...),
+			isArray:  v.IsArray,
+			integer:  v.Integer,
+			exported: v.Exported,
+			readonly: v.Readonly,
+		}
+	}
+	s.cmdAlias = make(map[string]string)
+	for name, value := range state.Aliases {
+		s.cmdAlias[name] = value
+	}
+	for name, value := range state.Options {
+		if _, known := optionDefaults[name]; known && !readonlyOptions[name] {
+			s.options.Set(name, value)
+		}
+	}
+	s.positional = append([]string{}, state.Positional...)
+	s.dirStack = append([]string{}, state.DirStack...)
+	s.editor.History().Clear()
+	s.editor.History().Load(state.History)
+
+	var errs []string
+	s.functions = make(map[string]*function)
+	for name, source := range state.Functions {
+		if err := s.define(source); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", name, err))
+		}
+	}
+	s.status = state.Status
+	if state.Dir != "" {
+		if err := os.Chdir(state.Dir); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", state.Dir, describeError(err)))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("restore: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Defines the functions of a definition's source, running nothing else
+func (s *Shell) define(source string) error {
+	lines := strings.Split(source, "\n")
+	next := func(string) (string, error) {
+		if len(lines) <= 1 {
+			return "", io.EOF
+		}
+		lines = lines[1:]
+		return lines[0], nil
+	}
+	b, err := s.readCompound(lines[0], next)
+	if err != nil {
+		return err
+	}
+	if len(b) == 0 {
+		return fmt.Errorf("not a function definition")
+	}
+	for _, n := range b {
+		if _, ok := n.compound.(*functionDefinition); !ok {
+			return fmt.Errorf("not a function definition")
+		}
+	}
+	return s.runBlock(b, standardStreams())
+}
@@ -0,0 +1,63 @@
+package shell
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// A session restored from its JSON snapshot into a new shell behaves as the original did
+func TestRestoreState(t *testing.T) {
+	original := scriptShell(t)
+	dir, _ := filepath.EvalSymlinks(t.TempDir())
+	for _, line := range []string{
+		"cd " + dir,
+		"export GREETING=hello",
+		"alias hi='echo hi there'",
+		"greet() { echo $GREETING $1; }",
+		"set -o nullglob",
+		"set -- one two",
+		"false",
+	} {
+		original.runLine(line)
+		original.editor.History().Add(line, dir)
+	}
+	data, err := json.Marshal(original.State())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	var state State
+	if err := json.Unmarshal(data, &state); err != nil {
+		t.Fatal(err)
+	}
+	restored := NewShell()
+	if err := restored.RestoreState(state); err != nil {
+		t.Fatal(err)
+	}
+
+	if cwd, _ := os.Getwd(); cwd != dir {
+		t.Errorf("directory is %q, want %q", cwd, dir)
+	}
+	if restored.status != 1 || !restored.options.Get("nullglob") || !slices.Equal(restored.positional, []string{"one", "two"}) {
+		t.Errorf("status %d, nullglob %v, positional %q", restored.status, restored.options.Get("nullglob"), restored.positional)
+	}
+	if !slices.Contains(restored.environ(), "GREETING=hello") {
+		t.Errorf("GREETING isn't exported")
+	}
+	output := captureOutput(t, func() {
+		restored.runLine("hi")
+		restored.runLine("greet world")
+	})
+	if output != "hi there\nhello world\n" {
+		t.Errorf("output is %q", output)
+	}
+	if entries := restored.editor.History().Entries(); len(entries) != 7 || entries[3].Line != "greet() { echo $GREETING $1; }" {
+		t.Errorf("history is %v", entries)
+	}
+}
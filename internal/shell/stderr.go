@@ -0,0 +1,54 @@
+package shell
+
+import (
+	"os"
+	"os/exec"
+
+	"github.com/codecrafters-io/shell-starter-go/internal/color"
+	"golang.org/x/term"
+)
+
+// ** Stderr Coloring **
+// ------------------------------------------------------------------------------------------
+
+// With color_stderr, what an external command writes to the shell's stderr is shown in red: its stderr is
+// a pseudo-terminal, so the command still sees a terminal there, or a pipe where there are none. The shell
+// copies from it, coloring each chunk as it arrives so prompts without a newline still show up. The returned
+// function is called once the command has exited, it waits for the copy to be written out
+func (s *Shell) paintStderr(ext *exec.Cmd) (finish func()) {
+	if !s.options.Get("color_stderr") || ext.Stderr != os.Stderr || !color.Enabled(os.Stderr) {
+		return func() {}
+	}
+	stopResizes := func() {}
+	reader, writer, err := openPty()
+	if err == nil {
+		// Newlines are left for the shell's terminal to translate
+		term.MakeRaw(int(writer.Fd()))
+		stopResizes = followResizes(reader)
+	} else if reader, writer, err = os.Pipe(); err != nil {
+		return func() {}
+	}
+	ext.Stderr = writer
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer reader.Close()
+		buf := make([]byte, 4096)
+		for {
+			n, err := reader.Read(buf)
+			if n > 0 {
+				os.Stderr.WriteString(color.Wrap(color.Red, string(buf[:n])))
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	return func() {
+		writer.Close()
+		<-done
+		stopResizes()
+	}
+}
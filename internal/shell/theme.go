@@ -0,0 +1,188 @@
+package shell
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/codecrafters-io/shell-starter-go/internal/color"
+)
+
+// ** Prompt Themes **
+// ------------------------------------------------------------------------------------------
+
+// Renders one piece of information shown by a prompt theme, "" leaves the segment out
+type promptSegment func(s *Shell) string
+
+// Segments a theme can show, PROMPT_SEGMENTS picks them by name
+var promptSegments = map[string]promptSegment{
+	"cwd":    (*Shell).promptDir,
+	"git":    (*Shell).gitBranch,
+	"jobs":   (*Shell).jobsIndicator,
+	"status": (*Shell).failedStatus,
+	"time": func(*Shell) string {
+		return time.Now().Format("15:04:05")
+	},
+}
+
+// A segment's name and rendered text
+type promptPart struct {
+	name, text string
+}
+
+// A prompt theme: the segments it shows unless PROMPT_SEGMENTS lists others, and how it lays them out
+type promptTheme struct {
+	segments []string
+	render   func(parts []promptPart) string
+}
+
+// Themes selected by PROMPT_THEME
+var promptThemes = map[string]promptTheme{
+	"minimal": {
+		segments: []string{"cwd"},
+		render: func(parts []promptPart) string {
+			var prompt strings.Builder
+			for _, part := range parts {
+				if part.name == "status" {
+					part.text = color.Wrap(color.Red, part.text)
+				}
+				prompt.WriteString(part.text + " ")
+			}
+			return prompt.String() + promptChar() + " "
+		},
+	},
+	"powerline": {
+		segments: []string{"cwd", "git", "jobs", "status"},
+		render:   renderPowerline,
+	},
+	"informative": {
+		segments: []string{"time", "cwd", "git", "jobs", "status"},
+		render: func(parts []promptPart) string {
+			var prompt strings.Builder
+			for _, part := range parts {
+				switch part.name {
+				case "time":
+					prompt.WriteString(color.Wrap(color.Dim, "["+part.text+"]"))
+				case "cwd":
+					prompt.WriteString(color.Wrap(color.Blue, part.text))
+				case "git":
+					prompt.WriteString(color.Wrap(color.Green, "("+part.text+")"))
+				case "jobs":
+					prompt.WriteString(color.Wrap(color.Yellow, part.text))
+				case "status":
+					prompt.WriteString(color.Wrap(color.Red, "✘ "+part.text))
+				default:
+					prompt.WriteString(part.text)
+				}
+				prompt.WriteByte(' ')
+			}
+			return prompt.String() + promptChar() + " "
+		},
+	},
+}
+
+// Background and foreground SGR colors of the powerline segments
+var powerlineColors = map[string][2]string{
+	"cwd":    {"44", "34"},
+	"git":    {"42", "32"},
+	"jobs":   {"43", "33"},
+	"status": {"41", "31"},
+	"time":   {"100", "90"},
+}
+
+// Segments on colored blocks joined by arrow separators (which need a powerline-patched font)
+func renderPowerline(parts []promptPart) string {
+	var prompt strings.Builder
+	previous := ""
+	for _, part := range parts {
+		colors, ok := powerlineColors[part.name]
+		if !ok {
+			colors = powerlineColors["time"]
+		}
+		if previous != "" {
+			prompt.WriteString(color.Wrap(previous+";"+colors[0], ""))
+		}
+		prompt.WriteString(color.Wrap(colors[0]+";97", " "+part.text+" "))
+		previous = colors[1]
+	}
+	if previous != "" {
+		prompt.WriteString(color.Wrap(previous, ""))
+	}
+	return prompt.String() + " "
+}
+
+// Prompt of the theme PROMPT_THEME names, false when it names none. PROMPT_SEGMENTS (names separated by
+// spaces or commas) replaces the theme's segments
+func (s *Shell) themePrompt(name string) (string, bool) {
+	theme, exists := promptThemes[name]
+	if !exists {
+		return "", false
+	}
+	names := theme.segments
+	if value, exists := s.getVar("PROMPT_SEGMENTS"); exists && strings.TrimSpace(value) != "" {
+		names = strings.FieldsFunc(value, func(r rune) bool { return r == ' ' || r == ',' })
+	}
+
+	parts := []promptPart{}
+	for _, name := range names {
+		if segment, exists := promptSegments[name]; exists {
+			if text := segment(s); text != "" {
+				parts = append(parts, promptPart{name, text})
+			}
+		}
+	}
+	return theme.render(parts), true
+}
+
+// Exit status of the last command when it failed
+func (s *Shell) failedStatus() string {
+	if s.status == 0 {
+		return ""
+	}
+	return strconv.Itoa(s.status)
+}
+
+// Branch checked out in the git repository containing the current directory, or the abbreviated commit when
+// HEAD is detached. Reads .git directly instead of running git, the prompt is drawn often
+func (s *Shell) gitBranch() string {
+	dir, err := os.Getwd()
+	if err != nil {
+		return ""
+	}
+	for {
+		gitDir := filepath.Join(dir, ".git")
+		if fi, err := os.Stat(gitDir); err == nil {
+			if !fi.IsDir() {
+				// Worktrees and submodules have a .git file pointing at the repository
+				data, err := os.ReadFile(gitDir)
+				if err != nil {
+					return ""
+				}
+				target, ok := strings.CutPrefix(strings.TrimSpace(string(data)), "gitdir: ")
+				if !ok {
+					return ""
+				}
+				if !filepath.IsAbs(target) {
+					target = filepath.Join(dir, target)
+				}
+				gitDir = target
+			}
+			head, err := os.ReadFile(filepath.Join(gitDir, "HEAD"))
+			if err != nil {
+				return ""
+			}
+			ref := strings.TrimSpace(string(head))
+			if branch, ok := strings.CutPrefix(ref, "ref: refs/heads/"); ok {
+				return branch
+			}
+			return ref[:min(7, len(ref))]
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
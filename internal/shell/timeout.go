@@ -0,0 +1,130 @@
+package shell
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ** Timeouts **
+// ------------------------------------------------------------------------------------------
+
+// Status of a timeout group whose time ran out, as timeout(1) exits with
+const timeoutStatus = 124
+
+// Cancellation scope of a timeout group. Once its time runs out, or Ctrl+C is pressed, the command running
+// in it is stopped and the rest of the group, nested groups included, is skipped
+type cancelScope struct {
+	status atomic.Int32 // status the group ends with once cancelled, 0 until then
+	parent *cancelScope // scope of the timeout group it is nested in, nil at the top
+}
+
+// Whether the scope, or one it is nested in, was cancelled: what is left of the group in it doesn't run
+func (scope *cancelScope) cancelled() bool {
+	for ; scope != nil; scope = scope.parent {
+		if scope.status.Load() != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Whether the scope is outer or nested in it
+func (scope *cancelScope) within(outer *cancelScope) bool {
+	for ; scope != nil; scope = scope.parent {
+		if scope == outer {
+			return true
+		}
+	}
+	return false
+}
+
+// Scopes of the timeout groups being run, the signal handler cancels them from another goroutine
+type cancelScopes struct {
+	mu     sync.Mutex
+	scopes []*cancelScope
+}
+
+// Opens a scope for a group being run in the parent scope, until close is called
+func (cs *cancelScopes) open(parent *cancelScope) (scope *cancelScope, close func()) {
+	scope = &cancelScope{parent: parent}
+	cs.mu.Lock()
+	cs.scopes = append(cs.scopes, scope)
+	cs.mu.Unlock()
+	return scope, func() {
+		cs.mu.Lock()
+		// The stages of a pipeline open and close their scopes concurrently, not necessarily innermost first
+		cs.scopes = slices.DeleteFunc(cs.scopes, func(open *cancelScope) bool { return open == scope })
+		cs.mu.Unlock()
+	}
+}
+
+// Cancels every open scope that isn't cancelled yet with a status
+func (cs *cancelScopes) cancel(status int) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	for _, scope := range cs.scopes {
+		scope.status.CompareAndSwap(0, int32(status))
+	}
+}
+
+// timeout DURATION { commands; }, running the group in a scope cancelled after the duration
+type timeoutCommand struct {
+	duration string
+	body     block
+}
+
+// Runs a timeout group. When the time runs out the foreground command gets SIGTERM, the commands after it
+// don't run and the status is 124. An invalid duration fails with 125, as with timeout(1)
+func (s *Shell) runTimeout(compound *timeoutCommand, std Streams) error {
+	word := compound.duration
+	duration, err := parseInterval(strings.ReplaceAll(s.expandCompoundWord(word), globMark, ""))
+	if err != nil {
+		s.status = 125
+		return err
+	}
+
+	scope, close := s.scopes.open(std.scope)
+	defer close()
+	timer := time.AfterFunc(duration, func() {
+		if scope.status.CompareAndSwap(0, timeoutStatus) {
+			s.jobs.terminate(scope)
+		}
+	})
+	std.scope = scope
+	err = s.runBlock(compound.body, std)
+	timer.Stop()
+	if status := scope.status.Load(); status != 0 {
+		s.status = int(status)
+		return ExitStatus(status)
+	}
+	return err
+}
+
+// Time interval as timeout(1) takes it: a number of seconds, fractions allowed, or of minutes, hours or days
+// with an m, h or d suffix
+func parseInterval(text string) (time.Duration, error) {
+	unit := time.Second
+	number := text
+	if n := len(text); n > 0 {
+		switch text[n-1] {
+		case 's':
+			number = text[:n-1]
+		case 'm':
+			unit, number = time.Minute, text[:n-1]
+		case 'h':
+			unit, number = time.Hour, text[:n-1]
+		case 'd':
+			unit, number = 24*time.Hour, text[:n-1]
+		}
+	}
+	value, err := strconv.ParseFloat(number, 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("timeout: invalid time interval '%s'", text)
+	}
+	return time.Duration(value * float64(unit)), nil
+}
@@ -0,0 +1,638 @@
+package shell
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ** Variables **
+// ------------------------------------------------------------------------------------------
+
+type Variable struct {
+	value    string
+	array    []string
+	isArray  bool
+	integer  bool
+	exported bool
+	readonly bool
+}
+
+// Imports the process environment as exported shell variables
+func (s *Shell) initVariables() {
+	for _, kv := range os.Environ() {
+		name, value, ok := strings.Cut(kv, "=")
+		if ok && isValidName(name) {
+			s.vars[name] = &Variable{value: value, exported: true}
+		}
+	}
+}
+
+// Special parameters, which $ expands though they aren't valid names. Positional parameters past $9 take
+// braces, ${10}
+const specialParameters = "-0?$!#@*123456789"
+
+// Whether name is a positional parameter, a number from 1
+func isPositional(name string) bool {
+	n, err := strconv.Atoi(name)
+	return err == nil && n > 0 && name[0] != '+'
+}
+
+// Whether name is an array element reference, name[subscript]
+func isElement(name string) bool {
+	base, subscript, ok := strings.Cut(name, "[")
+	return ok && isValidName(base) && len(subscript) > 1 && strings.HasSuffix(subscript, "]")
+}
+
+// Value of a shell variable, the first element for arrays, or of an element reference. Special parameters are
+// computed on lookup
+func (s *Shell) getVar(name string) (string, bool) {
+	if isElement(name) {
+		base, subscript, _ := strings.Cut(name, "[")
+		return s.getElement(base, strings.TrimSuffix(subscript, "]"))
+	}
+	switch name {
+	case "-":
+		return s.flags(), true
+	case "0":
+		return s.name, true
+	case "?":
+		return strconv.Itoa(s.status), true
+	case "$":
+		return strconv.Itoa(os.Getpid()), true
+	case "!":
+		if s.background == 0 {
+			return "", false
+		}
+		return strconv.Itoa(s.background), true
+	case "#":
+		return strconv.Itoa(len(s.positional)), true
+	case "@", "*":
+		return strings.Join(s.positional, " "), len(s.positional) > 0
+	}
+	if isPositional(name) {
+		n, _ := strconv.Atoi(name)
+		if n > len(s.positional) {
+			return "", false
+		}
+		return s.positional[n-1], true
+	}
+
+	v, exists := s.vars[name]
+	if !exists {
+		return "", false
+	}
+	if v.isArray {
+		if len(v.array) == 0 {
+			return "", true
+		}
+		return v.array[0], true
+	}
+	return v.value, true
+}
+
+// Value of ${name[subscript]}: the element at the index the arithmetic subscript evaluates to, counted from
+// the end when negative, or every element joined by spaces for @ and *
+func (s *Shell) getElement(name, subscript string) (string, bool) {
+	elements := s.arrayElements(name)
+	if subscript == "@" || subscript == "*" {
+		return strings.Join(elements, " "), len(elements) > 0
+	}
+	i, err := s.evalArithmetic(subscript)
+	if err != nil {
+		return "", false
+	}
+	if i < 0 {
+		i += len(elements)
+	}
+	if i < 0 || i >= len(elements) {
+		return "", false
+	}
+	return elements[i], true
+}
+
+// Elements of an array variable. A scalar is an array of one element, an unset variable one of none
+func (s *Shell) arrayElements(name string) []string {
+	v, exists := s.vars[name]
+	switch {
+	case !exists:
+		return nil
+	case v.isArray:
+		return v.array
+	}
+	return []string{v.value}
+}
+
+// Assigns a shell variable, creating it if needed. Integer variables evaluate the value arithmetically
+func (s *Shell) setVar(name, value string) error {
+	v, exists := s.vars[name]
+	if !exists {
+		v = &Variable{}
+		s.vars[name] = v
+	}
+	if v.readonly {
+		return fmt.Errorf("%s: readonly variable", name)
+	}
+	if v.integer {
+		n, err := s.evalArithmetic(value)
+		if err != nil {
+			return err
+		}
+		value = strconv.Itoa(n)
+	}
+	if v.isArray {
+		if len(v.array) == 0 {
+			v.array = []string{value}
+		} else {
+			v.array[0] = value
+		}
+		return nil
+	}
+	v.value = value
+	return nil
+}
+
+// Assigns elements to an array variable, creating it or turning a scalar into an array
+func (s *Shell) setArray(name string, elements []string) error {
+	v, exists := s.vars[name]
+	if !exists {
+		v = &Variable{}
+		s.vars[name] = v
+	}
+	if v.readonly {
+		return fmt.Errorf("%s: readonly variable", name)
+	}
+	v.isArray, v.value = true, ""
+	v.array = append([]string{}, elements...)
+	return nil
+}
+
+// Variables overridden for the duration of one command, with the state to roll back to
+type VarScope struct {
+	shell *Shell
+	saved map[string]*Variable // nil when the variable didn't exist
+}
+
+// Starts a scope, the caller defers restore so the variables come back even when the command fails or panics
+func (s *Shell) newScope() *VarScope {
+	return &VarScope{shell: s, saved: make(map[string]*Variable)}
+}
+
+// Saves the state of a variable to roll back to, the first time it is changed in the scope
+func (sc *VarScope) save(name string) {
+	if _, saved := sc.saved[name]; saved {
+		return
+	}
+	if v, exists := sc.shell.vars[name]; exists {
+		snapshot := *v
+		snapshot.array = append([]string(nil), v.array...)
+		sc.saved[name] = &snapshot
+	} else {
+		sc.saved[name] = nil
+	}
+}
+
+// Sets a variable inside the scope, exported when export is set
+func (sc *VarScope) set(name, value string, export bool) error {
+	sc.save(name)
+	if err := sc.shell.setVar(name, value); err != nil {
+		return err
+	}
+	if export {
+		sc.shell.vars[name].exported = true
+	}
+	return nil
+}
+
+// Rolls every variable set in the scope back to its state when it was first set
+func (sc *VarScope) restore() {
+	for name, v := range sc.saved {
+		if v == nil {
+			delete(sc.shell.vars, name)
+		} else {
+			sc.shell.vars[name] = v
+		}
+	}
+}
+
+// Applies NAME=value assignments exported in a new scope. The scope is returned even on error so it can be restored
+func (s *Shell) assignScoped(assignments []string) (*VarScope, error) {
+	scope := s.newScope()
+	for _, assignment := range assignments {
+		name, value, _ := strings.Cut(assignment, "=")
+		if err := scope.set(name, value, true); err != nil {
+			return scope, err
+		}
+	}
+	return scope, nil
+}
+
+// Applies the NAME=value assignments of a command line without a command, they last for the session
+func (s *Shell) assign(assignments []string) error {
+	for _, assignment := range assignments {
+		name, value, _ := strings.Cut(assignment, "=")
+		if err := s.setVar(name, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Environment for external commands, built from the exported variables
+func (s *Shell) environ() []string {
+	env := []string{}
+	for name, v := range s.vars {
+		if v.exported {
+			value, _ := s.getVar(name)
+			env = append(env, name+"="+value)
+		}
+	}
+	sort.Strings(env)
+	return env
+}
+
+// Environment with NAME=value assignments added, replacing the entries they override
+func withAssignments(env []string, assignments []string) []string {
+	for _, assignment := range assignments {
+		name, _, _ := strings.Cut(assignment, "=")
+		env = slices.DeleteFunc(env, func(entry string) bool {
+			return strings.HasPrefix(entry, name+"=")
+		})
+		env = append(env, assignment)
+	}
+	return env
+}
+
+// Whether name is a valid variable identifier
+func isValidName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, c := range name {
+		if c != '_' && !(c >= 'a' && c <= 'z') && !(c >= 'A' && c <= 'Z') && !(i > 0 && c >= '0' && c <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// Attribute letters of a variable in the order bash prints them, -- when it has none
+func (v *Variable) flags() string {
+	flags := ""
+	if v.isArray {
+		flags += "a"
+	}
+	if v.integer {
+		flags += "i"
+	}
+	if v.readonly {
+		flags += "r"
+	}
+	if v.exported {
+		flags += "x"
+	}
+	if flags == "" {
+		return "-"
+	}
+	return flags
+}
+
+// Whether the variable has every attribute in flags
+func (v *Variable) hasFlags(flags string) bool {
+	for _, f := range flags {
+		if !strings.ContainsRune(v.flags(), f) {
+			return false
+		}
+	}
+	return true
+}
+
+// Quotes a value for output that can be read back by the shell
+func quoteValue(value string) string {
+	var quoted strings.Builder
+	quoted.WriteByte('"')
+	for _, c := range value {
+		if strings.ContainsRune("\"\\$`", c) {
+			quoted.WriteByte('\\')
+		}
+		quoted.WriteRune(c)
+	}
+	quoted.WriteByte('"')
+	return quoted.String()
+}
+
+// Formats a variable as a declare statement that recreates it
+func (s *Shell) formatDeclare(name string) string {
+	v := s.vars[name]
+	if v.isArray {
+		elements := []string{}
+		for i, element := range v.array {
+			elements = append(elements, fmt.Sprintf("[%d]=%s", i, quoteValue(element)))
+		}
+		return fmt.Sprintf("declare -%s %s=(%s)", v.flags(), name, strings.Join(elements, " "))
+	}
+	return fmt.Sprintf("declare -%s %s=%s", v.flags(), name, quoteValue(v.value))
+}
+
+// Formats a variable as an assignment for set, values are quoted only when they need to be
+func (s *Shell) formatSet(name string) string {
+	v := s.vars[name]
+	if v.isArray {
+		elements := []string{}
+		for i, element := range v.array {
+			elements = append(elements, fmt.Sprintf("[%d]=%s", i, quoteValue(element)))
+		}
+		return fmt.Sprintf("%s=(%s)", name, strings.Join(elements, " "))
+	}
+	if v.value == "" || strings.ContainsAny(v.value, " \t\n\"'\\$`|&;<>()*?[]#~!{}") {
+		return name + "=" + quoteValue(v.value)
+	}
+	return name + "=" + v.value
+}
+
+// Shell builtin declare (and typeset): -i integer, -x export, -r readonly, -a array, -p print. +attr removes an attribute
+func (s *Shell) declare(args []string, std Streams) error {
+	return s.declareIn("declare", nil, args, std)
+}
+
+// Shell builtin local, declare for variables that belong to the function being run: the ones it names are
+// restored, or unset, when the function returns. Without names, lists the function's local variables
+func (s *Shell) local(args []string, std Streams) error {
+	if len(s.locals) == 0 {
+		return fmt.Errorf("local: can only be used in a function")
+	}
+	return s.declareIn("local", s.locals[len(s.locals)-1], args, std)
+}
+
+// declare run as builtin, the variables it changes saved in scope first when scope isn't nil. Listing without
+// names is limited to the scope's variables then
+func (s *Shell) declareIn(builtin string, scope *VarScope, args []string, std Streams) error {
+	var add, remove string
+	print := false
+
+	i := 0
+	for ; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			i++
+			break
+		}
+		if len(arg) < 2 || (arg[0] != '-' && arg[0] != '+') {
+			break
+		}
+		for _, f := range arg[1:] {
+			switch {
+			case f == 'p':
+				print = true
+			case strings.ContainsRune("airx", f) && arg[0] == '-':
+				add += string(f)
+			case strings.ContainsRune("airx", f):
+				remove += string(f)
+			default:
+				return fmt.Errorf("%s: %s: invalid option\n%s: usage: %s", builtin, arg, builtin, builtinDocs[builtin].Usage)
+			}
+		}
+	}
+	words := joinArrayWords(args[i:])
+
+	if len(words) == 0 {
+		names := []string{}
+		for name, v := range s.vars {
+			if !v.hasFlags(add) {
+				continue
+			}
+			if scope != nil {
+				if _, local := scope.saved[name]; !local {
+					continue
+				}
+			}
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintln(std.stdout, s.formatDeclare(name))
+		}
+		return nil
+	}
+
+	var errs []string
+	for _, word := range words {
+		name, value, hasValue := strings.Cut(word, "=")
+		if !isValidName(name) {
+			errs = append(errs, fmt.Sprintf("%s: '%s': not a valid identifier", builtin, strings.ReplaceAll(word, arraySeparator, " ")))
+			continue
+		}
+
+		if print {
+			if _, exists := s.vars[name]; !exists {
+				errs = append(errs, fmt.Sprintf("%s: %s: not found", builtin, name))
+				continue
+			}
+			fmt.Fprintln(std.stdout, s.formatDeclare(name))
+			continue
+		}
+
+		if scope != nil {
+			scope.save(name)
+		}
+		if err := s.declareVar(name, value, hasValue, add, remove); err != nil {
+			errs = append(errs, builtin+": "+err.Error())
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "\n"))
+	}
+	return nil
+}
+
+// Applies attribute changes and an optional assignment to a variable, readonly is applied last so the assignment still succeeds
+func (s *Shell) declareVar(name, value string, hasValue bool, add, remove string) error {
+	v, exists := s.vars[name]
+	if !exists {
+		v = &Variable{}
+		s.vars[name] = v
+	}
+	if v.readonly && (hasValue || remove != "") {
+		return fmt.Errorf("%s: readonly variable", name)
+	}
+	if strings.ContainsRune(remove, 'r') {
+		return fmt.Errorf("%s: readonly variable", name)
+	}
+
+	if strings.ContainsRune(add, 'a') && !v.isArray {
+		v.isArray = true
+		v.array = []string{}
+		if v.value != "" {
+			v.array = append(v.array, v.value)
+		}
+		v.value = ""
+	}
+	if strings.ContainsRune(remove, 'a') && v.isArray {
+		return fmt.Errorf("%s: cannot destroy array variables in this way", name)
+	}
+	v.integer = (v.integer || strings.ContainsRune(add, 'i')) && !strings.ContainsRune(remove, 'i')
+	v.exported = (v.exported || strings.ContainsRune(add, 'x')) && !strings.ContainsRune(remove, 'x')
+
+	if hasValue {
+		if v.isArray && strings.HasPrefix(value, "(") && strings.HasSuffix(value, ")") {
+			v.array = []string{}
+			for _, element := range arrayElementWords(value[1 : len(value)-1]) {
+				if v.integer {
+					n, err := s.evalArithmetic(element)
+					if err != nil {
+						return err
+					}
+					element = strconv.Itoa(n)
+				}
+				v.array = append(v.array, element)
+			}
+		} else if err := s.setVar(name, strings.ReplaceAll(value, arraySeparator, " ")); err != nil {
+			return err
+		}
+	}
+
+	if strings.ContainsRune(add, 'r') {
+		v.readonly = true
+	}
+	return nil
+}
+
+// Shell builtin export: marks variables for the environment of commands, assigning those given a value.
+// -n unexports instead, without names (or with -p) the exported variables are listed
+func (s *Shell) export(args []string, std Streams) error {
+	remove := false
+	i := 0
+	for ; i < len(args) && strings.HasPrefix(args[i], "-") && len(args[i]) > 1; i++ {
+		if args[i] == "--" {
+			i++
+			break
+		}
+		for _, f := range args[i][1:] {
+			switch f {
+			case 'n':
+				remove = true
+			case 'p':
+			default:
+				return fmt.Errorf("export: %s: invalid option\nexport: usage: export [-n] [-p] [name[=value] ...]", args[i])
+			}
+		}
+	}
+	words := joinArrayWords(args[i:])
+
+	if len(words) == 0 {
+		return s.declare([]string{"-x"}, std)
+	}
+
+	var errs []string
+	for _, word := range words {
+		name, value, hasValue := strings.Cut(word, "=")
+		if !isValidName(name) {
+			errs = append(errs, fmt.Sprintf("export: '%s': not a valid identifier", strings.ReplaceAll(word, arraySeparator, " ")))
+			continue
+		}
+		var err error
+		if remove {
+			err = s.declareVar(name, value, hasValue, "", "x")
+		} else {
+			err = s.declareVar(name, value, hasValue, "x", "")
+		}
+		if err != nil {
+			errs = append(errs, "export: "+err.Error())
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "\n"))
+	}
+	return nil
+}
+
+// Shell builtin unset: removes variables, or functions with -f. Without -f or -v a name that isn't a
+// variable names a function. Readonly variables stay
+func (s *Shell) unset(args []string, std Streams) error {
+	functions, variables := false, false
+	i := 0
+	for ; i < len(args) && strings.HasPrefix(args[i], "-") && len(args[i]) > 1; i++ {
+		if args[i] == "--" {
+			i++
+			break
+		}
+		for _, f := range args[i][1:] {
+			switch f {
+			case 'f':
+				functions = true
+			case 'v':
+				variables = true
+			default:
+				return fmt.Errorf("unset: %s: invalid option\nunset: usage: %s", args[i], builtinDocs["unset"].Usage)
+			}
+		}
+	}
+	if functions && variables {
+		return fmt.Errorf("unset: cannot simultaneously unset a function and a variable")
+	}
+
+	var errs []string
+	for _, name := range args[i:] {
+		if !isValidName(name) {
+			errs = append(errs, fmt.Sprintf("unset: '%s': not a valid identifier", name))
+			continue
+		}
+		v, isVariable := s.vars[name]
+		switch {
+		case functions || !variables && !isVariable:
+			delete(s.functions, name)
+		case v.readonly:
+			errs = append(errs, fmt.Sprintf("unset: %s: cannot unset: readonly variable", name))
+		default:
+			delete(s.vars, name)
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "\n"))
+	}
+	return nil
+}
+
+// Elements of the list between the parentheses of an array assignment: the words joinArrayWords rejoined, or
+// the fields of a list given as a single word
+func arrayElementWords(list string) []string {
+	if !strings.Contains(list, arraySeparator) {
+		return strings.Fields(list)
+	}
+	elements := strings.Split(list, arraySeparator)
+	if elements[0] == "" {
+		elements = elements[1:]
+	}
+	if len(elements) > 0 && elements[len(elements)-1] == "" {
+		elements = elements[:len(elements)-1]
+	}
+	return elements
+}
+
+// Separates the words of a rejoined array assignment, a word can't hold it
+const arraySeparator = "\x00"
+
+// Rejoins array assignments that the parser split on spaces, name=(a b c) arrives as "name=(a", "b", "c)".
+// The words are joined with arraySeparator so quoted elements keep their spaces
+func joinArrayWords(args []string) []string {
+	words := []string{}
+	for i := 0; i < len(args); i++ {
+		word := args[i]
+		if strings.Contains(word, "=(") && !strings.HasSuffix(word, ")") {
+			for i+1 < len(args) {
+				i++
+				word += arraySeparator + args[i]
+				if strings.HasSuffix(args[i], ")") {
+					break
+				}
+			}
+		}
+		words = append(words, word)
+	}
+	return words
+}